@@ -0,0 +1,25 @@
+package pushstate
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// legacyCheckSum returns the check-sum of m in the legacy algorithm of its entry at key, and false
+// when the entry has the cache's own algorithm or one that is not registered; the caller must hold
+// the lock
+func (fc *FileCache) legacyCheckSum(key string, m PushModel) (string, bool) {
+	algorithm := fc.entries.meta(key).Algorithm
+	if algorithm == fc.algorithm {
+		return "", false
+	}
+	cs, ok := fc.legacy[algorithm]
+	if !ok {
+		return "", false
+	}
+	sum, err := fc.computeCheckSumWith(cs, m)
+	if err != nil {
+		// Like makeCheckSum, an empty check-sum never matches and reports the model as changed
+		return "", true
+	}
+	return sum, true
+}
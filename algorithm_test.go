@@ -0,0 +1,70 @@
+package pushstate
+
+import (
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// prefixCheckSum is a new algorithm for the tests: the Murmur3 check-sum with a prefix
+type prefixCheckSum struct {
+	checksum.Murmur3CheckSum
+}
+
+func (p *prefixCheckSum) SumString(str string) string {
+	return "v2:" + p.Murmur3CheckSum.SumString(str)
+}
+
+func (p *prefixCheckSum) SumBytes(b []byte) string {
+	return "v2:" + p.Murmur3CheckSum.SumBytes(b)
+}
+
+func TestWithLegacyCheckSum(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantChanged bool
+	}{
+		{name: "not registered", opts: []Option{WithAlgorithmName("v2")}, wantChanged: true},
+		{name: "registered", opts: []Option{WithAlgorithmName("v2"), WithLegacyCheckSum("", &checksum.Murmur3CheckSum{})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newTestCache(t)
+			m := &testModel{ID: "a", Payload: "1"}
+			old.Put(m)
+			if err := old.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+
+			fc := NewFileCache(old.filename, &prefixCheckSum{}, nil, tt.opts...)
+			if err := fc.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if changed := fc.IsChanged(m); changed != tt.wantChanged {
+				t.Errorf("IsChanged is %t, expected %t", changed, tt.wantChanged)
+			}
+			changed, err := fc.FilterChanged([]PushModel{m})
+			if err != nil || (len(changed) == 1) != tt.wantChanged {
+				t.Errorf("FilterChanged returned %v and %v, expected a change %t", changed, err, tt.wantChanged)
+			}
+
+			// A put upgrades the entry to the new algorithm
+			fc.Put(m)
+			if err = fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			c := NewFileCache(old.filename, &prefixCheckSum{}, nil, WithAlgorithmName("v2"))
+			if err = c.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if c.IsChanged(m) || c.entries.meta("a").Algorithm != "v2" {
+				t.Errorf("the entry has algorithm %q after a put, expected v2", c.entries.meta("a").Algorithm)
+			}
+		})
+	}
+}
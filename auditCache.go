@@ -0,0 +1,170 @@
+package pushstate
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// AuditCache is a Cacher that writes an audit line for every change to the wrapped Cacher.
+// Each line is a JSON object with the time, the operation and the id, if any, and the new check-sum
+// of a put, so the stream can be replayed with ReplayAudit.
+type AuditCache struct {
+	cacher     Cacher
+	w          io.Writer
+	auditReads bool
+	now        func() time.Time
+	err        error
+	// Protect the writer
+	auditLock sync.Mutex
+}
+
+// AuditOption configures an AuditCache
+type AuditOption func(*AuditCache)
+
+// WithAuditReads makes the AuditCache also write audit lines for IsChanged, Get, Size, Dump and WriteTo
+func WithAuditReads() AuditOption {
+	return func(ac *AuditCache) {
+		ac.auditReads = true
+	}
+}
+
+// AuditRecord is one line in the audit stream
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	ID   string    `json:"id,omitempty"`
+	// Checksum is the check-sum put by a put
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// NewAuditCache wraps c and writes the audit lines to w
+func NewAuditCache(c Cacher, w io.Writer, opts ...AuditOption) *AuditCache {
+	ac := &AuditCache{
+		cacher: c,
+		w:      w,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(ac)
+	}
+	return ac
+}
+
+// Err returns the first error from writing to the audit stream
+func (ac *AuditCache) Err() error {
+	ac.auditLock.Lock()
+	defer ac.auditLock.Unlock()
+
+	return ac.err
+}
+
+func (ac *AuditCache) audit(op string, id string) {
+	ac.auditRecord(&AuditRecord{Op: op, ID: id})
+}
+
+func (ac *AuditCache) auditRecord(rec *AuditRecord) {
+	ac.auditLock.Lock()
+	defer ac.auditLock.Unlock()
+
+	rec.Time = ac.now().UTC()
+	b, err := json.Marshal(rec)
+	if err == nil {
+		_, err = ac.w.Write(append(b, '\n'))
+	}
+	if err != nil && ac.err == nil {
+		ac.err = err
+	}
+}
+
+func (ac *AuditCache) auditRead(op string, id string) {
+	if ac.auditReads {
+		ac.audit(op, id)
+	}
+}
+
+// IsChanged checks if the model is new or changed
+func (ac *AuditCache) IsChanged(m PushModel) bool {
+	ac.auditRead("is-changed", m.GetID())
+	return ac.cacher.IsChanged(m)
+}
+
+// Put puts the model's check-sum in the cache, and audits the check-sum the wrapped Cacher stored.
+// A put a FileCache or a section refused, e.g. of a denied id or after Drain, is not audited. Other
+// Cachers do not tell whether they stored the check-sum, so for them the check-sum Get returns after
+// the put is audited, which is the old one after a refused put.
+func (ac *AuditCache) Put(m PushModel) {
+	if cs, ok := ac.put(m); ok {
+		ac.auditRecord(&AuditRecord{Op: "put", ID: m.GetID(), Checksum: cs})
+	}
+}
+
+// put puts the model in the wrapped Cacher, and returns the check-sum it stored and whether it stored
+// one
+func (ac *AuditCache) put(m PushModel) (string, bool) {
+	if p, ok := ac.cacher.(interface {
+		putChecksum(m PushModel) (string, bool)
+	}); ok {
+		return p.putChecksum(m)
+	}
+	ac.cacher.Put(m)
+	cs := ac.cacher.Get(m.GetID())
+	return cs, cs != ""
+}
+
+// Read reads the check-sums from persistent storage
+func (ac *AuditCache) Read() error {
+	return ac.cacher.Read()
+}
+
+// Save saves the check-sums to persistent storage
+func (ac *AuditCache) Save() error {
+	return ac.cacher.Save()
+}
+
+// Size returns the number of check-sums
+func (ac *AuditCache) Size() int64 {
+	ac.auditRead("size", "")
+	return ac.cacher.Size()
+}
+
+// Get returns the check-sum for the given id
+func (ac *AuditCache) Get(id string) string {
+	ac.auditRead("get", id)
+	return ac.cacher.Get(id)
+}
+
+// Delete deletes the check-sum for the given id
+func (ac *AuditCache) Delete(id string) error {
+	if err := ac.cacher.Delete(id); err != nil {
+		return err
+	}
+	ac.audit("delete", id)
+	return nil
+}
+
+// Reset empties the cache
+func (ac *AuditCache) Reset() error {
+	if err := ac.cacher.Reset(); err != nil {
+		return err
+	}
+	ac.audit("reset", "")
+	return nil
+}
+
+// Dump dumps the whole content to an io.Reader
+func (ac *AuditCache) Dump() (io.Reader, error) {
+	ac.auditRead("dump", "")
+	return ac.cacher.Dump()
+}
+
+// WriteTo writes the whole content to w
+func (ac *AuditCache) WriteTo(w io.Writer) (int64, error) {
+	ac.auditRead("write-to", "")
+	return ac.cacher.WriteTo(w)
+}
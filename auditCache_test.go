@@ -0,0 +1,165 @@
+package pushstate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// auditOps returns the operations of the audit lines in b
+func auditOps(t *testing.T, b []byte) []string {
+	t.Helper()
+	var ops []string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		rec := AuditRecord{}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("audit line %q is not JSON; error = %v", sc.Text(), err)
+		}
+		ops = append(ops, rec.Op)
+	}
+	return ops
+}
+
+func TestAuditCache(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []AuditOption
+		want []string
+	}{
+		{name: "changes", want: []string{"put", "delete", "reset"}},
+		{name: "reads", opts: []AuditOption{WithAuditReads()},
+			want: []string{"is-changed", "put", "get", "size", "delete", "reset"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			ac := NewAuditCache(newTestCache(t), buf, tt.opts...)
+			m := &testModel{ID: "a", Payload: "1"}
+			_ = ac.IsChanged(m)
+			ac.Put(m)
+			_ = ac.Get("a")
+			_ = ac.Size()
+			if err := ac.Delete("a"); err != nil {
+				t.Fatalf("Delete failed; error = %v", err)
+			}
+			if err := ac.Reset(); err != nil {
+				t.Fatalf("Reset failed; error = %v", err)
+			}
+			if ops := auditOps(t, buf.Bytes()); !reflect.DeepEqual(ops, tt.want) {
+				t.Errorf("the audit operations are %v, expected %v", ops, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditCacheErr(t *testing.T) {
+	ac := NewAuditCache(newTestCache(t), failingWriter{})
+	ac.Put(&testModel{ID: "a", Payload: "1"})
+	if ac.Err() == nil {
+		t.Error("Err is nil after a failed audit write")
+	}
+	if ac.Get("a") == "" {
+		t.Error("a failed audit write stopped the put")
+	}
+}
+
+func TestAuditCacheRefusedPut(t *testing.T) {
+	tests := []struct {
+		name    string
+		cacher  func(fc *FileCache) Cacher
+		opts    []Option
+		prepare func(fc *FileCache)
+	}{
+		{name: "denied", opts: []Option{WithDenyList("a")}},
+		{name: "too long", opts: []Option{WithMaxChecksumLen(4)}, prepare: func(fc *FileCache) {
+			fc.PutRaw("a", "old")
+		}},
+		{name: "draining", prepare: func(fc *FileCache) {
+			fc.PutRaw("a", "old")
+			if err := fc.Drain(context.Background()); err != nil {
+				t.Fatalf("Drain failed; error = %v", err)
+			}
+		}},
+		{name: "draining section", cacher: func(fc *FileCache) Cacher { return fc.Section("s") },
+			prepare: func(fc *FileCache) {
+				fc.Section("s").Put(&testModel{ID: "a", Payload: "1"})
+				if err := fc.Drain(context.Background()); err != nil {
+					t.Fatalf("Drain failed; error = %v", err)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			if tt.prepare != nil {
+				tt.prepare(fc)
+			}
+			var c Cacher = fc
+			if tt.cacher != nil {
+				c = tt.cacher(fc)
+			}
+			buf := &bytes.Buffer{}
+			ac := NewAuditCache(c, buf)
+			ac.Put(&testModel{ID: "a", Payload: "2"})
+			if ops := auditOps(t, buf.Bytes()); len(ops) != 0 {
+				t.Errorf("a refused put was audited as %v", ops)
+			}
+		})
+	}
+}
+
+func TestAuditCachePutChecksum(t *testing.T) {
+	fc := newTestCache(t)
+	buf := &bytes.Buffer{}
+	ac := NewAuditCache(fc, buf)
+	m := &testModel{ID: "a", Payload: "1"}
+	ac.Put(m)
+	rec := AuditRecord{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("audit line %q is not JSON; error = %v", buf.String(), err)
+	}
+	if want := fc.Get("a"); rec.Op != "put" || rec.Checksum != want {
+		t.Errorf("audited %s with check-sum %q, expected put with %q", rec.Op, rec.Checksum, want)
+	}
+}
+
+func TestAuditCacheSetLogger(t *testing.T) {
+	fc := newTestCache(t, WithRejectEmptyID())
+	buf := &bytes.Buffer{}
+	ac := NewAuditCache(fc, buf)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ac.Put(&testModel{})
+		}()
+		go func() {
+			defer wg.Done()
+			fc.SetLogger(zap.NewNop().Sugar())
+		}()
+	}
+	wg.Wait()
+	if ops := auditOps(t, buf.Bytes()); len(ops) != 0 {
+		t.Errorf("a refused put was audited as %v", ops)
+	}
+}
@@ -0,0 +1,67 @@
+package pushstate
+
+import (
+	"math/rand"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// autoSaver saves the cache in the background until it is stopped by Close
+type autoSaver struct {
+	every  time.Duration
+	jitter float64
+	rand   *rand.Rand
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// startAutoSave starts saving the cache every interval of WithAutoSave
+func (fc *FileCache) startAutoSave() {
+	as := fc.autoSave
+	// Seeded per cache, or caches started together would draw the same intervals
+	as.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	as.stop = make(chan struct{})
+	as.done = make(chan struct{})
+
+	go func() {
+		defer close(as.done)
+
+		timer := time.NewTimer(as.interval())
+		defer timer.Stop()
+		for {
+			select {
+			case <-as.stop:
+				return
+			case <-timer.C:
+				if err := fc.Save(); err != nil {
+					fc.warnw(fc.logger(), "auto-save failed", "file", fc.filename, "error", err)
+				}
+				timer.Reset(as.interval())
+			}
+		}
+	}()
+}
+
+// interval returns the time until the next save, the interval of WithAutoSave moved randomly by up to
+// the fraction of WithAutoSaveJitter in either direction
+func (as *autoSaver) interval() time.Duration {
+	if as.jitter <= 0 {
+		return as.every
+	}
+	return as.every + time.Duration(float64(as.every)*as.jitter*(2*as.rand.Float64()-1))
+}
+
+// Close stops the saving of WithAutoSave, and saves the cache a final time when it is dirty. A cache
+// without WithAutoSave is only saved, and Close may be called more than once.
+func (fc *FileCache) Close() error {
+	if as := fc.autoSave; as != nil && as.stop != nil {
+		fc.closeOnce.Do(func() {
+			close(as.stop)
+		})
+		<-as.done
+	}
+	return fc.Save()
+}
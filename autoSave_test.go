@@ -0,0 +1,101 @@
+package pushstate
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestAutoSaveJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		fraction float64
+		min, max time.Duration
+	}{
+		{name: "no jitter", fraction: 0, min: time.Minute, max: time.Minute},
+		{name: "ten percent", fraction: 0.1, min: 54 * time.Second, max: 66 * time.Second},
+		{name: "half", fraction: 0.5, min: 30 * time.Second, max: 90 * time.Second},
+		{name: "clamped above one", fraction: 3, min: 0, max: 2 * time.Minute},
+		{name: "clamped below zero", fraction: -1, min: time.Minute, max: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &FileCache{}
+			WithAutoSave(time.Minute)(fc)
+			WithAutoSaveJitter(tt.fraction)(fc)
+			fc.autoSave.rand = rand.New(rand.NewSource(1))
+
+			lo, hi := time.Duration(1<<62), time.Duration(0)
+			for i := 0; i < 1000; i++ {
+				d := fc.autoSave.interval()
+				if d < tt.min || d > tt.max {
+					t.Fatalf("interval %v is outside [%v, %v]", d, tt.min, tt.max)
+				}
+				if d < lo {
+					lo = d
+				}
+				if d > hi {
+					hi = d
+				}
+			}
+			if tt.min != tt.max && hi-lo < (tt.max-tt.min)/2 {
+				t.Errorf("the intervals %v to %v are not spread over [%v, %v]", lo, hi, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestAutoSave(t *testing.T) {
+	fc := newTestCache(t, WithAutoSave(10*time.Millisecond), WithAutoSaveJitter(0.5))
+	fc.Put(&testModel{ID: "a", Payload: "1"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fc.IsDirty() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the cache was not saved in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if onDisk(t, fc)["a"] == "" {
+		t.Errorf("a is not in the file after the auto-save")
+	}
+
+	fc.Put(&testModel{ID: "b", Payload: "1"})
+	if err := fc.Close(); err != nil {
+		t.Fatalf("Close failed; error = %v", err)
+	}
+	if onDisk(t, fc)["b"] == "" {
+		t.Errorf("Close did not save the cache")
+	}
+	if err := fc.Close(); err != nil {
+		t.Errorf("a second Close failed; error = %v", err)
+	}
+}
+
+func TestNewFileCacheEStartsNothingOnError(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		fc, err := NewFileCacheE(filepath.Join(notDir, "state.json"), &checksum.Murmur3CheckSum{}, nil,
+			WithValidatePath(), WithAutoSave(time.Hour))
+		if err == nil || fc != nil {
+			t.Fatalf("NewFileCacheE returned %v, %v for a path that is not a directory", fc, err)
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines were left running by failed NewFileCacheE calls", after-before)
+	}
+}
@@ -0,0 +1,225 @@
+package badgercache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/tkandal/checksum"
+	"github.com/tkandal/pushstate"
+	"go.uber.org/zap"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// BadgerCache is a pushstate.Cacher that keeps every id->check-sum as a key/value in a BadgerDB.
+// Put and Delete are single transactions, so there is nothing to read at start or to write at
+// Save beyond syncing the DB. The DB is owned by the caller, who must close it.
+type BadgerCache struct {
+	db       *badger.DB
+	checkSum checksum.CheckSum
+	log      *zap.SugaredLogger
+	ttl      time.Duration
+}
+
+// Option configures a BadgerCache
+type Option func(*BadgerCache)
+
+// WithTTL makes every check-sum expire d after it was put, using Badger's own TTL; an expired
+// check-sum is gone, so its model is changed again
+func WithTTL(d time.Duration) Option {
+	return func(bc *BadgerCache) {
+		bc.ttl = d
+	}
+}
+
+// NewBadgerCache creates a cache in db; a nil log is replaced by a nop logger
+func NewBadgerCache(db *badger.DB, cs checksum.CheckSum, log *zap.SugaredLogger, opts ...Option) *BadgerCache {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+	bc := &BadgerCache{db: db, checkSum: cs, log: log}
+	for _, opt := range opts {
+		opt(bc)
+	}
+	return bc
+}
+
+// IsChanged checks if the model is new or changed
+func (bc *BadgerCache) IsChanged(m pushstate.PushModel) bool {
+	cs, err := bc.makeCheckSum(m)
+	if err != nil {
+		bc.log.Errorf("check-sum of %s failed; error = %v", m.GetID(), err)
+		return true
+	}
+	return bc.Get(m.GetID()) != cs
+}
+
+// Put puts the model's check-sum in the DB
+func (bc *BadgerCache) Put(m pushstate.PushModel) {
+	cs, err := bc.makeCheckSum(m)
+	if err != nil {
+		bc.log.Errorf("check-sum of %s failed; error = %v", m.GetID(), err)
+		return
+	}
+	err = bc.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry([]byte(m.GetID()), []byte(cs))
+		if bc.ttl > 0 {
+			e = e.WithTTL(bc.ttl)
+		}
+		return txn.SetEntry(e)
+	})
+	if err != nil {
+		bc.log.Errorf("put %s failed; error = %v", m.GetID(), err)
+	}
+}
+
+// Read does nothing, since the DB already holds the check-sums
+func (bc *BadgerCache) Read() error {
+	return nil
+}
+
+// Save syncs the DB to disk
+func (bc *BadgerCache) Save() error {
+	if err := bc.db.Sync(); err != nil {
+		return fmt.Errorf("sync badger failed; error = %w", err)
+	}
+	return nil
+}
+
+// Size counts the check-sums in the DB, which leaves out the expired ones
+func (bc *BadgerCache) Size() int64 {
+	var n int64
+	err := bc.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		bc.log.Errorf("count check-sums failed; error = %v", err)
+	}
+	return n
+}
+
+// Get returns the check-sum for the id, or an empty string when there is none
+func (bc *BadgerCache) Get(id string) string {
+	var cs string
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(id))
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		cs = string(v)
+		return err
+	})
+	if err != nil && err != badger.ErrKeyNotFound {
+		bc.log.Errorf("get %s failed; error = %v", id, err)
+	}
+	return cs
+}
+
+// Delete deletes the check-sum for the id
+func (bc *BadgerCache) Delete(id string) error {
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("delete %s failed; error = %w", id, err)
+	}
+	return nil
+}
+
+// Reset deletes all the check-sums, and everything else in the DB
+func (bc *BadgerCache) Reset() error {
+	if err := bc.db.DropAll(); err != nil {
+		return fmt.Errorf("drop badger failed; error = %w", err)
+	}
+	return nil
+}
+
+// Dump streams the check-sums as a JSON object from id to check-sum
+func (bc *BadgerCache) Dump() (io.Reader, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := bc.WriteTo(pw)
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// WriteTo writes the check-sums to w as a JSON object from id to check-sum, sorted by id
+func (bc *BadgerCache) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	err := bc.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		sep := "{"
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			id, err := json.Marshal(string(item.Key()))
+			if err != nil {
+				return err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			cs, err := json.Marshal(string(v))
+			if err != nil {
+				return err
+			}
+			if _, err = fmt.Fprintf(bw, "%s%s:%s", sep, id, cs); err != nil {
+				return err
+			}
+			sep = ","
+		}
+		if sep == "{" {
+			_, err := bw.WriteString("{")
+			if err != nil {
+				return err
+			}
+		}
+		_, err := bw.WriteString("}\n")
+		return err
+	})
+	if err != nil {
+		return cw.n, fmt.Errorf("write check-sums failed; error = %w", err)
+	}
+	if err = bw.Flush(); err != nil {
+		return cw.n, fmt.Errorf("write check-sums failed; error = %w", err)
+	}
+	return cw.n, nil
+}
+
+func (bc *BadgerCache) makeCheckSum(v interface{}) (string, error) {
+	jsonBuf := &bytes.Buffer{}
+	if err := json.NewEncoder(jsonBuf).Encode(v); err != nil {
+		return "", err
+	}
+	return bc.checkSum.SumBytes(jsonBuf.Bytes()), nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
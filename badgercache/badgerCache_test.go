@@ -0,0 +1,72 @@
+package badgercache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/tkandal/checksum"
+	"github.com/tkandal/pushstate"
+	"github.com/tkandal/pushstate/pushstatetest"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// newTestCache creates a cache in an in-memory database that is closed when t ends
+func newTestCache(t *testing.T, opts ...Option) *BadgerCache {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("open badger failed; error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return NewBadgerCache(db, &checksum.Murmur3CheckSum{}, nil, opts...)
+}
+
+func TestRunCacherSuite(t *testing.T) {
+	pushstatetest.RunCacherSuite(t, func() pushstate.Cacher {
+		return newTestCache(t)
+	})
+}
+
+func TestStressTest(t *testing.T) {
+	cfg := pushstatetest.StressConfig{Cache: newTestCache(t), Workers: 4, Operations: 200, IDs: 20, Seed: 1}
+	if err := pushstatetest.StressTest(context.Background(), cfg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantExpiry bool
+	}{
+		{name: "no ttl"},
+		{name: "ttl", opts: []Option{WithTTL(time.Hour)}, wantExpiry: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestCache(t, tt.opts...)
+			bc.Put(&pushstatetest.Model{ID: "a", Payload: "1"})
+			err := bc.db.View(func(txn *badger.Txn) error {
+				item, err := txn.Get([]byte("a"))
+				if err != nil {
+					return err
+				}
+				if expires := item.ExpiresAt() > 0; expires != tt.wantExpiry {
+					t.Errorf("the check-sum expires %t, expected %t", expires, tt.wantExpiry)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("get a failed; error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package pushstate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// PutBatch puts the check-sums of all the models in the cache under a single lock.
+// The check-sums are computed before the lock is taken. When some of them fail, the others are still
+// put and a *BatchError lists the failures, unless WithBatchAbortOnError is set, in which case
+// nothing is put. Models with the same id are handled as WithBatchDuplicatePolicy says.
+func (fc *FileCache) PutBatch(models []PushModel) error {
+	sums, errs := fc.checkSums(models)
+	skip, dupErr := fc.duplicates(models, errs)
+	if dupErr != nil {
+		return dupErr
+	}
+	batchErr := newBatchError(models, errs)
+	if batchErr != nil && fc.batchAbort {
+		return batchErr
+	}
+
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return ErrDraining
+	}
+	for i, m := range models {
+		if errs[i] == nil && !skip[i] && fc.putCheckSum(fc.key(m.GetID()), sums[i]) {
+			fc.markDirty()
+		}
+	}
+	if batchErr != nil {
+		return batchErr
+	}
+	return nil
+}
+
+// FilterChanged returns the models that are new or changed, in the order they were given
+func (fc *FileCache) FilterChanged(models []PushModel) ([]PushModel, error) {
+	sums, errs := fc.checkSums(models)
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("check-sum of %s failed; error = %w", models[i].GetID(), err)
+		}
+	}
+
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	changed := make([]PushModel, 0, len(models))
+	for i, m := range models {
+		id, sum := fc.key(m.GetID()), sums[i]
+		if cs, ok := fc.legacyCheckSum(id, m); ok {
+			sum = cs
+		}
+		fc.touch(id)
+		isChanged, _ := fc.compareCheckSum(id, sum)
+		fc.stats.count(isChanged)
+		if isChanged {
+			changed = append(changed, m)
+		}
+	}
+	return changed, nil
+}
+
+// Compare compares the models with the cache in one locked pass, and returns the sorted ids of the
+// models that differ from their check-sums, of the models that have no check-sum, and of the
+// cached ids that are not among the models. The changed and missing ids are the ids of the models,
+// while the extra ids are as stored, see WithKeyTransform, since no model gives their id. Nothing in
+// the cache is changed; unlike IsChanged, it does not count towards WithChangeHysteresis and ignores
+// WithEntryTTL.
+func (fc *FileCache) Compare(models []PushModel) ([]string, []string, []string, error) {
+	sums, errs := fc.checkSums(models)
+	for i, err := range errs {
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("check-sum of %s failed; error = %w", models[i].GetID(), err)
+		}
+	}
+
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	var changed, missing, extra []string
+	given := make(map[string]bool, len(models))
+	for i, m := range models {
+		id := fc.key(m.GetID())
+		given[id] = true
+		old, ok := fc.entries.get(id)
+		if !ok {
+			missing = append(missing, m.GetID())
+			continue
+		}
+		sum := sums[i]
+		if cs, ok := fc.legacyCheckSum(id, m); ok {
+			sum = cs
+		}
+		if old != sum {
+			changed = append(changed, m.GetID())
+		}
+	}
+	fc.entries.each(func(id string, _ string) {
+		if !given[id] {
+			extra = append(extra, id)
+		}
+	})
+	sort.Strings(changed)
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return changed, missing, extra, nil
+}
+
+// checkSums computes the check-sums of the models, in parallel when configured to, and returns
+// them with the error for each model
+func (fc *FileCache) checkSums(models []PushModel) ([]string, []error) {
+	sums := make([]string, len(models))
+	errs := make([]error, len(models))
+
+	workers := fc.workers
+	if workers > len(models) {
+		workers = len(models)
+	}
+	if workers <= 1 {
+		for i, m := range models {
+			sums[i], errs[i] = fc.batchCheckSum(m)
+		}
+	} else {
+		indexes := make(chan int)
+		wg := sync.WaitGroup{}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range indexes {
+					sums[i], errs[i] = fc.batchCheckSum(models[i])
+				}
+			}()
+		}
+		for i := range models {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+	}
+
+	return sums, errs
+}
+
+// batchCheckSum is the check-sum of a model in a batch, or the error why it has none
+func (fc *FileCache) batchCheckSum(m PushModel) (string, error) {
+	if err := fc.checkID(m); err != nil {
+		return "", err
+	}
+	cs, err := fc.computeCheckSum(m)
+	if err != nil {
+		return "", err
+	}
+	if err = fc.checkLen(cs); err != nil {
+		return "", err
+	}
+	return cs, nil
+}
+
+// BatchFailure is the failure of one model in a batch
+type BatchFailure struct {
+	Index int
+	ID    string
+	Err   error
+}
+
+// DuplicatePolicy says what PutBatch does with models in a batch that have the same id
+type DuplicatePolicy int
+
+const (
+	// DuplicateLastWins puts the check-sum of the last model with the id
+	DuplicateLastWins DuplicatePolicy = iota
+	// DuplicateFirstWins puts the check-sum of the first model with the id
+	DuplicateFirstWins
+	// DuplicateError puts nothing and fails the batch with ErrDuplicateID
+	DuplicateError
+)
+
+// duplicates returns the indexes in models that PutBatch shall skip by the duplicate policy, or a
+// *BatchError with a failure for every repeated id when the policy is DuplicateError. A model whose
+// check-sum failed, by errs, never wins over another with the id, so the id keeps a check-sum when
+// any of its models has one.
+func (fc *FileCache) duplicates(models []PushModel, errs []error) (map[int]bool, error) {
+	seen := make(map[string]int, len(models))
+	var skip map[int]bool
+	var failures []BatchFailure
+	for i, m := range models {
+		if errs[i] != nil && fc.duplicatePolicy != DuplicateError {
+			continue
+		}
+		id := fc.key(m.GetID())
+		first, ok := seen[id]
+		if !ok {
+			seen[id] = i
+			continue
+		}
+		if skip == nil {
+			skip = make(map[int]bool)
+		}
+		switch fc.duplicatePolicy {
+		case DuplicateFirstWins:
+			skip[i] = true
+		case DuplicateError:
+			failures = append(failures, BatchFailure{Index: i, ID: m.GetID(),
+				Err: fmt.Errorf("%w; first at index %d", ErrDuplicateID, first)})
+		default:
+			skip[first] = true
+			seen[id] = i
+		}
+	}
+	if len(failures) > 0 {
+		return nil, &BatchError{Failures: failures}
+	}
+	return skip, nil
+}
+
+// BatchError lists the models in a batch that failed, by their index in the batch
+type BatchError struct {
+	Failures []BatchFailure
+}
+
+func newBatchError(models []PushModel, errs []error) *BatchError {
+	var failures []BatchFailure
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, BatchFailure{Index: i, ID: models[i].GetID(), Err: err})
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return &BatchError{Failures: failures}
+}
+
+func (e *BatchError) Error() string {
+	first := e.Failures[0]
+	return fmt.Sprintf("%d models in batch failed; first failure is %s at index %d; error = %v",
+		len(e.Failures), first.ID, first.Index, first.Err)
+}
+
+// Is reports whether the error of any failure is target, see errors.Is
+func (e *BatchError) Is(target error) bool {
+	for _, f := range e.Failures {
+		if errors.Is(f.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first failure whose error is assignable to target, and sets target to it, see errors.As
+func (e *BatchError) As(target interface{}) bool {
+	for _, f := range e.Failures {
+		if errors.As(f.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the errors of all the failures. Only Go 1.20 and later follow an Unwrap that returns
+// several errors, so Is and As look at the failures themselves for the Go versions before.
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
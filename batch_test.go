@@ -0,0 +1,229 @@
+package pushstate
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// badModel is a model that can not be encoded
+type badModel struct {
+	ID string
+	Ch chan int
+}
+
+func (m *badModel) GetID() string {
+	return m.ID
+}
+
+func TestPutBatchPartialFailure(t *testing.T) {
+	models := []PushModel{
+		&testModel{ID: "a", Payload: "1"},
+		&badModel{ID: "bad"},
+		&testModel{ID: "", Payload: "1"},
+		&testModel{ID: "b", Payload: "1"},
+	}
+	tests := []struct {
+		name string
+		opts []Option
+		want int64
+	}{
+		{name: "others are put", opts: []Option{WithRejectEmptyID()}, want: 2},
+		{name: "abort", opts: []Option{WithRejectEmptyID(), WithBatchAbortOnError()}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			err := fc.PutBatch(models)
+
+			batchErr := &BatchError{}
+			if !errors.As(err, &batchErr) {
+				t.Fatalf("PutBatch returned %v, expected a *BatchError", err)
+			}
+			if len(batchErr.Failures) != 2 || batchErr.Failures[0].Index != 1 || batchErr.Failures[1].Index != 2 {
+				t.Errorf("the failures are %+v, expected index 1 and 2", batchErr.Failures)
+			}
+			if !errors.Is(err, ErrEmptyID) {
+				t.Errorf("errors.Is does not find %v in the batch error", ErrEmptyID)
+			}
+			// Called directly, as errors.Is of Go 1.19 does, which does not follow Unwrap() []error
+			if !batchErr.Is(ErrEmptyID) {
+				t.Errorf("BatchError.Is does not find %v", ErrEmptyID)
+			}
+			if errors.Is(err, ErrDraining) {
+				t.Errorf("errors.Is finds %v, which no model failed with", ErrDraining)
+			}
+			typeErr := &json.UnsupportedTypeError{}
+			if !errors.As(err, &typeErr) || !batchErr.As(&typeErr) {
+				t.Errorf("errors.As does not find the encoding error in the batch error")
+			}
+			if n := fc.Size(); n != tt.want {
+				t.Errorf("Size is %d, expected %d", n, tt.want)
+			}
+		})
+	}
+}
+
+// models returns a model with the payload for every id
+func models(payload string, ids ...string) []PushModel {
+	ms := make([]PushModel, 0, len(ids))
+	for _, id := range ids {
+		ms = append(ms, &testModel{ID: id, Payload: payload})
+	}
+	return ms
+}
+
+func TestPutBatchAndFilterChanged(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "sequential"},
+		{name: "parallel", opts: []Option{WithParallelChecksum(4)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			if err := fc.PutBatch(models("1", "a", "b", "c", "d", "e")); err != nil {
+				t.Fatalf("PutBatch failed; error = %v", err)
+			}
+			if n := fc.Size(); n != 5 {
+				t.Errorf("Size is %d, expected 5", n)
+			}
+			if !fc.IsDirty() {
+				t.Error("PutBatch did not mark the cache dirty")
+			}
+
+			batch := append(models("1", "a", "b"), models("2", "c", "f")...)
+			changed, err := fc.FilterChanged(batch)
+			if err != nil {
+				t.Fatalf("FilterChanged failed; error = %v", err)
+			}
+			if len(changed) != 2 || changed[0].GetID() != "c" || changed[1].GetID() != "f" {
+				t.Errorf("FilterChanged returned %v, expected c and f in order", changed)
+			}
+			if fc.Get("f") != "" {
+				t.Error("FilterChanged put a check-sum")
+			}
+		})
+	}
+}
+
+func TestPutBatchDenied(t *testing.T) {
+	fc := newTestCache(t, WithDenyList("a", "b"))
+	if err := fc.PutBatch(models("1", "a", "b")); err != nil {
+		t.Fatalf("PutBatch failed; error = %v", err)
+	}
+	if fc.Size() != 0 || fc.IsDirty() {
+		t.Errorf("PutBatch of denied ids stored %d check-sums, or marked the cache dirty", fc.Size())
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		models      []PushModel
+		wantChanged []string
+		wantMissing []string
+		wantExtra   []string
+	}{
+		{name: "same", models: models("1", "a", "b", "c")},
+		{name: "nothing", wantExtra: []string{"a", "b", "c"}},
+		{name: "mixed", models: append(models("2", "c", "a"), models("1", "e", "d")...),
+			wantChanged: []string{"a", "c"}, wantMissing: []string{"d", "e"}, wantExtra: []string{"b"}},
+		{name: "key transform", opts: []Option{WithKeyTransform("upper", strings.ToUpper)},
+			models:      append(models("2", "c", "a"), models("1", "e", "d")...),
+			wantChanged: []string{"a", "c"}, wantMissing: []string{"d", "e"}, wantExtra: []string{"B"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", "a", "b", "c")
+			before := fc.Entries()
+			changed, missing, extra, err := fc.Compare(tt.models)
+			if err != nil {
+				t.Fatalf("Compare failed; error = %v", err)
+			}
+			for _, c := range []struct {
+				what      string
+				got, want []string
+			}{{"changed", changed, tt.wantChanged}, {"missing", missing, tt.wantMissing}, {"extra", extra, tt.wantExtra}} {
+				if len(c.got) != len(c.want) || len(c.want) > 0 && !reflect.DeepEqual(c.got, c.want) {
+					t.Errorf("%s is %v, expected %v", c.what, c.got, c.want)
+				}
+			}
+			if !reflect.DeepEqual(fc.Entries(), before) {
+				t.Error("Compare changed the cache")
+			}
+		})
+	}
+}
+
+func TestWithBatchDuplicatePolicy(t *testing.T) {
+	batch := []PushModel{
+		&testModel{ID: "a", Payload: "first"},
+		&testModel{ID: "b", Payload: "1"},
+		&testModel{ID: "a", Payload: "last"},
+	}
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantPayload string
+		wantErr     error
+	}{
+		{name: "default", wantPayload: "last"},
+		{name: "last wins", opts: []Option{WithBatchDuplicatePolicy(DuplicateLastWins)}, wantPayload: "last"},
+		{name: "first wins", opts: []Option{WithBatchDuplicatePolicy(DuplicateFirstWins)}, wantPayload: "first"},
+		{name: "error", opts: []Option{WithBatchDuplicatePolicy(DuplicateError)}, wantErr: ErrDuplicateID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			if err := fc.PutBatch(batch); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PutBatch returned %v, expected %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if fc.Size() != 0 {
+					t.Errorf("a failed batch put %d check-sums", fc.Size())
+				}
+				return
+			}
+			if fc.IsChanged(&testModel{ID: "a", Payload: tt.wantPayload}) || fc.Size() != 2 {
+				t.Errorf("a does not have the check-sum of the %s model", tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestWithBatchDuplicatePolicyFailed(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		batch       []PushModel
+		wantPayload string
+	}{
+		{name: "last fails", batch: []PushModel{&testModel{ID: "a", Payload: "first"}, &badModel{ID: "a"}},
+			wantPayload: "first"},
+		{name: "first fails", opts: []Option{WithBatchDuplicatePolicy(DuplicateFirstWins)},
+			batch: []PushModel{&badModel{ID: "a"}, &testModel{ID: "a", Payload: "last"}}, wantPayload: "last"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			batchErr := &BatchError{}
+			if err := fc.PutBatch(tt.batch); !errors.As(err, &batchErr) {
+				t.Fatalf("PutBatch returned %v, expected a *BatchError", err)
+			}
+			if fc.IsChanged(&testModel{ID: "a", Payload: tt.wantPayload}) || fc.Size() != 1 {
+				t.Errorf("a does not have the check-sum of the %s model", tt.wantPayload)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package pushstate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// binaryMagic starts a state-file in the binary format, which is
+//
+//	magic
+//	uvarint length, and the header and sections as JSON
+//	uvarint number of entries, and for each entry sorted by id:
+//	  uvarint length and the id, uvarint length and the check-sum,
+//	  varint creation and update time in Unix nanoseconds, or 0 when not known
+//
+// The algorithms and sequence numbers of entries that have one are kept in the head.
+var binaryMagic = []byte("PSTB")
+
+// binaryHead is the part of a binary state-file that is kept as JSON
+type binaryHead struct {
+	Header     fileHeader                   `json:"header"`
+	Sections   map[string]map[string]string `json:"sections,omitempty"`
+	Algorithms map[string]string            `json:"algorithms,omitempty"`
+	Seqs       map[string]uint64            `json:"seqs,omitempty"`
+}
+
+func encodeBinary(w io.Writer, sf *stateFile) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf, v)
+		_, _ = bw.Write(buf[:n])
+	}
+	putVarint := func(v int64) {
+		n := binary.PutVarint(buf, v)
+		_, _ = bw.Write(buf[:n])
+	}
+	putBytes := func(s string) {
+		putUvarint(uint64(len(s)))
+		_, _ = bw.WriteString(s)
+	}
+
+	bh := &binaryHead{Header: sf.Header, Sections: sf.Sections}
+	for id, meta := range sf.Meta {
+		if meta.Algorithm != "" {
+			if bh.Algorithms == nil {
+				bh.Algorithms = map[string]string{}
+			}
+			bh.Algorithms[id] = meta.Algorithm
+		}
+		if meta.Seq != 0 {
+			if bh.Seqs == nil {
+				bh.Seqs = map[string]uint64{}
+			}
+			bh.Seqs[id] = meta.Seq
+		}
+	}
+	head, err := json.Marshal(bh)
+	if err != nil {
+		return err
+	}
+	_, _ = bw.Write(binaryMagic)
+	putBytes(string(head))
+
+	ids := make([]string, 0, len(sf.Entries))
+	for id := range sf.Entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	putUvarint(uint64(len(ids)))
+	for _, id := range ids {
+		meta := sf.Meta[id]
+		putBytes(id)
+		putBytes(sf.Entries[id])
+		putVarint(unixNano(meta.CreatedAt))
+		putVarint(unixNano(meta.UpdatedAt))
+	}
+	// bufio.Writer keeps the first write error, and returns it from Flush
+	return bw.Flush()
+}
+
+func decodeBinary(r *bufio.Reader, sf *stateFile) error {
+	if _, err := r.Discard(len(binaryMagic)); err != nil {
+		return err
+	}
+	readBytes := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", err
+		}
+		// Do not trust a length from the file with a large allocation up front
+		if n > 1<<30 {
+			return "", fmt.Errorf("length %d is too large", n)
+		}
+		b := make([]byte, n)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	head, err := readBytes()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	bh := &binaryHead{}
+	if err = json.Unmarshal([]byte(head), bh); err != nil {
+		return err
+	}
+	sf.Header = bh.Header
+	if bh.Sections != nil {
+		sf.Sections = bh.Sections
+	}
+	if sf.Header.Version > fileVersion {
+		return nil
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	for i := uint64(0); i < count; i++ {
+		id, err := readBytes()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		cs, err := readBytes()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		created, err := binary.ReadVarint(r)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		updated, err := binary.ReadVarint(r)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		sf.Entries[id] = cs
+		meta := entryMeta{CreatedAt: fromUnixNano(created), UpdatedAt: fromUnixNano(updated), Algorithm: bh.Algorithms[id],
+			Seq: bh.Seqs[id]}
+		if meta != (entryMeta{}) {
+			sf.Meta[id] = meta
+		}
+	}
+	return nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, since the file ends too early
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,75 @@
+package pushstate
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithBinaryFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "binary", opts: []Option{WithBinaryFormat()}},
+		{name: "binary compressed", opts: []Option{WithBinaryFormat(), WithCompression()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			fc := newTestCache(t, tt.opts...)
+			fc.now = func() time.Time { return now }
+			putAll(fc, "1", "b", "a")
+			fc.Pin("a")
+			fc.Section("s").Put(&testModel{ID: "c", Payload: "1"})
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			raw, err := fc.RawDump()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := io.ReadAll(raw); bytes.HasPrefix(b, []byte("{")) {
+				t.Errorf("the file is JSON, expected the binary format")
+			}
+
+			c := reopen(t, fc, tt.opts...)
+			for _, e := range fc.Entries() {
+				if c.Get(e.ID) != e.Checksum {
+					t.Errorf("%s has %q after Read, expected %q", e.ID, c.Get(e.ID), e.Checksum)
+				}
+			}
+			if at, ok := c.CreatedAt("a"); !ok || !at.Equal(now) {
+				t.Errorf("a was created at %v after Read, expected %v", at, now)
+			}
+			if !c.IsPinned("a") || c.Section("s").Get("c") == "" {
+				t.Error("the pin or the section was lost")
+			}
+		})
+	}
+}
+
+func TestBinaryTruncated(t *testing.T) {
+	fc := newTestCache(t, WithBinaryFormat())
+	putAll(fc, "1", "a", "b")
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	b, err := os.ReadFile(fc.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(fc.filename, b[:len(b)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = fc.Read(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Read of a truncated file returned %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,65 @@
+package pushstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// canonicalJSON re-encodes the JSON in b with all object keys sorted.
+// Numbers are kept as they are written, so large integers do not lose precision on the way, unless
+// numbers is true, in which case integer-valued numbers are written as plain integers, see
+// canonicalNumber.
+func canonicalJSON(b []byte, numbers bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if numbers {
+		v = canonicalNumbers(v)
+	}
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalNumbers replaces the numbers in v, as decoded with UseNumber, by canonicalNumber
+func canonicalNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		return canonicalNumber(t)
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = canonicalNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = canonicalNumbers(e)
+		}
+	}
+	return v
+}
+
+// canonicalNumber writes an integer-valued number as a plain integer, e.g. 1e+21 as
+// 1000000000000000000000 and 5.0 as 5, so the same integer gets the same check-sum whether it was
+// encoded from an integer or a float. Other numbers are kept as they are.
+func canonicalNumber(n json.Number) json.Number {
+	// A number beyond the range of a float64 is not from encoding a Go number, and its exponent is
+	// not expanded into a huge integer
+	if _, err := n.Float64(); err != nil {
+		return n
+	}
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok || !r.IsInt() {
+		return n
+	}
+	return json.Number(r.Num().String())
+}
@@ -0,0 +1,104 @@
+package pushstate
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestCanonicalNumber(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "5", want: "5"},
+		{in: "5.0", want: "5"},
+		{in: "1e+21", want: "1000000000000000000000"},
+		{in: "-2.5e1", want: "-25"},
+		{in: "0.5", want: "0.5"},
+		{in: "1e400", want: "1e400"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := canonicalNumber(json.Number(tt.in)); string(got) != tt.want {
+				t.Errorf("canonicalNumber(%s) is %s, expected %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalNumbersCheckSum(t *testing.T) {
+	type floatModel struct {
+		ID string  `json:"id"`
+		N  float64 `json:"n"`
+	}
+	type intModel struct {
+		ID string      `json:"id"`
+		N  json.Number `json:"n"`
+	}
+	tests := []struct {
+		name       string
+		a, b       interface{}
+		numbers    bool
+		wantEquals bool
+	}{
+		{name: "large float and integer", a: floatModel{ID: "a", N: 1e21}, b: intModel{ID: "a", N: "1000000000000000000000"}, numbers: true, wantEquals: true},
+		{name: "large float and integer without the option", a: floatModel{ID: "a", N: 1e21}, b: intModel{ID: "a", N: "1000000000000000000000"}},
+		{name: "fraction and integer", a: intModel{ID: "a", N: "5.0"}, b: intModel{ID: "a", N: "5"}, numbers: true, wantEquals: true},
+		{name: "different numbers", a: floatModel{ID: "a", N: 0.5}, b: intModel{ID: "a", N: "5"}, numbers: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithStableChecksum()}
+			if tt.numbers {
+				opts = append(opts, WithCanonicalNumbers())
+			}
+			fc := newTestCache(t, opts...)
+			a, err := fc.computeCheckSum(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := fc.computeCheckSum(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (a == b) != tt.wantEquals {
+				t.Errorf("the check-sums are equal %v, expected %v", a == b, tt.wantEquals)
+			}
+		})
+	}
+}
+
+func TestStableChecksum(t *testing.T) {
+	type ab struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	}
+	type ba struct {
+		B string `json:"b"`
+		A string `json:"a"`
+	}
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantEqual bool
+	}{
+		{name: "field order", wantEqual: false},
+		{name: "stable", opts: []Option{WithStableChecksum()}, wantEqual: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			cs1, err1 := fc.computeCheckSum(&ab{A: "1", B: "2"})
+			cs2, err2 := fc.computeCheckSum(&ba{A: "1", B: "2"})
+			if err1 != nil || err2 != nil {
+				t.Fatalf("computeCheckSum failed; error = %v, %v", err1, err2)
+			}
+			if (cs1 == cs2) != tt.wantEqual {
+				t.Errorf("the check-sums are %q and %q, expected them equal %t", cs1, cs2, tt.wantEqual)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package pushstate
+
+import (
+	"context"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// Drain prepares the cache for shutdown: it waits for the operations in flight, makes every later
+// operation that changes the cache fail with ErrDraining, or be dropped and logged where there is no
+// error to return, and saves the cache a final time. That is every put, delete and reset, also through
+// WithLock and Section, and the renames, invalidations, pins, garbage collections, replays and
+// EndRun. A write of SaveAsync still in flight does not overwrite the final save. The context's error
+// is returned without draining when it is done before the lock is acquired.
+func (fc *FileCache) Drain(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fc.draining = true
+	if !fc.isDirty {
+		return nil
+	}
+	if err := fc.saveToFile(fc.filename, fc.entries); err != nil {
+		return err
+	}
+	fc.isDirty = false
+	fc.log.Debugw("drained state-cache", "entries", fc.entries.len())
+	return nil
+}
@@ -0,0 +1,92 @@
+package pushstate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(fc *FileCache) error
+	}{
+		{name: "Put", op: func(fc *FileCache) error { fc.Put(&testModel{ID: "c"}); return ErrDraining }},
+		{name: "PutE", op: func(fc *FileCache) error { return fc.PutE(&testModel{ID: "c"}) }},
+		{name: "PutRawE", op: func(fc *FileCache) error { return fc.PutRawE("c", "x") }},
+		{name: "Delete", op: func(fc *FileCache) error { return fc.Delete("a") }},
+		{name: "Reset", op: func(fc *FileCache) error { return fc.Reset() }},
+		{name: "ResetContext", op: func(fc *FileCache) error { return fc.ResetContext(context.Background()) }},
+		{name: "ResetExcept", op: func(fc *FileCache) error { return fc.ResetExcept([]string{"a"}) }},
+		{name: "ReplaceAll", op: func(fc *FileCache) error { return fc.ReplaceAll(map[string]string{"c": "x"}) }},
+		{name: "LoadMap", op: func(fc *FileCache) error { return fc.LoadMap(map[string]string{"c": "x"}) }},
+		{name: "Rename", op: func(fc *FileCache) error { return fc.Rename("a", "c") }},
+		{name: "Update", op: func(fc *FileCache) error {
+			return fc.Update("a", func(string, bool) (string, bool) { return "x", true })
+		}},
+		{name: "Swap", op: func(fc *FileCache) error { _, _, err := fc.Swap(&testModel{ID: "a", Payload: "2"}); return err }},
+		{name: "Invalidate", op: func(fc *FileCache) error { fc.Invalidate("a"); return ErrDraining }},
+		{name: "InvalidateAll", op: func(fc *FileCache) error { fc.InvalidateAll(); return ErrDraining }},
+		{name: "PurgeInvalid", op: func(fc *FileCache) error { _, err := fc.PurgeInvalid(); return err }},
+		{name: "GarbageCollect", op: func(fc *FileCache) error { _, err := fc.GarbageCollect(nil); return err }},
+		{name: "EndRun", op: func(fc *FileCache) error { _, err := fc.EndRun(); return err }},
+		{name: "Recompact", op: func(fc *FileCache) error { return fc.Recompact() }},
+		{name: "ReplayAudit", op: func(fc *FileCache) error {
+			_, err := fc.ReplayAudit(strings.NewReader(`{"op":"reset"}`))
+			return err
+		}},
+		{name: "WithLock", op: func(fc *FileCache) error {
+			fc.WithLock(func(la LockedAccess) {
+				la.Put(&testModel{ID: "c"})
+				la.Delete("a")
+			})
+			return ErrDraining
+		}},
+		{name: "Section.Put", op: func(fc *FileCache) error { fc.Section("s").Put(&testModel{ID: "c"}); return ErrDraining }},
+		{name: "Section.Delete", op: func(fc *FileCache) error { return fc.Section("s").Delete("a") }},
+		{name: "Section.Reset", op: func(fc *FileCache) error { return fc.Section("s").Reset() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a", "b")
+			fc.Section("s").Put(&testModel{ID: "a"})
+			fc.BeginRun()
+			if err := fc.Drain(context.Background()); err != nil {
+				t.Fatalf("Drain failed; error = %v", err)
+			}
+			want := fc.Get("a")
+
+			if err := tt.op(fc); !errors.Is(err, ErrDraining) {
+				t.Fatalf("%s returned %v after Drain, expected %v", tt.name, err, ErrDraining)
+			}
+			if fc.Size() != 2 || fc.Get("a") != want || fc.Get("c") != "" || fc.IsDirty() {
+				t.Errorf("%s changed the cache after Drain", tt.name)
+			}
+			if fc.Section("s").Size() != 1 || fc.IsPinned("a") {
+				t.Errorf("%s changed the section after Drain", tt.name)
+			}
+			if disk := onDisk(t, fc); len(disk) != 2 || disk["a"] != want {
+				t.Errorf("%s changed the file after Drain: %v", tt.name, disk)
+			}
+		})
+	}
+}
+
+func TestDrainPin(t *testing.T) {
+	fc := newTestCache(t)
+	fc.Pin("a")
+	if err := fc.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain failed; error = %v", err)
+	}
+	fc.Unpin("a")
+	fc.Pin("b")
+	if !fc.IsPinned("a") || fc.IsPinned("b") {
+		t.Errorf("pins changed after Drain")
+	}
+}
@@ -0,0 +1,85 @@
+package pushstate
+
+import (
+	"fmt"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// checkID returns ErrEmptyID when m has an empty id and WithRejectEmptyID is set
+func (fc *FileCache) checkID(m PushModel) error {
+	if fc.rejectEmptyID && m.GetID() == "" {
+		return ErrEmptyID
+	}
+	return nil
+}
+
+// PutE is Put, and returns ErrEmptyID instead of storing a model with an empty id when
+// WithRejectEmptyID is set, ErrChecksumTooLong for a check-sum longer than WithMaxChecksumLen,
+// ErrDraining after Drain, and the error of a model that can not be encoded
+func (fc *FileCache) PutE(m PushModel) error {
+	if err := fc.checkID(m); err != nil {
+		return err
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	_, err := fc.putModel(m)
+	return err
+}
+
+// putModel puts the model's check-sum as PutE does, and returns whether it was stored, which a denied
+// id is not; the caller must hold the lock and have checked the id
+func (fc *FileCache) putModel(m PushModel) (bool, error) {
+	if fc.draining {
+		return false, ErrDraining
+	}
+	cs, err := fc.computeCheckSum(m)
+	if err != nil {
+		return false, fmt.Errorf("put %s failed; error = %w", m.GetID(), err)
+	}
+	if err = fc.checkLen(cs); err != nil {
+		return false, fmt.Errorf("put %s failed; error = %w", m.GetID(), err)
+	}
+	if !fc.putCheckSum(fc.key(m.GetID()), cs) {
+		return false, nil
+	}
+	fc.markDirty()
+	return true, nil
+}
+
+// IsChangedE is IsChanged, and returns ErrEmptyID for a model with an empty id when
+// WithRejectEmptyID is set
+func (fc *FileCache) IsChangedE(m PushModel) (bool, error) {
+	if err := fc.checkID(m); err != nil {
+		return false, err
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	changed, _ := fc.changeStatus(m)
+	return changed, nil
+}
+
+// RemoveEmptyKey deletes the check-sum of the empty id, which all models without an id have shared
+// in a cache without WithRejectEmptyID, and returns whether there was one. The cache is marked as
+// dirty, so the next Save removes it from the state-file.
+func (fc *FileCache) RemoveEmptyKey() bool {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		fc.warnw(fc.log, "remove of empty key failed", "error", ErrDraining)
+		return false
+	}
+	id := fc.key("")
+	if _, ok := fc.entries.get(id); !ok {
+		return false
+	}
+	fc.deleteCheckSum(id)
+	fc.markDirty()
+	fc.log.Debugw("removed check-sum of the empty id", "entries", fc.entries.len())
+	return true
+}
@@ -0,0 +1,84 @@
+package pushstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestRejectEmptyID(t *testing.T) {
+	empty := &testModel{ID: "", Payload: "1"}
+	tests := []struct {
+		name    string
+		put     func(fc *FileCache) error
+		stored  func(fc *FileCache) int64
+		wantErr bool
+	}{
+		{name: "PutE", put: func(fc *FileCache) error { return fc.PutE(empty) }, wantErr: true},
+		{name: "Put", put: func(fc *FileCache) error { fc.Put(empty); return nil }},
+		{name: "Swap", put: func(fc *FileCache) error { _, _, err := fc.Swap(empty); return err }, wantErr: true},
+		{name: "PutBatch", put: func(fc *FileCache) error { return fc.PutBatch([]PushModel{empty}) }, wantErr: true},
+		{name: "Prefill", put: func(fc *FileCache) error { fc.Prefill([]PushModel{empty}); return nil }},
+		{name: "WithLock", put: func(fc *FileCache) error {
+			fc.WithLock(func(la LockedAccess) {
+				if !la.IsChanged(empty) {
+					t.Errorf("an empty id is not changed")
+				}
+				la.Put(empty)
+			})
+			return nil
+		}},
+		{name: "Ingest", put: func(fc *FileCache) error {
+			ch := make(chan PushModel, 1)
+			ch <- empty
+			close(ch)
+			n, err := fc.Ingest(context.Background(), ch)
+			if n != 0 {
+				t.Errorf("Ingest counted %d models, expected 0", n)
+			}
+			return err
+		}},
+		{name: "Section", put: func(fc *FileCache) error { fc.Section("s").Put(empty); return nil },
+			stored: func(fc *FileCache) int64 { return fc.Section("s").Size() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, WithRejectEmptyID())
+			err := tt.put(fc)
+			if tt.wantErr && !errors.Is(err, ErrEmptyID) {
+				t.Errorf("%s returned %v, expected %v", tt.name, err, ErrEmptyID)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("%s failed; error = %v", tt.name, err)
+			}
+			stored := fc.Size
+			if tt.stored != nil {
+				stored = func() int64 { return tt.stored(fc) }
+			}
+			if n := stored(); n != 0 {
+				t.Errorf("%s stored an empty id", tt.name)
+			}
+		})
+	}
+}
+
+func TestEmptyIDShared(t *testing.T) {
+	fc := newTestCache(t)
+	fc.Put(&testModel{ID: "", Payload: "1"})
+	if fc.IsChanged(&testModel{ID: "", Payload: "1"}) {
+		t.Errorf("an empty id without WithRejectEmptyID is changed right after it was put")
+	}
+	if changed, err := newTestCache(t, WithRejectEmptyID()).IsChangedE(&testModel{}); !errors.Is(err, ErrEmptyID) || changed {
+		t.Errorf("IsChangedE returned %v, %v, expected false, %v", changed, err, ErrEmptyID)
+	}
+	if !fc.RemoveEmptyKey() || fc.Size() != 0 {
+		t.Errorf("RemoveEmptyKey did not remove the empty id")
+	}
+	if fc.RemoveEmptyKey() {
+		t.Errorf("RemoveEmptyKey reported an empty id that was already removed")
+	}
+}
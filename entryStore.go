@@ -0,0 +1,274 @@
+package pushstate
+
+import (
+	"sort"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// entryStore holds the check-sums of a FileCache, and the metadata kept with them, by id. The map
+// store is the default, and the sorted store keeps them in slices sorted by id, see
+// WithSortedArrayStore. The caller must hold the lock of the cache.
+type entryStore interface {
+	// get returns the check-sum of id, and whether it has one
+	get(id string) (string, bool)
+	// meta returns the metadata of id, which is zero when it has none
+	meta(id string) entryMeta
+	// put stores the check-sum of id, and keeps the metadata it has
+	put(id string, cs string)
+	// setMeta replaces the metadata of id, when it has a check-sum
+	setMeta(id string, meta entryMeta)
+	// remove removes the check-sum and the metadata of id
+	remove(id string)
+	// len returns the number of check-sums
+	len() int
+	// ids returns the ids, sorted
+	ids() []string
+	// each calls fn with every id and its check-sum; fn may put another check-sum for the id, but must
+	// not add or remove ids
+	each(fn func(id string, cs string))
+	// maps returns the check-sums and the metadata as maps, which may be the store's own and then
+	// change with it
+	maps() (map[string]string, map[string]entryMeta)
+	// memUsageBytes returns an estimate of the bytes held by the store
+	memUsageBytes() int64
+}
+
+// newStore returns a store of the kind the cache is configured with, holding cache and meta, which the
+// store may keep; the caller must hold the lock
+func (fc *FileCache) newStore(cache map[string]string, meta map[string]entryMeta) entryStore {
+	if fc.sortedArray {
+		return newSortedStore(cache, meta)
+	}
+	return newMapStore(cache, meta)
+}
+
+// mapStore keeps the entries in maps, and lets equal check-sums share one string
+type mapStore struct {
+	sums  map[string]string
+	metas map[string]entryMeta
+	pool  *stringPool
+}
+
+// newMapStore returns a store of cache and meta, which it keeps; nil maps are an empty store
+func newMapStore(cache map[string]string, meta map[string]entryMeta) *mapStore {
+	if cache == nil {
+		cache = map[string]string{}
+	}
+	if meta == nil {
+		meta = map[string]entryMeta{}
+	}
+	s := &mapStore{sums: cache, metas: meta, pool: newStringPool()}
+	s.pool.internAll(cache)
+	return s
+}
+
+func (s *mapStore) get(id string) (string, bool) {
+	cs, ok := s.sums[id]
+	return cs, ok
+}
+
+func (s *mapStore) meta(id string) entryMeta {
+	return s.metas[id]
+}
+
+func (s *mapStore) put(id string, cs string) {
+	if old, ok := s.sums[id]; ok {
+		if old == cs {
+			return
+		}
+		s.pool.release(old)
+	}
+	s.sums[id] = s.pool.intern(cs)
+}
+
+func (s *mapStore) setMeta(id string, meta entryMeta) {
+	if _, ok := s.sums[id]; !ok {
+		return
+	}
+	if meta == (entryMeta{}) {
+		delete(s.metas, id)
+		return
+	}
+	s.metas[id] = meta
+}
+
+func (s *mapStore) remove(id string) {
+	if old, ok := s.sums[id]; ok {
+		s.pool.release(old)
+		delete(s.sums, id)
+	}
+	delete(s.metas, id)
+}
+
+func (s *mapStore) len() int {
+	return len(s.sums)
+}
+
+func (s *mapStore) ids() []string {
+	return sortedKeys(s.sums)
+}
+
+func (s *mapStore) each(fn func(id string, cs string)) {
+	for id, cs := range s.sums {
+		fn(id, cs)
+	}
+}
+
+func (s *mapStore) maps() (map[string]string, map[string]entryMeta) {
+	return s.sums, s.metas
+}
+
+// Rough per-entry costs used by memUsageBytes; a string header is 16 bytes and a map entry has
+// about as much overhead again for hash bits, overflow pointers and unused slots
+const (
+	stringHeaderBytes = 16
+	mapEntryBytes     = 16
+	entryMetaBytes    = 48
+)
+
+func (s *mapStore) memUsageBytes() int64 {
+	var n int64
+	for id := range s.sums {
+		// The id is shared by the check-sums and the meta data
+		n += int64(len(id)) + 2*(2*stringHeaderBytes+mapEntryBytes) + entryMetaBytes
+	}
+	for cs := range s.pool.strs {
+		n += int64(len(cs)) + 2*stringHeaderBytes + mapEntryBytes + 8
+	}
+	return n
+}
+
+// sortedStore keeps the entries in three slices sorted by id, and finds an id by binary search, so it
+// has no per-entry overhead beyond the strings and the metadata. Putting or removing an id that is not
+// at the end copies the slices after it. Check-sums are not shared, since the pool of the map store
+// would need a map entry per check-sum.
+type sortedStore struct {
+	keys  []string
+	sums  []string
+	metas []entryMeta
+}
+
+// newSortedStore returns a store with the entries of cache and meta
+func newSortedStore(cache map[string]string, meta map[string]entryMeta) *sortedStore {
+	keys := sortedKeys(cache)
+	s := &sortedStore{keys: keys, sums: make([]string, len(keys)), metas: make([]entryMeta, len(keys))}
+	for i, id := range keys {
+		s.sums[i] = cache[id]
+		s.metas[i] = meta[id]
+	}
+	return s
+}
+
+// find returns the index of id, or where it belongs, and whether it is there
+func (s *sortedStore) find(id string) (int, bool) {
+	// Ids mostly come in sorted order when a file is read, so check the end first
+	if n := len(s.keys); n == 0 || s.keys[n-1] < id {
+		return n, false
+	}
+	i := sort.SearchStrings(s.keys, id)
+	return i, i < len(s.keys) && s.keys[i] == id
+}
+
+// add stores the check-sum and the metadata of id
+func (s *sortedStore) add(id string, cs string, meta entryMeta) {
+	i, ok := s.find(id)
+	if !ok {
+		s.insert(i, id)
+	}
+	s.sums[i] = cs
+	s.metas[i] = meta
+}
+
+// insert makes room for id at i, with an empty check-sum and no metadata
+func (s *sortedStore) insert(i int, id string) {
+	s.keys = append(s.keys, "")
+	s.sums = append(s.sums, "")
+	s.metas = append(s.metas, entryMeta{})
+	if i < len(s.keys)-1 {
+		copy(s.keys[i+1:], s.keys[i:])
+		copy(s.sums[i+1:], s.sums[i:])
+		copy(s.metas[i+1:], s.metas[i:])
+	}
+	s.keys[i] = id
+	s.sums[i] = ""
+	s.metas[i] = entryMeta{}
+}
+
+func (s *sortedStore) get(id string) (string, bool) {
+	if i, ok := s.find(id); ok {
+		return s.sums[i], true
+	}
+	return "", false
+}
+
+func (s *sortedStore) meta(id string) entryMeta {
+	if i, ok := s.find(id); ok {
+		return s.metas[i]
+	}
+	return entryMeta{}
+}
+
+func (s *sortedStore) put(id string, cs string) {
+	i, ok := s.find(id)
+	if !ok {
+		s.insert(i, id)
+	}
+	s.sums[i] = cs
+}
+
+func (s *sortedStore) setMeta(id string, meta entryMeta) {
+	if i, ok := s.find(id); ok {
+		s.metas[i] = meta
+	}
+}
+
+func (s *sortedStore) remove(id string) {
+	i, ok := s.find(id)
+	if !ok {
+		return
+	}
+	last := len(s.keys) - 1
+	copy(s.keys[i:], s.keys[i+1:])
+	copy(s.sums[i:], s.sums[i+1:])
+	copy(s.metas[i:], s.metas[i+1:])
+	// Let go of the strings of the last slot, which is now a copy
+	s.keys[last], s.sums[last], s.metas[last] = "", "", entryMeta{}
+	s.keys, s.sums, s.metas = s.keys[:last], s.sums[:last], s.metas[:last]
+}
+
+func (s *sortedStore) len() int {
+	return len(s.keys)
+}
+
+func (s *sortedStore) ids() []string {
+	return append([]string(nil), s.keys...)
+}
+
+func (s *sortedStore) each(fn func(id string, cs string)) {
+	for i := range s.keys {
+		fn(s.keys[i], s.sums[i])
+	}
+}
+
+func (s *sortedStore) maps() (map[string]string, map[string]entryMeta) {
+	cache := make(map[string]string, len(s.keys))
+	meta := make(map[string]entryMeta, len(s.keys))
+	for i, id := range s.keys {
+		cache[id] = s.sums[i]
+		if s.metas[i] != (entryMeta{}) {
+			meta[id] = s.metas[i]
+		}
+	}
+	return cache, meta
+}
+
+func (s *sortedStore) memUsageBytes() int64 {
+	var n int64
+	for i, id := range s.keys {
+		n += int64(len(id)+len(s.sums[i])) + 2*stringHeaderBytes + entryMetaBytes
+	}
+	return n
+}
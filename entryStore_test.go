@@ -0,0 +1,286 @@
+package pushstate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestEntryStores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func() entryStore
+	}{
+		{name: "map", store: func() entryStore { return newMapStore(nil, nil) }},
+		{name: "sorted", store: func() entryStore { return newSortedStore(nil, nil) }},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			s := st.store()
+			want := map[string]string{}
+			wantMeta := map[string]entryMeta{}
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 2000; i++ {
+				id := fmt.Sprintf("id-%03d", rnd.Intn(300))
+				switch rnd.Intn(3) {
+				case 0, 1:
+					cs := fmt.Sprintf("cs-%d", rnd.Intn(5))
+					s.put(id, cs)
+					want[id] = cs
+					meta := entryMeta{Seq: uint64(i)}
+					s.setMeta(id, meta)
+					wantMeta[id] = meta
+				default:
+					s.remove(id)
+					delete(want, id)
+					delete(wantMeta, id)
+				}
+			}
+			s.setMeta("unknown", entryMeta{Seq: 1})
+			if _, ok := s.get("unknown"); ok {
+				t.Error("setMeta of an unknown id added it")
+			}
+			if s.len() != len(want) {
+				t.Fatalf("len is %d; want %d", s.len(), len(want))
+			}
+			if got := s.ids(); !reflect.DeepEqual(got, sortedKeys(want)) {
+				t.Errorf("ids are not the sorted ids")
+			}
+			for id, cs := range want {
+				if got, ok := s.get(id); !ok || got != cs {
+					t.Errorf("get(%s) is %q, %t; want %q", id, got, ok, cs)
+				}
+				if got := s.meta(id); got != wantMeta[id] {
+					t.Errorf("meta(%s) is %v; want %v", id, got, wantMeta[id])
+				}
+			}
+			got := map[string]string{}
+			s.each(func(id string, cs string) {
+				got[id] = cs
+			})
+			cache, meta := s.maps()
+			if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(cache, want) || !reflect.DeepEqual(meta, wantMeta) {
+				t.Errorf("each or maps differ from the entries put")
+			}
+		})
+	}
+}
+
+// oldIntegrity is how integrity was computed from maps, which the streamed one must equal
+func oldIntegrity(sf *stateFile) string {
+	type entry struct {
+		Checksum  string `json:"c"`
+		CreatedAt int64  `json:"cr,omitempty"`
+		UpdatedAt int64  `json:"up,omitempty"`
+		Algorithm string `json:"a,omitempty"`
+		Seq       uint64 `json:"q,omitempty"`
+	}
+	entries := make(map[string]entry, len(sf.Entries))
+	for id, cs := range sf.Entries {
+		meta := sf.Meta[id]
+		entries[id] = entry{Checksum: cs, CreatedAt: unixNano(meta.CreatedAt), UpdatedAt: unixNano(meta.UpdatedAt),
+			Algorithm: meta.Algorithm, Seq: meta.Seq}
+	}
+	b, _ := json.Marshal(&struct {
+		Entries  map[string]entry             `json:"e"`
+		Sections map[string]map[string]string `json:"s,omitempty"`
+	}{Entries: entries, Sections: sf.Sections})
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestIntegrity(t *testing.T) {
+	now := time.Unix(1700000000, 5)
+	tests := []struct {
+		name string
+		sf   *stateFile
+	}{
+		{name: "empty", sf: newStateFile()},
+		{name: "entries", sf: &stateFile{
+			Entries: map[string]string{"b": "2", "a<&>": "1", "ø": "3"},
+			Meta:    map[string]entryMeta{"a<&>": {CreatedAt: now, UpdatedAt: now, Algorithm: "x", Seq: 4}},
+		}},
+		{name: "sections", sf: &stateFile{
+			Entries:  map[string]string{"a": "1"},
+			Meta:     map[string]entryMeta{},
+			Sections: map[string]map[string]string{"s": {"x": "y"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := oldIntegrity(tt.sf)
+			if got := tt.sf.integrity(); got != want {
+				t.Errorf("map integrity is %s; want %s", got, want)
+			}
+			sorted := &stateFile{Entries: map[string]string{}, Meta: map[string]entryMeta{}, Sections: tt.sf.Sections,
+				sorted: newSortedStore(tt.sf.Entries, tt.sf.Meta)}
+			if got := sorted.integrity(); got != want {
+				t.Errorf("sorted integrity is %s; want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSortedArrayStore(t *testing.T) {
+	tests := []struct {
+		name       string
+		writeOpts  []Option
+		readOpts   []Option
+		file       string
+		wantSorted bool
+	}{
+		{name: "map to sorted", readOpts: []Option{WithSortedArrayStore()}, wantSorted: true},
+		{name: "sorted to map", writeOpts: []Option{WithSortedArrayStore()}},
+		{name: "sorted to sorted", writeOpts: []Option{WithSortedArrayStore(), WithReadIntegrity()},
+			readOpts: []Option{WithSortedArrayStore(), WithReadIntegrity()}, wantSorted: true},
+		{name: "gzipped", writeOpts: []Option{WithCompression()},
+			readOpts: []Option{WithSortedArrayStore()}, wantSorted: true},
+		{name: "binary", writeOpts: []Option{WithBinaryFormat()},
+			readOpts: []Option{WithSortedArrayStore()}, wantSorted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.writeOpts...)
+			putAll(fc, "p", "c", "a", "b")
+			fc.Section("s").Put(&testModel{ID: "x"})
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			c := reopen(t, fc, tt.readOpts...)
+			if _, ok := c.entries.(*sortedStore); ok != tt.wantSorted {
+				t.Errorf("read into a sorted store %t; want %t", ok, tt.wantSorted)
+			}
+			if !reflect.DeepEqual(c.Entries(), fc.Entries()) {
+				t.Errorf("got %v; want %v", c.Entries(), fc.Entries())
+			}
+			for _, id := range []string{"a", "b", "c"} {
+				want, _ := fc.CreatedAt(id)
+				if got, ok := c.CreatedAt(id); !ok || !got.Equal(want) {
+					t.Errorf("CreatedAt(%s) is %v; want %v", id, got, want)
+				}
+			}
+			if c.Section("s").Get("x") == "" {
+				t.Error("the section was not read")
+			}
+		})
+	}
+}
+
+func TestSortedArrayStoreLegacyFile(t *testing.T) {
+	fc := newTestCache(t, WithSortedArrayStore())
+	if err := os.WriteFile(fc.filename, []byte(`{"b":"2","a":"1"}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Read(); err != nil {
+		t.Fatalf("Read failed; error = %v", err)
+	}
+	want := []Entry{{ID: "a", Checksum: "1"}, {ID: "b", Checksum: "2"}}
+	if got := fc.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if !fc.IsDirty() {
+		t.Error("a legacy file is not rewritten on the next save")
+	}
+}
+
+func TestSortedArrayStoreOperations(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(fc *FileCache) error
+	}{
+		{name: "rename", op: func(fc *FileCache) error { return fc.Rename("a", "z") }},
+		{name: "delete", op: func(fc *FileCache) error { return fc.Delete("b") }},
+		{name: "reset except", op: func(fc *FileCache) error { return fc.ResetExcept([]string{"a"}) }},
+		{name: "replace all", op: func(fc *FileCache) error {
+			return fc.ReplaceAll(map[string]string{"a": "1", "d": "4"})
+		}},
+		{name: "invalidate all", op: func(fc *FileCache) error { fc.InvalidateAll(); return nil }},
+		{name: "purge invalid", op: func(fc *FileCache) error {
+			fc.PutRaw("e", "")
+			_, err := fc.PurgeInvalid()
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			byMap, bySorted := newTestCache(t), newTestCache(t, WithSortedArrayStore())
+			for _, fc := range []*FileCache{byMap, bySorted} {
+				putAll(fc, "p", "c", "a", "b")
+				if err := tt.op(fc); err != nil {
+					t.Fatalf("failed; error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(bySorted.Entries(), byMap.Entries()) {
+				t.Errorf("sorted store has %v; the map store %v", bySorted.Entries(), byMap.Entries())
+			}
+		})
+	}
+}
+
+// largeStateFile writes a state-file with n entries, and returns its name
+func largeStateFile(b *testing.B, n int) string {
+	b.Helper()
+	fc := NewFileCache(b.TempDir()+"/state.json", nil, nil)
+	entries := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		entries[fmt.Sprintf("id-%07d", i)] = fmt.Sprintf("%032x", i)
+	}
+	if err := fc.ReplaceAll(entries); err != nil {
+		b.Fatalf("ReplaceAll failed; error = %v", err)
+	}
+	return fc.filename
+}
+
+func BenchmarkStore(b *testing.B) {
+	const n = 200000
+	filename := largeStateFile(b, n)
+	stores := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "map"},
+		{name: "sorted", opts: []Option{WithSortedArrayStore()}},
+	}
+	for _, st := range stores {
+		b.Run(st.name+"/read", func(b *testing.B) {
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				before := heapInUse()
+				fc := NewFileCache(filename, nil, nil, st.opts...)
+				if err := fc.Read(); err != nil {
+					b.Fatalf("Read failed; error = %v", err)
+				}
+				retained += heapInUse() - before
+				runtime.KeepAlive(fc)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N)/n, "heap-B/entry")
+		})
+		b.Run(st.name+"/get", func(b *testing.B) {
+			fc := NewFileCache(filename, nil, nil, st.opts...)
+			if err := fc.Read(); err != nil {
+				b.Fatalf("Read failed; error = %v", err)
+			}
+			ids := make([]string, 1024)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("id-%07d", (i*7919)%n)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if fc.Get(ids[i%len(ids)]) == "" {
+					b.Fatal("an id was not found")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package pushstate
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+var (
+	// ErrWrongFileFormat is returned when the state-file does not contain a JSON object
+	ErrWrongFileFormat = errors.New("wrong state-file format")
+	// ErrUnsupportedVersion is returned when the state-file is written in a newer format than this build understands
+	ErrUnsupportedVersion = errors.New("unsupported state-file version")
+	// ErrTruncated is returned when only a prefix of the state-file is written
+	ErrTruncated = errors.New("state-file truncated")
+	// ErrIOTimeout is returned when a file operation does not finish within the timeout of WithIOTimeout
+	ErrIOTimeout = errors.New("file operation timed out")
+	// ErrIntegrity is returned when the content of the state-file does not match its integrity check-sum
+	ErrIntegrity = errors.New("state-file integrity check failed")
+	// ErrCacheFull is returned when saving would make the state-file larger than WithMaxFileBytes allows
+	ErrCacheFull = errors.New("cache is full")
+	// ErrNotFound is returned when an id has no check-sum
+	ErrNotFound = errors.New("id not found")
+	// ErrExists is returned when an id already has a check-sum
+	ErrExists = errors.New("id already exists")
+	// ErrDenied is returned when a check-sum is not stored since the id is on the deny list of WithDenyList
+	ErrDenied = errors.New("id is denied")
+	// ErrKeyTransformMismatch is returned when the state-file was written with another key transform
+	ErrKeyTransformMismatch = errors.New("key transform mismatch")
+	// ErrDuplicateID is returned when a batch has several models with the same id and the policy of
+	// WithBatchDuplicatePolicy is DuplicateError, or ReadFiles finds an id in several files with
+	// different check-sums and the policy of WithMergePolicy is DuplicateError
+	ErrDuplicateID = errors.New("duplicate id in batch")
+	// ErrEmptyID is returned for a model with an empty id when WithRejectEmptyID is set
+	ErrEmptyID = errors.New("empty id")
+	// ErrChecksumTooLong is returned for a check-sum longer than WithMaxChecksumLen allows
+	ErrChecksumTooLong = errors.New("check-sum too long")
+	// ErrIDTooLong is returned by a MaxIDLenCache for an id longer than its maximum
+	ErrIDTooLong = errors.New("id too long")
+	// ErrDraining is returned by puts and deletes after Drain
+	ErrDraining = errors.New("cache is draining")
+	// ErrNoRun is returned by EndRun when no run is begun
+	ErrNoRun = errors.New("no run begun")
+)
+
+// CacheError records a failed operation on the state-file and the path it failed on
+type CacheError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *CacheError) Error() string {
+	return fmt.Sprintf("%s %s failed; error = %v", e.Op, e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *CacheError) Unwrap() error {
+	return e.Err
+}
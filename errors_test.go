@@ -0,0 +1,62 @@
+package pushstate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestCacheError(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     func(t *testing.T, dir string) error
+		wantOp string
+		path   func(dir string) string
+	}{
+		{
+			name: "decode",
+			op: func(t *testing.T, dir string) error {
+				filename := filepath.Join(dir, "state.json")
+				if err := os.WriteFile(filename, []byte("{not json"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return NewFileCache(filename, &checksum.Murmur3CheckSum{}, nil).Read()
+			},
+			wantOp: "decode",
+			path:   func(dir string) string { return filepath.Join(dir, "state.json") },
+		},
+		{
+			name: "save into missing directory",
+			op: func(t *testing.T, dir string) error {
+				fc := NewFileCache(filepath.Join(dir, "missing", "state.json"), &checksum.Murmur3CheckSum{}, nil)
+				putAll(fc, "1", "a")
+				return fc.Save()
+			},
+			wantOp: "create temporary file in",
+			path:   func(dir string) string { return filepath.Join(dir, "missing") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := tt.op(t, dir)
+			var ce *CacheError
+			if !errors.As(err, &ce) {
+				t.Fatalf("returned %v, expected a *CacheError", err)
+			}
+			if ce.Op != tt.wantOp || ce.Path != tt.path(dir) {
+				t.Errorf("Op is %q and Path is %q, expected %q and %q", ce.Op, ce.Path, tt.wantOp, tt.path(dir))
+			}
+			if ce.Unwrap() == nil {
+				t.Error("the *CacheError has no underlying error")
+			}
+		})
+	}
+}
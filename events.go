@@ -0,0 +1,118 @@
+package pushstate
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// ChangeKind tells what kind of change a ChangeEvent describes
+type ChangeKind int
+
+const (
+	// Added is a check-sum for a new id
+	Added ChangeKind = iota
+	// Modified is a changed check-sum for an existing id
+	Modified
+	// Deleted is a removed check-sum
+	Deleted
+	// Cleared is a reset of the whole cache; the event has no id or check-sums
+	Cleared
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	case Cleared:
+		return "cleared"
+	default:
+		return "unknown"
+	}
+}
+
+// ChangeEvent describes a change to the check-sum of an id
+type ChangeEvent struct {
+	ID          string
+	Kind        ChangeKind
+	OldChecksum string
+	NewChecksum string
+}
+
+// subscribers delivers change events to channels without ever blocking the cache
+type subscribers struct {
+	chans   map[int]chan ChangeEvent
+	nextID  int
+	dropped uint64
+	// Protect the channels
+	subLock sync.Mutex
+}
+
+// Subscribe returns a channel that receives an event after every change to the cache, and a
+// function that unsubscribes and closes the channel.
+// Events are dropped and counted when the channel's buffer is full, see DroppedEvents.
+func (fc *FileCache) Subscribe(buffer int) (<-chan ChangeEvent, func()) {
+	s := &fc.subs
+	s.subLock.Lock()
+	defer s.subLock.Unlock()
+
+	if s.chans == nil {
+		s.chans = map[int]chan ChangeEvent{}
+	}
+	id := s.nextID
+	s.nextID++
+	ch := make(chan ChangeEvent, buffer)
+	s.chans[id] = ch
+
+	once := sync.Once{}
+	return ch, func() {
+		once.Do(func() {
+			s.subLock.Lock()
+			defer s.subLock.Unlock()
+
+			delete(s.chans, id)
+			close(ch)
+		})
+	}
+}
+
+// DroppedEvents returns the number of events dropped because a subscriber was too slow
+func (fc *FileCache) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&fc.subs.dropped)
+}
+
+// publishCleared notifies subscribers that the check-sums in old were removed by a reset, as one
+// Cleared event or as a Deleted event per id; the caller must hold the cache's lock
+func (fc *FileCache) publishCleared(old entryStore) {
+	if old.len() == 0 {
+		return
+	}
+	if !fc.deleteEventsOnReset {
+		fc.subs.publish(ChangeEvent{Kind: Cleared})
+		return
+	}
+	for _, id := range old.ids() {
+		cs, _ := old.get(id)
+		fc.subs.publish(ChangeEvent{ID: id, Kind: Deleted, OldChecksum: cs})
+	}
+}
+
+func (s *subscribers) publish(ev ChangeEvent) {
+	s.subLock.Lock()
+	defer s.subLock.Unlock()
+
+	for _, ch := range s.chans {
+		select {
+		case ch <- ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
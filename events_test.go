@@ -0,0 +1,110 @@
+package pushstate
+
+import (
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// drain returns the events waiting on ch
+func drain(ch <-chan ChangeEvent) []ChangeEvent {
+	var evs []ChangeEvent
+	for {
+		select {
+		case ev := <-ch:
+			evs = append(evs, ev)
+		default:
+			return evs
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(fc *FileCache)
+		want []ChangeKind
+	}{
+		{name: "add", op: func(fc *FileCache) { putAll(fc, "2", "b") }, want: []ChangeKind{Added}},
+		{name: "modify", op: func(fc *FileCache) { putAll(fc, "2", "a") }, want: []ChangeKind{Modified}},
+		{name: "unchanged", op: func(fc *FileCache) { putAll(fc, "1", "a") }},
+		{name: "delete", op: func(fc *FileCache) { _ = fc.Delete("a") }, want: []ChangeKind{Deleted}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a")
+			ch, unsubscribe := fc.Subscribe(8)
+			defer unsubscribe()
+
+			tt.op(fc)
+			evs := drain(ch)
+			if len(evs) != len(tt.want) {
+				t.Fatalf("got %d events, expected %d", len(evs), len(tt.want))
+			}
+			for i, ev := range evs {
+				if ev.Kind != tt.want[i] {
+					t.Errorf("event %d is %v, expected %v", i, ev.Kind, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSubscribeDropsAndUnsubscribes(t *testing.T) {
+	fc := newTestCache(t)
+	ch, unsubscribe := fc.Subscribe(1)
+	putAll(fc, "1", "a", "b", "c")
+	if n := fc.DroppedEvents(); n != 2 {
+		t.Errorf("DroppedEvents is %d, expected 2", n)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if ev := <-ch; ev.ID != "a" || ev.Kind != Added {
+		t.Errorf("the buffered event is %+v, expected a to be added", ev)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("the channel is not closed after unsubscribe")
+	}
+}
+
+func TestResetEvents(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		empty bool
+		want  []ChangeEvent
+	}{
+		{name: "cleared", want: []ChangeEvent{{Kind: Cleared}}},
+		{name: "deleted", opts: []Option{WithDeleteEventsOnReset()},
+			want: []ChangeEvent{{ID: "a", Kind: Deleted, OldChecksum: "x"}, {ID: "b", Kind: Deleted, OldChecksum: "y"}}},
+		{name: "already empty", empty: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			if !tt.empty {
+				fc.PutRaw("a", "x")
+				fc.PutRaw("b", "y")
+			}
+			ch, unsubscribe := fc.Subscribe(8)
+			defer unsubscribe()
+
+			if err := fc.Reset(); err != nil {
+				t.Fatalf("Reset failed; error = %v", err)
+			}
+			evs := drain(ch)
+			if len(evs) != len(tt.want) {
+				t.Fatalf("got %v, expected %v", evs, tt.want)
+			}
+			for i, ev := range evs {
+				if ev != tt.want[i] {
+					t.Errorf("event %d is %+v, expected %+v", i, ev, tt.want[i])
+				}
+			}
+		})
+	}
+}
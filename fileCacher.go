@@ -2,14 +2,22 @@ package pushstate
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/tkandal/checksum"
 	"go.uber.org/zap"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 /*
@@ -21,119 +29,989 @@ type FileCache struct {
 	filename   string
 	checkSum   checksum.CheckSum
 	log        *zap.SugaredLogger
-	stateCache map[string]string
+	entries    entryStore
+	pinned     map[string]bool
+	sections   map[string]map[string]string
 	isDirty    bool
+	noChmod    bool
+	workers    int
+	batchAbort bool
+	// When deleted ids, with the time they were deleted, are kept, and for how long
+	tombstoneTTL time.Duration
+	tombstones   map[string]time.Time
+	// Warn once when the cache grows beyond this, when > 0
+	growthThreshold int64
+	growthWarned    bool
+	// Write through a symlinked state-file to its target
+	followSymlinks bool
+	// The ids checked or put since BeginRun, or nil when no run is begun
+	runSeen map[string]bool
+	// Refuse puts and deletes after Drain
+	draining bool
+	// Refuse check-sums longer than this, when > 0
+	maxChecksumLen int
+	// Refuse models with an empty id
+	rejectEmptyID bool
+	// What PutBatch does with repeated ids
+	duplicatePolicy DuplicatePolicy
+	mergePolicy     DuplicatePolicy
+	compress        bool
+	binary          bool
+	// Only compress files of at least this many bytes
+	compressMin int64
+	// Save and verify a check-sum of the whole content
+	readIntegrity bool
+	// Transform a copy of the check-sums before they are written
+	beforeSave func(map[string]string) map[string]string
+	// Check-sum a canonical form of the JSON
+	stableChecksum bool
+	// Write integer-valued numbers in one form in the canonical JSON
+	canonicalNumbers bool
+	copyBufSize      int
+	copyBufs         sync.Pool
+	memo             *checkSumMemo
+	now              func() time.Time
+	lastSave         time.Time
+	ioTimeout        time.Duration
+	throttle         *logThrottle
+	// Continue in memory only when the file is not writable
+	fallbackToMemory  bool
+	preserveOwnership bool
+	inPlaceWrite      bool
+	renameOverwrite   bool
+	// Consecutive differences needed to report an id as changed, and the differences seen so far
+	hysteresis int
+	pending    map[string]int
+	// Check-sum of the content last written to the file, so identical content is not rewritten
+	lastWritten string
+	// The name of the check-sum algorithm, and the legacy algorithms entries may still be stored with
+	algorithm string
+	legacy    map[string]checksum.CheckSum
+	// Keep the entries in sorted slices instead of maps
+	sortedArray bool
+	// Record the order ids are added in, and the last sequence number
+	orderedEntries bool
+	seq            uint64
+	// The prefix of temporary files, and the age at which leftover ones are removed
+	tempFilePrefix string
+	staleTempAge   time.Duration
+	// Counters for MetricsSnapshot, and whether they are kept in the state-file
+	stats        stats
+	persistStats bool
+	// The size of the state-file of the last save
+	fileBytes int64
+	// Report entries older than this as changed
+	entryTTL time.Duration
+	// How Ingest puts and saves
+	ingestOnlyChanged bool
+	saveEvery         time.Duration
+	// Save in the background, until Close
+	autoSave  *autoSaver
+	closeOnce sync.Once
+	// Ids that are never cached, as stored
+	denied map[string]bool
+	// The name of the transform of ids to the keys they are stored with
+	keyTransformName string
+	keyTransform     func(string) string
+	// Limit the size of the state-file, and evict entries instead of failing to save
+	maxFileBytes  int64
+	spillEviction bool
+	// Publish a Deleted event per id instead of one Cleared event on Reset
+	deleteEventsOnReset bool
+	degraded            bool
+	// Only used by NewFileCacheE
+	validatePath bool
+	subs         subscribers
+	// Where the state-file is kept
+	fs FileSystem
+	// Order the writes of the file; saveGen numbers the snapshots, and writtenGen is the last one
+	// written, kept with atomic
+	saveGen    uint64
+	writtenGen uint64
+	writeLock  sync.Mutex
+	// Count the changes, and the SaveAsync calls waiting for a save
+	changes      uint64
+	asyncRunning bool
+	asyncWaiters []chan error
 	// Protect this cache
 	cacheLock *sync.Mutex
 }
 
-func NewFileCache(sf string, cs checksum.CheckSum, log *zap.SugaredLogger) *FileCache {
-	return &FileCache{
-		filename:   sf,
-		checkSum:   cs,
-		log:        log,
-		stateCache: map[string]string{},
-		isDirty:    false,
-		cacheLock:  &sync.Mutex{},
+// NewFileCache creates a cache that persists to the file sf; a nil log is replaced by a nop logger
+func NewFileCache(sf string, cs checksum.CheckSum, log *zap.SugaredLogger, opts ...Option) *FileCache {
+	fc := newFileCache(sf, cs, log, opts...)
+	fc.start()
+	return fc
+}
+
+// newFileCache creates a cache with the options applied, but nothing started or removed yet
+func newFileCache(sf string, cs checksum.CheckSum, log *zap.SugaredLogger, opts ...Option) *FileCache {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+	fc := &FileCache{
+		filename:  sf,
+		checkSum:  cs,
+		log:       log,
+		pinned:    map[string]bool{},
+		sections:  map[string]map[string]string{},
+		isDirty:   false,
+		cacheLock: &sync.Mutex{},
+		now:       time.Now,
+		fs:        OSFileSystem{},
+	}
+	for _, opt := range opts {
+		opt(fc)
+	}
+	fc.entries = fc.newStore(nil, nil)
+	if fc.keyTransform != nil && len(fc.denied) > 0 {
+		// The deny list is given as ids, whatever order the options come in
+		denied := make(map[string]bool, len(fc.denied))
+		for id := range fc.denied {
+			denied[fc.key(id)] = true
+		}
+		fc.denied = denied
+	}
+	return fc
+}
+
+// start removes the stale temporary files and starts the saving in the background, as the options say
+func (fc *FileCache) start() {
+	if fc.staleTempAge > 0 {
+		fc.removeStaleTemps()
+	}
+	if fc.autoSave != nil && fc.autoSave.every > 0 {
+		fc.startAutoSave()
+	}
+}
+
+// NewFileCacheE is like NewFileCache, but returns an error when the options fail, e.g. WithValidatePath
+func NewFileCacheE(sf string, cs checksum.CheckSum, log *zap.SugaredLogger, opts ...Option) (*FileCache, error) {
+	// Validated before anything is started or removed, since the caller gets no cache to Close
+	fc := newFileCache(sf, cs, log, opts...)
+	if fc.validatePath {
+		if err := validateDir(fc.fs, filepath.Dir(sf)); err != nil {
+			return nil, err
+		}
+	}
+	fc.start()
+	return fc, nil
+}
+
+// validateDir checks that dir is an existing directory where files can be created
+func validateDir(fsys FileSystem, dir string) error {
+	info, err := fsys.Stat(dir)
+	if err != nil {
+		return &CacheError{Op: "validate", Path: dir, Err: err}
+	}
+	if !info.IsDir() {
+		return &CacheError{Op: "validate", Path: dir, Err: errors.New("not a directory")}
+	}
+	f, err := fsys.CreateTemp(dir, ".pushstate")
+	if err != nil {
+		return &CacheError{Op: "validate", Path: dir, Err: err}
+	}
+	_ = f.Close()
+	_ = fsys.Remove(f.Name())
+	return nil
+}
+
+// SetLogger replaces the logger; a nil log is replaced by a nop logger
+func (fc *FileCache) SetLogger(log *zap.SugaredLogger) {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	fc.log = log
+}
+
+// ChangeReason tells why a model is considered changed or not
+type ChangeReason int
+
+const (
+	// ReasonUnchanged is a model with the same check-sum as the cached one
+	ReasonUnchanged ChangeReason = iota
+	// ReasonNew is a model without a cached check-sum
+	ReasonNew
+	// ReasonModified is a model with another check-sum than the cached one
+	ReasonModified
+	// ReasonExpired is a model whose cached check-sum is older than WithEntryTTL allows
+	ReasonExpired
+	// ReasonTombstone is a model whose id was deleted less than the TTL of WithTombstones ago
+	ReasonTombstone
+)
+
+func (r ChangeReason) String() string {
+	switch r {
+	case ReasonUnchanged:
+		return "unchanged"
+	case ReasonNew:
+		return "new"
+	case ReasonModified:
+		return "modified"
+	case ReasonExpired:
+		return "expired"
+	case ReasonTombstone:
+		return "tombstone"
+	default:
+		return "unknown"
 	}
 }
 
+// logger returns the current logger, for use where the lock is not held
+func (fc *FileCache) logger() *zap.SugaredLogger {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	return fc.log
+}
+
 // IsChanged checks if the card is new or changed
 func (fc *FileCache) IsChanged(m PushModel) bool {
+	changed, _ := fc.ChangeStatus(m)
+	return changed
+}
+
+// ChangeStatus checks if the card is new or changed, and tells which
+func (fc *FileCache) ChangeStatus(m PushModel) (bool, ChangeReason) {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
-	cs, ok := fc.stateCache[m.GetID()]
+	if err := fc.checkID(m); err != nil {
+		fc.warnw(fc.log, "check of model failed", "error", err)
+		return true, ReasonNew
+	}
+	return fc.changeStatus(m)
+}
+
+// changeStatus is ChangeStatus for a caller that holds the lock
+func (fc *FileCache) changeStatus(m PushModel) (bool, ChangeReason) {
+	fc.touch(fc.key(m.GetID()))
+	changed, reason := fc.checkChange(m)
+	fc.stats.count(changed)
+	return changed, reason
+}
+
+// checkChange decides whether a model is changed, without counting the check
+func (fc *FileCache) checkChange(m PushModel) (bool, ChangeReason) {
+	id := fc.key(m.GetID())
+	if _, ok := fc.entries.get(id); !ok {
+		if fc.isTombstone(id) {
+			return true, ReasonTombstone
+		}
+		return true, ReasonNew
+	}
+	if cs, ok := fc.legacyCheckSum(id, m); ok {
+		return fc.compareCheckSum(id, cs)
+	}
+	return fc.compareCheckSum(id, fc.memoCheckSum(m))
+}
+
+// compareCheckSum tells whether cs is a change for id; the caller must hold the lock
+func (fc *FileCache) compareCheckSum(id string, cs string) (bool, ChangeReason) {
+	old, ok := fc.entries.get(id)
 	if !ok {
+		return true, ReasonNew
+	}
+	if old == InvalidatedCheckSum {
+		return true, ReasonModified
+	}
+	if old != cs {
+		if !fc.settled(id) {
+			return false, ReasonUnchanged
+		}
+		return true, ReasonModified
+	}
+	delete(fc.pending, id)
+	if fc.expired(id) {
+		return true, ReasonExpired
+	}
+	return false, ReasonUnchanged
+}
+
+// settled counts one more consecutive difference for id, and returns true when there have been
+// enough of them to report id as changed, see WithChangeHysteresis; the caller must hold the lock
+func (fc *FileCache) settled(id string) bool {
+	if fc.hysteresis <= 1 {
 		return true
 	}
-	return cs != fc.makeCheckSum(m)
+	if fc.pending == nil {
+		fc.pending = map[string]int{}
+	}
+	if fc.pending[id] < fc.hysteresis {
+		fc.pending[id]++
+	}
+	return fc.pending[id] >= fc.hysteresis
 }
 
 // Put puts the card's check-sum in the cache
 func (fc *FileCache) Put(m PushModel) {
+	if err := fc.PutE(m); err != nil {
+		fc.warnw(fc.logger(), "put of model failed", "error", err)
+	}
+}
+
+// putChecksum puts the model's check-sum as Put does, and returns the check-sum and whether it was
+// stored, in one locked operation so no other put comes in between
+func (fc *FileCache) putChecksum(m PushModel) (string, bool) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if err := fc.checkID(m); err != nil {
+		fc.warnw(fc.log, "put of model failed", "error", err)
+		return "", false
+	}
+	stored, err := fc.putModel(m)
+	if err != nil {
+		fc.warnw(fc.log, "put of model failed", "error", err)
+		return "", false
+	}
+	if !stored {
+		return "", false
+	}
+	return fc.entries.get(fc.key(m.GetID()))
+}
+
+// Prefill puts the models' check-sums in the cache without marking the cache as dirty.
+// It is meant for bootstrapping a cache that already matches a known-good state, e.g. after a
+// complete and successful push, so the check-sums are not saved until the next real change.
+func (fc *FileCache) Prefill(models []PushModel) {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
-	fc.stateCache[m.GetID()] = fc.makeCheckSum(m)
+	for _, m := range models {
+		if fc.checkID(m) != nil {
+			continue
+		}
+		cs := fc.makeCheckSum(m)
+		if err := fc.checkLen(cs); err != nil {
+			fc.warnw(fc.log, "prefill of model failed", "id", m.GetID(), "error", err)
+			continue
+		}
+		fc.putCheckSum(fc.key(m.GetID()), cs)
+	}
+}
+
+// IsDirty returns true when the cache has changes that are not saved
+func (fc *FileCache) IsDirty() bool {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	return fc.isDirty
+}
+
+// markDirty marks the cache as changed since the last save; the caller must hold the lock
+func (fc *FileCache) markDirty() {
 	fc.isDirty = true
+	fc.changes++
 }
 
-func readFile(filename string) (map[string]string, error) {
-	stateFile, err := os.OpenFile(filename, os.O_CREATE|os.O_RDONLY, 0644)
+// PutRaw puts a check-sum for id in the cache as it is, without computing it from a model
+func (fc *FileCache) PutRaw(id string, checkSum string) {
+	if err := fc.PutRawE(id, checkSum); err != nil {
+		fc.warnw(fc.logger(), "put of check-sum failed", "error", err)
+	}
+}
+
+// PutRawE is PutRaw, and returns ErrChecksumTooLong for a check-sum longer than WithMaxChecksumLen,
+// ErrDenied for an id on the deny list of WithDenyList, and ErrDraining after Drain
+func (fc *FileCache) PutRawE(id string, checkSum string) error {
+	if err := fc.checkLen(checkSum); err != nil {
+		return fmt.Errorf("put %s failed; error = %w", id, err)
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return ErrDraining
+	}
+	if !fc.putCheckSum(fc.key(id), checkSum) {
+		return fmt.Errorf("put %s failed; error = %w", id, ErrDenied)
+	}
+	fc.markDirty()
+	return nil
+}
+
+// checkLen returns ErrChecksumTooLong when cs is longer than WithMaxChecksumLen allows
+func (fc *FileCache) checkLen(cs string) error {
+	if fc.maxChecksumLen > 0 && len(cs) > fc.maxChecksumLen {
+		return fmt.Errorf("%w; %d bytes, at most %d", ErrChecksumTooLong, len(cs), fc.maxChecksumLen)
+	}
+	return nil
+}
+
+// InvalidEntries returns the ids with an empty check-sum, sorted; an empty check-sum is stored when
+// a model can not be encoded
+func (fc *FileCache) InvalidEntries() []string {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	return fc.invalidEntries()
+}
+
+func (fc *FileCache) invalidEntries() []string {
+	var ids []string
+	fc.entries.each(func(id string, cs string) {
+		if cs == "" {
+			ids = append(ids, id)
+		}
+	})
+	sort.Strings(ids)
+	return ids
+}
+
+// PurgeInvalid deletes the ids with an empty check-sum, saves the cache once and returns the
+// number of deleted ids
+func (fc *FileCache) PurgeInvalid() (int, error) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return 0, ErrDraining
+	}
+	ids := fc.invalidEntries()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	for _, id := range ids {
+		fc.deleteCheckSum(id)
+	}
+	fc.markDirty()
+	if err := fc.saveToFile(fc.filename, fc.entries); err != nil {
+		return len(ids), err
+	}
+	fc.isDirty = false
+	return len(ids), nil
+}
+
+// readFile reads the check-sums from filename without ever creating it; both a missing and an
+// empty file is an empty cache
+func readFile(fsys FileSystem, filename string) (*stateFile, int64, error) {
+	return readFileSorted(fsys, filename, false)
+}
+
+// readFileSorted is readFile, and reads the entries into a sorted store when sorted is true
+func readFileSorted(fsys FileSystem, filename string, sorted bool) (*stateFile, int64, error) {
+	stateFile, err := openRead(fsys, filename)
 	if err != nil {
-		return nil, fmt.Errorf("open %s failed; error = %v", filename, err)
+		if errors.Is(err, os.ErrNotExist) {
+			sf := newStateFile()
+			if sorted {
+				sf.sorted = &sortedStore{}
+			}
+			return sf, 0, nil
+		}
+		return nil, 0, &CacheError{Op: "open", Path: filename, Err: err}
 	}
 	defer func() {
 		_ = stateFile.Close()
 	}()
 
-	cache := map[string]string{}
-	if err = json.NewDecoder(stateFile).Decode(&cache); err != nil && err != io.EOF {
-		return nil, fmt.Errorf("decode state-file %s failed; error = %v", filename, err)
+	cr := &countingReader{r: stateFile}
+	sf, err := decodeStateFile(cr, filename, sorted)
+	if err != nil {
+		return nil, cr.n, err
+	}
+	if sf.Header.Version > fileVersion {
+		return nil, cr.n, &CacheError{Op: "read", Path: filename, Err: fmt.Errorf("%w; found version %d, expected version %d or lower",
+			ErrUnsupportedVersion, sf.Header.Version, fileVersion)}
 	}
-	return cache, nil
+	return sf, cr.n, nil
 }
 
+// Read reads the check-sums from the file.
+// Read, Save, Delete and Reset return a *CacheError when the file operation fails.
 func (fc *FileCache) Read() error {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
-	cache, err := readFile(fc.filename)
+	var sf *stateFile
+	var n int64
+	filename, fsys, sorted := fc.filename, fc.fs, fc.sortedArray
+	start := fc.now()
+	err := fc.withIOTimeout(func() error {
+		var err error
+		sf, n, err = readFileSorted(fsys, filename, sorted)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	fc.stateCache = cache
+	if err = fc.checkHeader(sf, fc.filename); err != nil {
+		return err
+	}
+	denied := fc.dropDenied(sf)
+	// The file may have been written by someone else
+	fc.lastWritten = ""
+	if sf.sorted != nil {
+		fc.setStore(sf.sorted)
+	} else {
+		fc.setCache(sf.Entries, sf.Meta)
+	}
+	fc.pinned = sf.pinned()
+	fc.sections = sf.Sections
+	fc.tombstones = nil
+	if fc.tombstoneTTL > 0 {
+		fc.tombstones = sf.Header.Tombstones
+	}
+	if fc.persistStats && sf.Header.Stats != nil {
+		fc.stats = *sf.Header.Stats
+	}
+	// An older file is migrated in memory, and rewritten in the current format on the next save, and
+	// a stream cache passes what it read on to its writer
+	fc.isDirty = sf.Header.Version < fileVersion && sf.size() > 0 || fc.streamPending()
+	fc.stats.Reads++
+	fc.log.Debugw("read state-cache", "file", filename, "entries", sf.size(), "bytes", n,
+		"duration_ms", durationMillis(fc.now().Sub(start)))
+	if denied > 0 {
+		// Rewrite the file at once, so the denied ids are not left on disk until the next change
+		fc.markDirty()
+		if err := fc.saveToFile(fc.filename, fc.entries); err != nil {
+			return err
+		}
+		fc.isDirty = false
+		fc.log.Debugw("dropped denied check-sums", "file", filename, "denied", denied)
+	}
 	return nil
 }
 
-func (fc *FileCache) saveToFile(filename string, cache map[string]string) error {
-	tmpFile, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename))
+// dropDenied removes the ids of the deny list from sf, and returns how many were removed
+func (fc *FileCache) dropDenied(sf *stateFile) int {
+	denied := 0
+	for id := range fc.denied {
+		if _, ok := sf.Entries[id]; ok {
+			delete(sf.Entries, id)
+			delete(sf.Meta, id)
+			denied++
+		} else if sf.sorted != nil {
+			if _, ok := sf.sorted.get(id); ok {
+				sf.sorted.remove(id)
+				denied++
+			}
+		}
+	}
+	return denied
+}
+
+func (fc *FileCache) saveToFile(filename string, store entryStore) error {
+	_, err := fc.saveToFileN(filename, store)
+	return err
+}
+
+// saveToFileN is saveToFile, and returns the number of bytes written, which is 0 when nothing was
+func (fc *FileCache) saveToFileN(filename string, store entryStore) (int64, error) {
+	job, err := fc.prepareSave(filename, store, false)
+	if err != nil || job == nil {
+		return 0, err
+	}
+	fc.runSave(job)
+	if err = fc.finishSave(job); err != nil || job.skipped || job.err != nil {
+		return 0, err
+	}
+	return job.n, nil
+}
+
+// saveJob is a save of a snapshot of the cache, which is written without the lock by SaveAsync
+type saveJob struct {
+	filename string
+	sf       *stateFile
+	wo       writeOptions
+	fsys     FileSystem
+	sum      string
+	gen      uint64
+	start    time.Time
+	// The store of the snapshot, and the entries evicted from the snapshot by WithMaxFileBytes
+	store   entryStore
+	evicted []Entry
+	// The result of the write
+	skipped  bool
+	n        int64
+	err      error
+	chmodErr error
+}
+
+// prepareSave makes the state-file of store, and returns nil when it does not need to be written; the
+// caller must hold the lock. The state-file is a copy when snapshot is true.
+func (fc *FileCache) prepareSave(filename string, store entryStore, snapshot bool) (*saveJob, error) {
+	if fc.degraded {
+		return nil, nil
+	}
+	fc.sweepTombstones()
+	sf := fc.saveSnapshot(store)
+	wo, fsys := fc.writeOptions(), fc.fs
+	var evicted []Entry
+	if fc.maxFileBytes > 0 {
+		var err error
+		if evicted, err = fc.fitMaxFileBytes(sf, wo); err != nil {
+			return nil, err
+		}
+	}
+	if fc.readIntegrity {
+		sf.Header.Integrity = sf.integrity()
+	}
+	sum, err := contentSum(sf, wo)
+	if err != nil {
+		return nil, &CacheError{Op: "encode", Path: filename, Err: err}
+	}
+	if sum == fc.lastWritten {
+		// The file already has this content, do not rewrite it and bump its mtime
+		fc.lastSave = fc.now()
+		fc.stats.SkippedSaves++
+		fc.log.Debugw("state-cache unchanged, skipped save", "file", filename, "entries", store.len())
+		return nil, nil
+	}
+	if fc.persistStats {
+		// Added after the content check, or the changing counters would make every save a change
+		counters := fc.stats
+		sf.Header.Stats = &counters
+	}
+	if snapshot || fc.ioTimeout > 0 {
+		// A write that times out goes on in the background, and must not see later changes
+		sf = sf.clone()
+	}
+	// Whatever is on disk is unknown until the write succeeds
+	fc.lastWritten = ""
+	fc.saveGen++
+	return &saveJob{filename: filename, sf: sf, wo: wo, fsys: fsys, sum: sum, gen: fc.saveGen, start: fc.now(),
+		store: store, evicted: evicted}, nil
+}
+
+// saveSnapshot returns the state-file a save of store writes, before WithMaxFileBytes evicts from it.
+// The entries are the store's own maps, unless WithBeforeSave or WithSortedArrayStore makes them a
+// copy. The caller must hold the lock.
+func (fc *FileCache) saveSnapshot(store entryStore) *stateFile {
+	cache, meta := store.maps()
+	sf := &stateFile{
+		Header: fileHeader{Version: fileVersion, Pinned: sortedKeys(fc.pinned), KeyTransform: fc.keyTransformName,
+			Tombstones: fc.tombstoneEntries()},
+		Entries:  cache,
+		Meta:     meta,
+		Sections: fc.sections,
+	}
+	if fc.beforeSave != nil {
+		sf.Entries, sf.Meta = fc.transformBeforeSave(cache, meta)
+	}
+	return sf
+}
+
+// transformBeforeSave returns the check-sums of WithBeforeSave for a copy of cache, and the meta of
+// the ids it kept; the caller must hold the lock
+func (fc *FileCache) transformBeforeSave(cache map[string]string, meta map[string]entryMeta) (map[string]string, map[string]entryMeta) {
+	entries := fc.beforeSave(copyMap(cache))
+	if entries == nil {
+		entries = map[string]string{}
+	}
+	kept := make(map[string]entryMeta, len(meta))
+	for id, m := range meta {
+		if _, ok := entries[id]; ok {
+			kept[id] = m
+		}
+	}
+	return entries, kept
+}
+
+// runSave writes the state-file of job, unless a newer one is already written. It only uses the
+// job and the write lock, so it may run without the cache's lock.
+func (fc *FileCache) runSave(job *saveJob) {
+	fc.writeLock.Lock()
+	defer fc.writeLock.Unlock()
+
+	if job.gen < atomic.LoadUint64(&fc.writtenGen) {
+		job.skipped = true
+		return
+	}
+	job.err = fc.withIOTimeout(func() error {
+		var err error
+		job.n, err = writeFile(job.fsys, job.filename, job.sf, job.wo)
+		return err
+	})
+	if job.err != nil {
+		return
+	}
+	atomic.StoreUint64(&fc.writtenGen, job.gen)
+	if !fc.noChmod {
+		job.chmodErr = fc.withIOTimeout(func() error {
+			return job.fsys.Chmod(job.filename, os.FileMode(0640))
+		})
+	}
+}
+
+// finishSave records the result of job; the caller must hold the lock
+func (fc *FileCache) finishSave(job *saveJob) error {
+	if job.skipped {
+		return nil
+	}
+	if err := job.err; err != nil {
+		fc.stats.SaveErrors++
+		if fc.fallbackToMemory && isUnwritable(err) {
+			fc.degraded = true
+			fc.warnw(fc.log, fmt.Sprintf("%s is not writable, continue without saving", job.filename), "error", err,
+				"entries", len(job.sf.Entries))
+			return nil
+		}
+		return err
+	}
+	// Some filesystems do not support chmod at all, do not warn about that on every save
+	if job.chmodErr != nil && !isUnsupported(job.chmodErr) {
+		fc.warnw(fc.log, fmt.Sprintf("chmod on %s failed", job.filename), "error", job.chmodErr)
+	}
+	if job.gen == atomic.LoadUint64(&fc.writtenGen) {
+		fc.lastWritten = job.sum
+	}
+	fc.lastSave = fc.now()
+	fc.stats.Saves++
+	fc.stats.BytesWritten += uint64(job.n)
+	fc.fileBytes = job.n
+	fc.evict(job)
+	fc.log.Debugw("saved state-cache", "file", job.filename, "entries", len(job.sf.Entries), "bytes", job.n,
+		"duration_ms", durationMillis(fc.lastSave.Sub(job.start)))
+	return nil
+}
+
+// isUnwritable returns true when err means the state-file can not be written at all
+func isUnwritable(err error) bool {
+	return errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+// Degraded returns true when the cache has fallen back to memory only, see WithFallbackToMemory
+func (fc *FileCache) Degraded() bool {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	return fc.degraded
+}
+
+// writeOptions are the options for writing the state-file, copied so a write that times out does
+// not share them with the cache
+type writeOptions struct {
+	compress          bool
+	compressMin       int64
+	binary            bool
+	preserveOwnership bool
+	inPlace           bool
+	tempPrefix        string
+	followSymlinks    bool
+}
+
+func (fc *FileCache) writeOptions() writeOptions {
+	return writeOptions{
+		compress:          fc.compress,
+		compressMin:       fc.compressMin,
+		binary:            fc.binary,
+		preserveOwnership: fc.preserveOwnership,
+		inPlace:           fc.inPlaceWrite,
+		tempPrefix:        fc.tempPrefix(),
+		followSymlinks:    fc.followSymlinks,
+	}
+}
+
+// writeFile writes sf to a temporary file and renames it to filename, and returns the bytes written
+func writeFile(fsys FileSystem, filename string, sf *stateFile, wo writeOptions) (int64, error) {
+	if wo.inPlace {
+		return writeFileInPlace(fsys, filename, sf, wo)
+	}
+	if wo.followSymlinks {
+		filename = resolveSymlinks(fsys, filename)
+	}
+	tmpFile, err := fsys.CreateTemp(filepath.Dir(filename), wo.tempPrefix)
 	if err != nil {
-		return fmt.Errorf("create temporary file failed; error = %v", err)
+		return 0, &CacheError{Op: "create temporary file in", Path: filepath.Dir(filename), Err: err}
 	}
 
-	if err = json.NewEncoder(tmpFile).Encode(cache); err != nil {
+	if wo.preserveOwnership {
+		// Not being permitted to chown is expected when not running as root, so just go on
+		info, err := fsys.Stat(filename)
+		chowner, canChown := tmpFile.(interface{ Chown(uid, gid int) error })
+		if err == nil && canChown {
+			if uid, gid, ok := fileOwner(info); ok {
+				_ = chowner.Chown(uid, gid)
+			}
+		}
+	}
+	cw := &countingWriter{w: tmpFile}
+	if err = encodeStateFile(cw, sf, wo); err != nil {
 		_ = tmpFile.Close()
-		_ = os.Remove(tmpFile.Name())
-		return fmt.Errorf("encode to %s failed; error = %v", tmpFile.Name(), err)
+		_ = fsys.Remove(tmpFile.Name())
+		return cw.n, &CacheError{Op: "encode", Path: tmpFile.Name(), Err: err}
 	}
 	if err = tmpFile.Close(); err != nil {
-		_ = os.Remove(tmpFile.Name())
-		return fmt.Errorf("close %s failed; error = %v", tmpFile.Name(), err)
+		_ = fsys.Remove(tmpFile.Name())
+		return cw.n, &CacheError{Op: "close", Path: tmpFile.Name(), Err: err}
 	}
-	if err = os.Rename(tmpFile.Name(), filename); err != nil {
-		return fmt.Errorf("rename %s to %s failed; error = %v", tmpFile.Name(), filename, err)
+	if err = fsys.Rename(tmpFile.Name(), filename); err != nil {
+		return cw.n, &CacheError{Op: "rename", Path: filename, Err: err}
 	}
+	return cw.n, nil
+}
 
-	if err = os.Chmod(filename, os.FileMode(0640)); err != nil {
-		fc.log.Warnw(fmt.Sprintf("chmod on %s failed", filename), "error", err)
+// writeFileInPlace truncates filename and writes sf to it; the file keeps its owner and inode
+func writeFileInPlace(fsys FileSystem, filename string, sf *stateFile, wo writeOptions) (int64, error) {
+	f, err := fsys.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(0640))
+	if err != nil {
+		return 0, &CacheError{Op: "open", Path: filename, Err: err}
+	}
+	cw := &countingWriter{w: f}
+	if err = encodeStateFile(cw, sf, wo); err != nil {
+		_ = f.Close()
+		return cw.n, &CacheError{Op: "encode", Path: filename, Err: err}
 	}
-	fc.log.Debugf("saved state-cache to %s", filename)
+	if err = f.Close(); err != nil {
+		return cw.n, &CacheError{Op: "close", Path: filename, Err: err}
+	}
+	return cw.n, nil
+}
 
+// contentSum returns a check-sum of the bytes sf is written as
+func contentSum(sf *stateFile, wo writeOptions) (string, error) {
+	h := sha256.New()
+	if err := encodeStateFile(h, sf, wo); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// durationMillis returns d in milliseconds, with fractions, for the duration_ms log field
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// withIOTimeout runs op, and returns ErrIOTimeout when it does not finish within the configured
+// timeout. The goroutine running op is then left behind until op returns by itself, since a
+// blocked system call can not be interrupted.
+func (fc *FileCache) withIOTimeout(op func() error) error {
+	if fc.ioTimeout <= 0 {
+		return op()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+	timer := time.NewTimer(fc.ioTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return &CacheError{Op: "wait for", Path: fc.filename, Err: ErrIOTimeout}
+	}
+}
+
+// Recompact applies the options and rewrites the whole file from the in-memory cache, even when it
+// is not dirty, e.g. to compress an existing file or to rewrite a legacy file in the current format
+func (fc *FileCache) Recompact(opts ...Option) error {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return ErrDraining
+	}
+	for _, opt := range opts {
+		opt(fc)
+	}
+	fc.markDirty()
+	if err := fc.saveToFile(fc.filename, fc.entries); err != nil {
+		return err
+	}
+	fc.isDirty = false
 	return nil
 }
 
-// Save saves the check-sums to a file
+// Save saves the check-sums to a file. The file is not rewritten when it would get the same content as
+// the last time this cache wrote it. A state-file that is a symlink is replaced by a regular file,
+// unless WithFollowSymlinks is set.
 func (fc *FileCache) Save() error {
+	_, err := fc.SaveN()
+	return err
+}
+
+// SaveN is Save, and returns the number of bytes written to the state-file. It is 0 when the cache
+// is not dirty, or the file already has the same content and is not rewritten.
+func (fc *FileCache) SaveN() (int64, error) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
 	if !fc.isDirty {
-		return nil
+		return 0, nil
 	}
+	n, err := fc.saveToFileN(fc.filename, fc.entries)
+	if err != nil {
+		return 0, err
+	}
+	fc.isDirty = false
+	return n, nil
+}
+
+// SaveIfOlderThan saves the check-sums to a file only when the cache is dirty and the last save was
+// more than d ago, and returns whether it saved. It is cheap enough to call on every turn of a loop
+// to coalesce bursts of changes into fewer saves.
+func (fc *FileCache) SaveIfOlderThan(d time.Duration) (bool, error) {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
-	if err := fc.saveToFile(fc.filename, fc.stateCache); err != nil {
-		return err
+	if !fc.isDirty || fc.now().Sub(fc.lastSave) <= d {
+		return false, nil
+	}
+	if err := fc.saveToFile(fc.filename, fc.entries); err != nil {
+		return false, err
 	}
 	fc.isDirty = false
-	return nil
+	return true, nil
 }
 
 // Size returns the number of check-sums
 func (fc *FileCache) Size() int64 {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
-	return int64(len(fc.stateCache))
+	return int64(fc.entries.len())
+}
+
+// MemUsageBytes returns an estimate of the bytes held by the cache, i.e. the ids and check-sums with
+// their map overhead. It is a heuristic and not a measurement; check-sums shared by several ids
+// are counted once.
+func (fc *FileCache) MemUsageBytes() int64 {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	return fc.memUsageBytes()
+}
+
+// memUsageBytes is MemUsageBytes for a caller that holds the lock
+func (fc *FileCache) memUsageBytes() int64 {
+	n := fc.entries.memUsageBytes()
+	for name, section := range fc.sections {
+		n += int64(len(name)) + stringHeaderBytes + mapEntryBytes
+		for id, cs := range section {
+			n += int64(len(id)+len(cs)) + 2*stringHeaderBytes + mapEntryBytes
+		}
+	}
+	return n
 }
 
 // Get returns the check-sum for the given id
@@ -141,54 +1019,583 @@ func (fc *FileCache) Get(id string) string {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
-	cs, ok := fc.stateCache[id]
-	if !ok {
-		return ""
-	}
+	cs, _ := fc.entries.get(fc.key(id))
 	return cs
 }
 
+// Entry is an id and its check-sum
+type Entry struct {
+	ID       string `json:"id"`
+	Checksum string `json:"checksum"`
+}
+
+// Entries returns all ids and check-sums sorted by id.
+// The file itself is always written with sorted keys, since encoding/json sorts map keys, so saving
+// the same check-sums twice gives byte-identical files.
+func (fc *FileCache) Entries() []Entry {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	entries := make([]Entry, 0, fc.entries.len())
+	fc.entries.each(func(id string, cs string) {
+		entries = append(entries, Entry{ID: id, Checksum: cs})
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// OrderedEntries returns all ids and check-sums in the order the ids were added, see
+// WithOrderedEntries. Ids added before the order was recorded come first, by creation time and id.
+func (fc *FileCache) OrderedEntries() []Entry {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	ids := fc.entries.ids()
+	sort.Slice(ids, func(i, j int) bool {
+		mi, mj := fc.entries.meta(ids[i]), fc.entries.meta(ids[j])
+		if mi.Seq != mj.Seq {
+			return mi.Seq < mj.Seq
+		}
+		if !mi.CreatedAt.Equal(mj.CreatedAt) {
+			return mi.CreatedAt.Before(mj.CreatedAt)
+		}
+		return ids[i] < ids[j]
+	})
+	entries := make([]Entry, 0, len(ids))
+	for _, id := range ids {
+		cs, _ := fc.entries.get(id)
+		entries = append(entries, Entry{ID: id, Checksum: cs})
+	}
+	return entries
+}
+
+// nextSeq returns the next insertion sequence number, or 0 when the order is not recorded; the
+// caller must hold the lock
+func (fc *FileCache) nextSeq() uint64 {
+	if !fc.orderedEntries {
+		return 0
+	}
+	fc.seq++
+	return fc.seq
+}
+
+// CreatedAt returns when the id first got a check-sum, and false when that is not known
+func (fc *FileCache) CreatedAt(id string) (time.Time, bool) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	t := fc.entries.meta(fc.key(id)).CreatedAt
+	return t, !t.IsZero()
+}
+
+// UpdatedAt returns when the check-sum for the id last changed, and false when that is not known
+func (fc *FileCache) UpdatedAt(id string) (time.Time, bool) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	t := fc.entries.meta(fc.key(id)).UpdatedAt
+	return t, !t.IsZero()
+}
+
 // Delete deletes the check-sum for the given id
 func (fc *FileCache) Delete(id string) error {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
-	delete(fc.stateCache, id)
-	fc.isDirty = true
-	if err := fc.saveToFile(fc.filename, fc.stateCache); err != nil {
+	if fc.draining {
+		return ErrDraining
+	}
+	fc.deleteCheckSum(fc.key(id))
+	fc.markDirty()
+	if err := fc.saveToFile(fc.filename, fc.entries); err != nil {
+		fc.warnw(fc.log, "delete check-sum failed", "id", id, "entries", fc.entries.len(), "error", err)
 		return err
 	}
 	fc.isDirty = false
+	fc.log.Debugw("deleted check-sum", "id", id, "entries", fc.entries.len())
 	return nil
 }
 
-// Reset empties the cache
+// Rename moves the check-sum of oldID, with its timestamps and pin, to newID, so a changed id is
+// not pushed again. It returns ErrNotFound when oldID has no check-sum, ErrExists when newID has
+// one, unless WithRenameOverwrite is used, ErrDenied when newID is on the deny list, and
+// ErrChecksumTooLong for a check-sum longer than WithMaxChecksumLen; nothing is changed then. The
+// cache is saved by the next Save.
+func (fc *FileCache) Rename(oldID string, newID string) error {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return ErrDraining
+	}
+	oldID, newID = fc.key(oldID), fc.key(newID)
+	cs, ok := fc.entries.get(oldID)
+	if !ok {
+		return fmt.Errorf("rename %s failed; error = %w", oldID, ErrNotFound)
+	}
+	if oldID == newID {
+		return nil
+	}
+	if fc.denied[newID] {
+		return fmt.Errorf("rename %s to %s failed; error = %w", oldID, newID, ErrDenied)
+	}
+	if err := fc.checkLen(cs); err != nil {
+		return fmt.Errorf("rename %s to %s failed; error = %w", oldID, newID, err)
+	}
+	if _, exists := fc.entries.get(newID); exists {
+		if !fc.renameOverwrite {
+			return fmt.Errorf("rename %s to %s failed; error = %w", oldID, newID, ErrExists)
+		}
+		fc.deleteCheckSum(newID)
+	}
+	meta := fc.entries.meta(oldID)
+	// The id and the check-sum are checked above, so the check-sum is stored
+	fc.putCheckSum(newID, cs)
+	fc.entries.setMeta(newID, meta)
+	fc.deleteCheckSum(oldID)
+	if fc.pinned[oldID] {
+		fc.pinned[newID] = true
+		delete(fc.pinned, oldID)
+	}
+	fc.markDirty()
+	return nil
+}
+
+// Update calls fn with the check-sum of id, and whether it has one, under the lock, and stores the
+// check-sum fn returns, or deletes the id when fn returns false for keep. It returns
+// ErrChecksumTooLong for a check-sum longer than WithMaxChecksumLen, ErrDenied for an id on the deny
+// list of WithDenyList, and ErrDraining after Drain, and changes nothing then.
+func (fc *FileCache) Update(id string, fn func(old string, exists bool) (string, bool)) error {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return ErrDraining
+	}
+	key := fc.key(id)
+	old, exists := fc.entries.get(key)
+	cs, keep := fn(old, exists)
+	switch {
+	case !keep:
+		if exists {
+			fc.deleteCheckSum(key)
+			fc.markDirty()
+		}
+	case !exists || cs != old:
+		if err := fc.checkLen(cs); err != nil {
+			return fmt.Errorf("update %s failed; error = %w", id, err)
+		}
+		if !fc.putCheckSum(key, cs) {
+			return fmt.Errorf("update %s failed; error = %w", id, ErrDenied)
+		}
+		fc.markDirty()
+	}
+	return nil
+}
+
+// Swap puts the model's check-sum in the cache, and returns the check-sum it replaced and whether
+// there was one, in one operation so no other put comes in between. It fails as PutE does, and with
+// ErrDenied for an id on the deny list of WithDenyList, and changes nothing then.
+func (fc *FileCache) Swap(m PushModel) (string, bool, error) {
+	if err := fc.checkID(m); err != nil {
+		return "", false, err
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return "", false, ErrDraining
+	}
+	cs := fc.makeCheckSum(m)
+	if err := fc.checkLen(cs); err != nil {
+		return "", false, fmt.Errorf("swap %s failed; error = %w", m.GetID(), err)
+	}
+	key := fc.key(m.GetID())
+	old, existed := fc.entries.get(key)
+	if !fc.putCheckSum(key, cs) {
+		return "", false, fmt.Errorf("swap %s failed; error = %w", m.GetID(), ErrDenied)
+	}
+	fc.markDirty()
+	return old, existed, nil
+}
+
+// key returns the key id is stored with; the caller must hold the lock
+func (fc *FileCache) key(id string) string {
+	if fc.keyTransform == nil {
+		return id
+	}
+	return fc.keyTransform(id)
+}
+
+// InvalidatedCheckSum is the check-sum of an invalidated id. It is never the check-sum of a
+// model, since check-sums are hex encoded, so IsChanged always reports the id as changed.
+const InvalidatedCheckSum = "!invalidated"
+
+// Invalidate forces the model of id to be reported as changed and pushed again, without deleting
+// the entry's metadata. The check-sum is set to InvalidatedCheckSum until the next Put replaces it
+// with the model's real check-sum. Subscribers are not notified, since the pushed content has not
+// changed. Nothing happens when id has no check-sum.
+func (fc *FileCache) Invalidate(id string) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		fc.warnw(fc.log, "invalidate failed", "id", id, "error", ErrDraining)
+		return
+	}
+	fc.invalidate(fc.key(id))
+}
+
+// InvalidateAll invalidates every id, see Invalidate
+func (fc *FileCache) InvalidateAll() {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		fc.warnw(fc.log, "invalidate failed", "error", ErrDraining)
+		return
+	}
+	fc.entries.each(func(id string, _ string) {
+		fc.invalidate(id)
+	})
+}
+
+// invalidate sets the check-sum of id to InvalidatedCheckSum; the caller must hold the lock
+func (fc *FileCache) invalidate(id string) {
+	old, ok := fc.entries.get(id)
+	if !ok || old == InvalidatedCheckSum {
+		return
+	}
+	fc.entries.put(id, InvalidatedCheckSum)
+	delete(fc.pending, id)
+	fc.markDirty()
+}
+
+// Reset empties the cache and saves the empty cache to the file.
+// Reset runs as one locked operation, so a Put from another goroutine is ordered either
+// before the Reset (and is removed) or after it (and is kept), never interleaved.
+// Subscribers get one Cleared event, or a Deleted event per id with WithDeleteEventsOnReset.
 func (fc *FileCache) Reset() error {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
+	return fc.clear()
+}
+
+// ResetContext is like Reset, but returns the context's error without touching the cache when
+// the context is done before the lock is acquired
+func (fc *FileCache) ResetContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fc.clear()
+}
+
+// ResetExcept empties the cache except for the check-sums of the ids in keep, in one locked operation.
+// Subscribers get a Deleted event per removed id.
+func (fc *FileCache) ResetExcept(keep []string) error {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
 	cache := map[string]string{}
-	fc.isDirty = true
-	if err := fc.saveToFile(fc.filename, cache); err != nil {
+	meta := map[string]entryMeta{}
+	for _, id := range keep {
+		id = fc.key(id)
+		if cs, ok := fc.entries.get(id); ok {
+			cache[id] = cs
+			if m := fc.entries.meta(id); m != (entryMeta{}) {
+				meta[id] = m
+			}
+		}
+	}
+	old := fc.entries
+	if err := fc.reset(fc.newStore(cache, meta)); err != nil {
 		return err
 	}
-	fc.stateCache = cache
+	for _, id := range old.ids() {
+		if _, ok := cache[id]; !ok {
+			cs, _ := old.get(id)
+			fc.subs.publish(ChangeEvent{ID: id, Kind: Deleted, OldChecksum: cs})
+		}
+	}
+	return nil
+}
+
+// ReplaceAll replaces all check-sums with a copy of entries and saves the cache once, which is
+// effectively a Reset and a Prefill in one atomic step. It returns ErrChecksumTooLong without
+// changing anything when a check-sum is longer than WithMaxChecksumLen. Subscribers are notified of
+// the difference, or like by Reset when entries is empty.
+func (fc *FileCache) ReplaceAll(entries map[string]string) error {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	for id, cs := range entries {
+		if err := fc.checkLen(cs); err != nil {
+			return fmt.Errorf("replace %s failed; error = %w", id, err)
+		}
+	}
+	cache, meta := fc.replacement(entries)
+	if len(cache) == 0 {
+		return fc.clear()
+	}
+	old := fc.entries
+	if err := fc.reset(fc.newStore(cache, meta)); err != nil {
+		return err
+	}
+	fc.publishReplaced(old, fc.entries)
+	return nil
+}
+
+// LoadMap replaces all check-sums with a copy of entries without saving, and marks the cache as
+// dirty, e.g. to seed the cache from a map kept by other means. It returns ErrChecksumTooLong without
+// changing anything when a check-sum is longer than WithMaxChecksumLen, and ErrDraining after Drain.
+// Subscribers are notified of the difference.
+func (fc *FileCache) LoadMap(entries map[string]string) error {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return ErrDraining
+	}
+	for id, cs := range entries {
+		if err := fc.checkLen(cs); err != nil {
+			return fmt.Errorf("load %s failed; error = %w", id, err)
+		}
+	}
+	cache, meta := fc.replacement(entries)
+	old := fc.entries
+	fc.setCache(cache, meta)
+	fc.markDirty()
+	fc.publishReplaced(old, fc.entries)
+	fc.log.Debugw("loaded check-sums", "entries", len(cache))
+	return nil
+}
+
+// replacement returns the cache and metadata that replace the current ones with entries, keeping the
+// metadata of the ids that are not changed; the caller must hold the lock
+func (fc *FileCache) replacement(entries map[string]string) (map[string]string, map[string]entryMeta) {
+	cache := make(map[string]string, len(entries))
+	for id, cs := range entries {
+		cache[fc.key(id)] = cs
+	}
+	for id := range fc.denied {
+		delete(cache, id)
+	}
+	meta := map[string]entryMeta{}
+	now := fc.now()
+	// Sorted, so new ids get their sequence numbers in a predictable order
+	for _, id := range sortedKeys(cache) {
+		cs := cache[id]
+		m := fc.entries.meta(id)
+		if old, ok := fc.entries.get(id); !ok {
+			m = entryMeta{CreatedAt: now, UpdatedAt: now, Seq: fc.nextSeq()}
+		} else if old != cs {
+			m.UpdatedAt = now
+		}
+		m.Algorithm = fc.algorithm
+		meta[id] = m
+	}
+	return cache, meta
+}
+
+// publishReplaced notifies subscribers of the difference between the old and the new cache; the
+// caller must hold the lock
+func (fc *FileCache) publishReplaced(old entryStore, cache entryStore) {
+	old.each(func(id string, cs string) {
+		if newCS, ok := cache.get(id); !ok {
+			fc.subs.publish(ChangeEvent{ID: id, Kind: Deleted, OldChecksum: cs})
+		} else if newCS != cs {
+			fc.subs.publish(ChangeEvent{ID: id, Kind: Modified, OldChecksum: cs, NewChecksum: newCS})
+		}
+	})
+	cache.each(func(id string, cs string) {
+		if _, ok := old.get(id); !ok {
+			fc.subs.publish(ChangeEvent{ID: id, Kind: Added, NewChecksum: cs})
+		}
+	})
+}
+
+// clear empties the cache and notifies subscribers; the caller must hold the lock
+func (fc *FileCache) clear() error {
+	if fc.draining {
+		return ErrDraining
+	}
+	old := fc.entries
+	if err := fc.reset(fc.newStore(nil, nil)); err != nil {
+		return err
+	}
+	fc.publishCleared(old)
+	return nil
+}
+
+// reset saves store to the file and replaces the in-memory cache with it; the caller must hold the lock
+func (fc *FileCache) reset(store entryStore) error {
+	if fc.draining {
+		return ErrDraining
+	}
+	fc.markDirty()
+	if err := fc.saveToFile(fc.filename, store); err != nil {
+		return err
+	}
+	fc.setStore(store)
 	fc.isDirty = false
 	return nil
 }
 
-// Dump dumps the whole content to an io.Reader
+// putCheckSum stores the check-sum for id and notifies subscribers, and returns false when it was
+// not stored, e.g. for a denied id; the caller must hold the lock
+func (fc *FileCache) putCheckSum(id string, cs string) bool {
+	delete(fc.pending, id)
+	if fc.denied[id] || fc.draining {
+		return false
+	}
+	if err := fc.checkLen(cs); err != nil {
+		fc.warnw(fc.log, "not storing check-sum", "id", id, "error", err)
+		return false
+	}
+	fc.stats.Puts++
+	fc.touch(id)
+	if fc.tombstones != nil {
+		delete(fc.tombstones, id)
+	}
+	ev := ChangeEvent{ID: id, Kind: Added, NewChecksum: cs}
+	if old, ok := fc.entries.get(id); ok {
+		if old == cs {
+			if fc.entryTTL > 0 {
+				// The entry is refreshed when it is put again, so it does not stay expired
+				meta := fc.entries.meta(id)
+				meta.UpdatedAt = fc.now()
+				fc.entries.setMeta(id, meta)
+			}
+			return true
+		}
+		ev.Kind = Modified
+		ev.OldChecksum = old
+	}
+	now := fc.now()
+	meta := fc.entries.meta(id)
+	if ev.Kind == Added {
+		meta = entryMeta{CreatedAt: now, Seq: fc.nextSeq()}
+	}
+	meta.UpdatedAt = now
+	meta.Algorithm = fc.algorithm
+	fc.entries.put(id, cs)
+	fc.entries.setMeta(id, meta)
+	fc.subs.publish(ev)
+	if ev.Kind == Added {
+		fc.checkGrowth()
+	}
+	return true
+}
+
+// deleteCheckSum removes the check-sum for id, keeps a tombstone for it when WithTombstones is set,
+// and notifies subscribers; the caller must hold the lock
+func (fc *FileCache) deleteCheckSum(id string) {
+	fc.removeCheckSum(id)
+	fc.bury(id)
+}
+
+// removeCheckSum removes the check-sum for id without a tombstone, as a reset does, and notifies
+// subscribers; the caller must hold the lock
+func (fc *FileCache) removeCheckSum(id string) {
+	delete(fc.pending, id)
+	if old, ok := fc.entries.get(id); ok {
+		fc.stats.Deletes++
+		fc.entries.remove(id)
+		fc.subs.publish(ChangeEvent{ID: id, Kind: Deleted, OldChecksum: old})
+	}
+}
+
+// setCache replaces the in-memory cache with cache and meta, which it keeps; the caller must hold the lock
+func (fc *FileCache) setCache(cache map[string]string, meta map[string]entryMeta) {
+	fc.setStore(fc.newStore(cache, meta))
+}
+
+// setStore replaces the in-memory cache with store; the caller must hold the lock
+func (fc *FileCache) setStore(store entryStore) {
+	fc.entries = store
+	fc.pending = nil
+	fc.changes++
+	store.each(func(id string, _ string) {
+		if m := store.meta(id); m.Seq > fc.seq {
+			fc.seq = m.Seq
+		}
+	})
+}
+
+// Dump dumps the whole content to an io.Reader, decompressed when the file is gzipped
 func (fc *FileCache) Dump() (io.Reader, error) {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
-	stateFile, err := os.OpenFile(fc.filename, os.O_CREATE|os.O_RDONLY, 0644)
+	stateFile, err := openRead(fc.fs, fc.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		// A missing file is an empty cache, as for Read
+		return &bytes.Buffer{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s failed; error = %v", fc.filename, err)
+	}
+	defer func() {
+		if err := stateFile.Close(); err != nil {
+			fc.warnw(fc.log, fmt.Sprintf("close %s failed", fc.filename), "error", err)
+		}
+	}()
+
+	r, err := decompressed(stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s failed; error = %v", fc.filename, err)
+	}
+	buf := &bytes.Buffer{}
+	if _, err = io.Copy(buf, r); err != nil {
+		return nil, fmt.Errorf("copy %s to buffer failed; error = %v", fc.filename, err)
+	}
+	return buf, nil
+}
+
+// DumpFilter dumps the in-memory check-sums for which pred returns true, as a JSON object of ids and
+// check-sums. The predicate is called with the lock held, and must not call the cache.
+func (fc *FileCache) DumpFilter(pred func(id, checksum string) bool) (io.Reader, error) {
+	fc.cacheLock.Lock()
+	entries := map[string]string{}
+	fc.entries.each(func(id string, cs string) {
+		if pred(id, cs) {
+			entries[id] = cs
+		}
+	})
+	fc.cacheLock.Unlock()
+
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(entries); err != nil {
+		return nil, fmt.Errorf("encode dump failed; error = %v", err)
+	}
+	return buf, nil
+}
+
+// RawDump dumps the whole content to an io.Reader as it is on disk, i.e. gzipped when the file is
+func (fc *FileCache) RawDump() (io.Reader, error) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	stateFile, err := openRead(fc.fs, fc.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		// A missing file is an empty cache, as for Read
+		return &bytes.Buffer{}, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("open %s failed; error = %v", fc.filename, err)
 	}
 	defer func() {
 		if err := stateFile.Close(); err != nil {
-			fc.log.Warnw(fmt.Sprintf("close %s failed", fc.filename), "error", err)
+			fc.warnw(fc.log, fmt.Sprintf("close %s failed", fc.filename), "error", err)
 		}
 	}()
 
@@ -205,26 +1612,127 @@ func (fc *FileCache) Dump() (io.Reader, error) {
 	return buf, nil
 }
 
+// WriteTo writes the whole content to w, decompressed when the file is gzipped
 func (fc *FileCache) WriteTo(w io.Writer) (int64, error) {
 	fc.cacheLock.Lock()
 	defer fc.cacheLock.Unlock()
 
-	stateFile, err := os.OpenFile(fc.filename, os.O_CREATE|os.O_RDONLY, os.FileMode(0644))
+	stateFile, err := openRead(fc.fs, fc.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		// A missing file is an empty cache, as for Read
+		return 0, nil
+	}
 	if err != nil {
 		return 0, fmt.Errorf("open %s failed; error = %v", fc.filename, err)
 	}
 	defer func() {
 		if err := stateFile.Close(); err != nil {
-			fc.log.Warnf("close %s failed; error = %v", fc.filename, err)
+			fc.warnw(fc.log, fmt.Sprintf("close %s failed", fc.filename), "error", err)
 		}
 	}()
-	return io.Copy(w, stateFile)
+	r, err := decompressed(stateFile)
+	if err != nil {
+		return 0, fmt.Errorf("decompress %s failed; error = %v", fc.filename, err)
+	}
+	return fc.copy(w, r)
+}
+
+// WriteToLimited writes at most max bytes of the file to w, and returns ErrTruncated when the file
+// is larger. The bytes written are then only a prefix of the file, and not valid JSON by themselves.
+func (fc *FileCache) WriteToLimited(w io.Writer, max int64) (int64, error) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	stateFile, err := openRead(fc.fs, fc.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		// A missing file is an empty cache, as for Read
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("open %s failed; error = %v", fc.filename, err)
+	}
+	defer func() {
+		if err := stateFile.Close(); err != nil {
+			fc.warnw(fc.log, fmt.Sprintf("close %s failed", fc.filename), "error", err)
+		}
+	}()
+	r, err := decompressed(stateFile)
+	if err != nil {
+		return 0, fmt.Errorf("decompress %s failed; error = %v", fc.filename, err)
+	}
+	n, err := fc.copy(w, io.LimitReader(r, max))
+	if err != nil {
+		return n, err
+	}
+	if m, _ := r.Read(make([]byte, 1)); m > 0 {
+		return n, ErrTruncated
+	}
+	return n, nil
+}
+
+// copy copies r to w with a pooled buffer when a buffer size is configured
+func (fc *FileCache) copy(w io.Writer, r io.Reader) (int64, error) {
+	if fc.copyBufSize <= 0 {
+		return io.Copy(w, r)
+	}
+	buf, ok := fc.copyBufs.Get().(*[]byte)
+	if !ok || len(*buf) != fc.copyBufSize {
+		b := make([]byte, fc.copyBufSize)
+		buf = &b
+	}
+	defer fc.copyBufs.Put(buf)
+	// Hide any WriterTo of r, since io.CopyBuffer would use that instead of the buffer
+	return io.CopyBuffer(w, struct{ io.Reader }{r}, *buf)
+}
+
+// CheckSumOf returns the check-sum of m as the cache computes it, without looking at or changing
+// the cache, and the error when m can not be encoded
+func (fc *FileCache) CheckSumOf(m PushModel) (string, error) {
+	cs, err := fc.computeCheckSum(m)
+	if err != nil {
+		return "", fmt.Errorf("check-sum of %s failed; error = %w", m.GetID(), err)
+	}
+	return cs, nil
 }
 
 func (fc *FileCache) makeCheckSum(v interface{}) string {
+	cs, err := fc.computeCheckSum(v)
+	if err != nil {
+		return ""
+	}
+	return cs
+}
+
+func (fc *FileCache) computeCheckSum(v interface{}) (string, error) {
+	return fc.computeCheckSumWith(fc.checkSum, v)
+}
+
+// computeCheckSumWith computes the check-sum of v with cs instead of the primary check-sum
+func (fc *FileCache) computeCheckSumWith(cs checksum.CheckSum, v interface{}) (string, error) {
+	if cr, ok := v.(ContentReader); ok {
+		return contentCheckSum(cs, cr)
+	}
+	// Encode straight into the hash, so the encoding is not copied to a buffer of its own first.
+	// encoding/json still builds the whole encoding in its pooled buffer, so this saves the copy and
+	// its allocation, but the memory of one encoding is still needed.
+	if scs, ok := cs.(StreamCheckSum); ok && !fc.stableChecksum {
+		h := scs.NewHash()
+		if err := json.NewEncoder(h).Encode(v); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
 	jsonBuf := &bytes.Buffer{}
 	if err := json.NewEncoder(jsonBuf).Encode(v); err != nil {
-		return ""
+		return "", err
+	}
+	if fc.stableChecksum {
+		b, err := canonicalJSON(jsonBuf.Bytes(), fc.canonicalNumbers)
+		if err != nil {
+			return "", err
+		}
+		return cs.SumBytes(b), nil
 	}
-	return fc.checkSum.SumBytes(jsonBuf.Bytes())
+	return cs.SumBytes(jsonBuf.Bytes()), nil
 }
@@ -0,0 +1,1467 @@
+package pushstate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tkandal/checksum"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestResetExcept(t *testing.T) {
+	tests := []struct {
+		name string
+		keep []string
+		want []string
+	}{
+		{name: "full reset", keep: nil, want: nil},
+		{name: "keep some", keep: []string{"a", "c"}, want: []string{"a", "c"}},
+		{name: "keep unknown", keep: []string{"a", "x"}, want: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a", "b", "c")
+			if err := fc.ResetExcept(tt.keep); err != nil {
+				t.Fatalf("ResetExcept failed; error = %v", err)
+			}
+			if n := fc.Size(); n != int64(len(tt.want)) {
+				t.Errorf("Size is %d, expected %d", n, len(tt.want))
+			}
+			disk := onDisk(t, fc)
+			for _, id := range tt.want {
+				if fc.Get(id) == "" || disk[id] == "" {
+					t.Errorf("%s was not kept", id)
+				}
+			}
+			if len(disk) != len(tt.want) {
+				t.Errorf("the file has %d entries, expected %d", len(disk), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestResetContext(t *testing.T) {
+	fc := newTestCache(t)
+	putAll(fc, "1", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fc.ResetContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ResetContext returned %v, expected %v", err, context.Canceled)
+	}
+	if n := fc.Size(); n != 1 {
+		t.Errorf("a cancelled reset changed the cache, Size is %d", n)
+	}
+	if err := fc.ResetContext(context.Background()); err != nil {
+		t.Fatalf("ResetContext failed; error = %v", err)
+	}
+	if n := fc.Size(); n != 0 {
+		t.Errorf("Size is %d after ResetContext, expected 0", n)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		observed bool
+	}{
+		{name: "nil logger", observed: false},
+		{name: "observed logger", observed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.DebugLevel)
+			fc := newTestCache(t)
+			fc.SetLogger(zap.New(core).Sugar())
+			if !tt.observed {
+				fc.SetLogger(nil)
+			}
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if n := logs.FilterMessage("saved state-cache").Len(); (n > 0) != tt.observed {
+				t.Errorf("the replaced logger got %d save messages, expected them %t", n, tt.observed)
+			}
+		})
+	}
+}
+
+func TestSetLoggerConcurrentPut(t *testing.T) {
+	fc := newTestCache(t, WithRejectEmptyID())
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			fc.Put(&testModel{})
+		}()
+		go func() {
+			defer wg.Done()
+			fc.SetLogger(zap.NewNop().Sugar())
+		}()
+	}
+	wg.Wait()
+	if n := fc.Size(); n != 0 {
+		t.Errorf("Size is %d, expected the empty ids to be refused", n)
+	}
+}
+
+func TestWithNoChmod(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want os.FileMode
+	}{
+		{name: "chmod", want: 0640},
+		{name: "no chmod", opts: []Option{WithNoChmod()}, want: 0600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			fi, err := os.Stat(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := fi.Mode().Perm(); mode != tt.want {
+				t.Errorf("mode is %v, expected %v", mode, tt.want)
+			}
+		})
+	}
+}
+
+// chmodFileSystem is an OSFileSystem whose Chmod fails with err
+type chmodFileSystem struct {
+	OSFileSystem
+	err error
+}
+
+func (c chmodFileSystem) Chmod(string, fs.FileMode) error {
+	return c.err
+}
+
+func TestChmodWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantWarn bool
+	}{
+		{name: "permission", err: &fs.PathError{Op: "chmod", Path: "state.json", Err: fs.ErrPermission}, wantWarn: true},
+		{name: "not supported", err: &fs.PathError{Op: "chmod", Path: "state.json", Err: syscall.ENOTSUP}},
+		{name: "not implemented", err: &fs.PathError{Op: "chmod", Path: "state.json", Err: syscall.ENOSYS}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, WithFileSystem(chmodFileSystem{err: tt.err}))
+			core, logs := observer.New(zap.WarnLevel)
+			fc.SetLogger(zap.New(core).Sugar())
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if warned := logs.Len() > 0; warned != tt.wantWarn {
+				t.Errorf("warned %t about the failed chmod, expected %t", warned, tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestReadWrongFileFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{name: "object", content: `{}`},
+		{name: "empty", content: ``},
+		{name: "array", content: `["a"]`, wantErr: ErrWrongFileFormat},
+		{name: "string", content: `"a"`, wantErr: ErrWrongFileFormat},
+		{name: "number", content: `1`, wantErr: ErrWrongFileFormat},
+		{name: "null", content: `null`, wantErr: ErrWrongFileFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			if err := os.WriteFile(fc.filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := fc.Read(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Read returned %v, expected %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrefillAndIsDirty(t *testing.T) {
+	tests := []struct {
+		name      string
+		op        func(fc *FileCache)
+		wantDirty bool
+	}{
+		{name: "prefill", op: func(fc *FileCache) { fc.Prefill([]PushModel{&testModel{ID: "a", Payload: "1"}}) }},
+		{name: "put", op: func(fc *FileCache) { putAll(fc, "1", "a") }, wantDirty: true},
+		{name: "put and save", op: func(fc *FileCache) { putAll(fc, "1", "a"); _ = fc.Save() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			tt.op(fc)
+			if fc.Get("a") == "" {
+				t.Error("a has no check-sum")
+			}
+			if dirty := fc.IsDirty(); dirty != tt.wantDirty {
+				t.Errorf("IsDirty is %t, expected %t", dirty, tt.wantDirty)
+			}
+		})
+	}
+}
+
+func TestReadMissingFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content *string
+	}{
+		{name: "missing"},
+		{name: "empty", content: new(string)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			if tt.content != nil {
+				if err := os.WriteFile(fc.filename, []byte(*tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := fc.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if n := fc.Size(); n != 0 {
+				t.Errorf("Size is %d, expected 0", n)
+			}
+			if _, err := os.Stat(fc.filename); (err == nil) != (tt.content != nil) {
+				t.Errorf("Stat of the state-file returned %v", err)
+			}
+		})
+	}
+}
+
+func TestDumpMissingFile(t *testing.T) {
+	tests := []struct {
+		name string
+		dump func(fc *FileCache) (int64, error)
+	}{
+		{name: "Dump", dump: func(fc *FileCache) (int64, error) {
+			r, err := fc.Dump()
+			if err != nil {
+				return 0, err
+			}
+			return io.Copy(io.Discard, r)
+		}},
+		{name: "RawDump", dump: func(fc *FileCache) (int64, error) {
+			r, err := fc.RawDump()
+			if err != nil {
+				return 0, err
+			}
+			return io.Copy(io.Discard, r)
+		}},
+		{name: "WriteTo", dump: func(fc *FileCache) (int64, error) { return fc.WriteTo(io.Discard) }},
+		{name: "WriteToLimited", dump: func(fc *FileCache) (int64, error) { return fc.WriteToLimited(io.Discard, 10) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			n, err := tt.dump(fc)
+			if err != nil || n != 0 {
+				t.Errorf("%s of a missing file wrote %d bytes; error = %v", tt.name, n, err)
+			}
+			if _, err = os.Stat(fc.filename); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s created the state-file; Stat returned %v", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestWriteToLimited(t *testing.T) {
+	fc := newTestCache(t)
+	putAll(fc, "1", "a", "b")
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	full := &bytes.Buffer{}
+	size, err := fc.WriteTo(full)
+	if err != nil {
+		t.Fatalf("WriteTo failed; error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		max     int64
+		want    int64
+		wantErr error
+	}{
+		{name: "larger", max: size + 1, want: size},
+		{name: "exact", max: size, want: size},
+		{name: "smaller", max: size - 1, want: size - 1, wantErr: ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			n, err := fc.WriteToLimited(buf, tt.max)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("WriteToLimited returned %v, expected %v", err, tt.wantErr)
+			}
+			if n != tt.want || !bytes.Equal(buf.Bytes(), full.Bytes()[:tt.want]) {
+				t.Errorf("wrote %d bytes, expected a prefix of %d bytes", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFileCacheE(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		dir     string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "valid", dir: dir, opts: []Option{WithValidatePath()}},
+		{name: "missing", dir: filepath.Join(dir, "missing"), opts: []Option{WithValidatePath()}, wantErr: true},
+		{name: "not a directory", dir: notDir, opts: []Option{WithValidatePath()}, wantErr: true},
+		{name: "missing without validation", dir: filepath.Join(dir, "missing")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc, err := NewFileCacheE(filepath.Join(tt.dir, "state.json"), &checksum.Murmur3CheckSum{}, nil, tt.opts...)
+			var ce *CacheError
+			if tt.wantErr != errors.As(err, &ce) {
+				t.Fatalf("NewFileCacheE returned %v, expected a *CacheError %t", err, tt.wantErr)
+			}
+			if (fc == nil) != tt.wantErr {
+				t.Errorf("NewFileCacheE returned the cache %v", fc)
+			}
+			if tt.wantErr && ce.Op != "validate" {
+				t.Errorf("Op is %q, expected validate", ce.Op)
+			}
+		})
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("the directory has %d files after validation, expected 1", len(entries))
+	}
+}
+
+func TestRecompact(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantGzip bool
+	}{
+		{name: "current format", wantGzip: false},
+		{name: "compressed", opts: []Option{WithCompression()}, wantGzip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			if err := os.WriteFile(fc.filename, []byte(`{"a":"1"}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := fc.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if err := fc.Recompact(tt.opts...); err != nil {
+				t.Fatalf("Recompact failed; error = %v", err)
+			}
+			b, err := os.ReadFile(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isGzip := len(b) > 1 && b[0] == 0x1f && b[1] == 0x8b; isGzip != tt.wantGzip {
+				t.Errorf("the file is gzipped %t, expected %t", isGzip, tt.wantGzip)
+			}
+			if v, err := FileVersion(fc.filename); err != nil || v != fileVersion {
+				t.Errorf("FileVersion is %d and %v, expected %d", v, err, fileVersion)
+			}
+			if c := reopen(t, fc); c.Get("a") != "1" {
+				t.Errorf("a has %q after Read, expected 1", c.Get("a"))
+			}
+		})
+	}
+}
+
+// chunkWriter records the size of the largest write to it
+type chunkWriter struct {
+	buf     bytes.Buffer
+	largest int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) > w.largest {
+		w.largest = len(p)
+	}
+	return w.buf.Write(p)
+}
+
+func TestWithCopyBufferSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantLargest int
+	}{
+		{name: "default"},
+		{name: "small buffer", opts: []Option{WithCopyBufferSize(7)}, wantLargest: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", "a", "b", "c")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			want, err := os.ReadFile(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := &chunkWriter{}
+			if _, err = fc.WriteTo(w); err != nil {
+				t.Fatalf("WriteTo failed; error = %v", err)
+			}
+			if !bytes.Equal(w.buf.Bytes(), want) {
+				t.Errorf("WriteTo wrote %q, expected %q", w.buf.Bytes(), want)
+			}
+			if tt.wantLargest > 0 && w.largest != tt.wantLargest {
+				t.Errorf("the largest write is %d bytes, expected %d", w.largest, tt.wantLargest)
+			}
+		})
+	}
+}
+
+func TestChangeStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		model       *testModel
+		wantChanged bool
+		wantReason  ChangeReason
+	}{
+		{name: "unchanged", model: &testModel{ID: "a", Payload: "1"}, wantReason: ReasonUnchanged},
+		{name: "new", model: &testModel{ID: "b", Payload: "1"}, wantChanged: true, wantReason: ReasonNew},
+		{name: "modified", model: &testModel{ID: "a", Payload: "2"}, wantChanged: true, wantReason: ReasonModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a")
+			changed, reason := fc.ChangeStatus(tt.model)
+			if changed != tt.wantChanged || reason != tt.wantReason {
+				t.Errorf("ChangeStatus is %t, %v, expected %t, %v", changed, reason, tt.wantChanged, tt.wantReason)
+			}
+			if changed != fc.IsChanged(tt.model) {
+				t.Error("IsChanged does not agree with ChangeStatus")
+			}
+		})
+	}
+}
+
+func TestSaveIfOlderThan(t *testing.T) {
+	tests := []struct {
+		name     string
+		dirty    bool
+		elapsed  time.Duration
+		wantSave bool
+	}{
+		{name: "clean", elapsed: time.Hour},
+		{name: "recent", dirty: true, elapsed: time.Second},
+		{name: "old", dirty: true, elapsed: time.Hour, wantSave: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			fc := newTestCache(t)
+			fc.now = func() time.Time { return now }
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if tt.dirty {
+				putAll(fc, "2", "a")
+			}
+			now = now.Add(tt.elapsed)
+			saved, err := fc.SaveIfOlderThan(time.Minute)
+			if err != nil {
+				t.Fatalf("SaveIfOlderThan failed; error = %v", err)
+			}
+			if saved != tt.wantSave {
+				t.Errorf("SaveIfOlderThan saved %t, expected %t", saved, tt.wantSave)
+			}
+			if wantDirty := tt.dirty && !tt.wantSave; fc.IsDirty() != wantDirty {
+				t.Errorf("IsDirty is %t, expected %t", fc.IsDirty(), wantDirty)
+			}
+		})
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		want    map[string]string
+	}{
+		{name: "replace", entries: map[string]string{"b": "x", "c": "y"}, want: map[string]string{"b": "x", "c": "y"}},
+		{name: "empty", entries: map[string]string{}, want: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a", "b")
+			if err := fc.ReplaceAll(tt.entries); err != nil {
+				t.Fatalf("ReplaceAll failed; error = %v", err)
+			}
+			if fc.IsDirty() {
+				t.Error("ReplaceAll left the cache dirty")
+			}
+			disk := onDisk(t, fc)
+			if len(disk) != len(tt.want) || fc.Size() != int64(len(tt.want)) {
+				t.Errorf("the file has %v and Size is %d, expected %v", disk, fc.Size(), tt.want)
+			}
+			for id, cs := range tt.want {
+				if disk[id] != cs || fc.Get(id) != cs {
+					t.Errorf("%s has %q on disk and %q in memory, expected %q", id, disk[id], fc.Get(id), cs)
+				}
+			}
+		})
+	}
+}
+
+func TestDumpDecompresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		rawGzip bool
+	}{
+		{name: "plain"},
+		{name: "gzipped", opts: []Option{WithCompression()}, rawGzip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			dumps := map[string]func() (io.Reader, error){
+				"Dump":    fc.Dump,
+				"RawDump": fc.RawDump,
+				"WriteTo": func() (io.Reader, error) {
+					buf := &bytes.Buffer{}
+					_, err := fc.WriteTo(buf)
+					return buf, err
+				},
+			}
+			for name, dump := range dumps {
+				r, err := dump()
+				if err != nil {
+					t.Fatalf("%s failed; error = %v", name, err)
+				}
+				b, _ := io.ReadAll(r)
+				wantGzip := name == "RawDump" && tt.rawGzip
+				if isGzip := len(b) > 1 && b[0] == 0x1f && b[1] == 0x8b; isGzip != wantGzip {
+					t.Errorf("%s is gzipped %t, expected %t", name, isGzip, wantGzip)
+				}
+				if !wantGzip && !json.Valid(b) {
+					t.Errorf("%s is not JSON: %q", name, b)
+				}
+			}
+		})
+	}
+}
+
+// hungFileSystem is a FileSystem whose opens block until release is closed, and then fail
+type hungFileSystem struct {
+	OSFileSystem
+	release chan struct{}
+}
+
+func (h *hungFileSystem) OpenFile(string, int, fs.FileMode) (File, error) {
+	<-h.release
+	return nil, fs.ErrClosed
+}
+
+func (h *hungFileSystem) CreateTemp(string, string) (File, error) {
+	<-h.release
+	return nil, fs.ErrClosed
+}
+
+func TestWithIOTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(fc *FileCache) error
+	}{
+		{name: "read", op: func(fc *FileCache) error { return fc.Read() }},
+		{name: "save", op: func(fc *FileCache) error { putAll(fc, "1", "a"); return fc.Save() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hung := &hungFileSystem{release: make(chan struct{})}
+			defer close(hung.release)
+			fc := newTestCache(t, WithFileSystem(hung), WithIOTimeout(10*time.Millisecond))
+			if err := tt.op(fc); !errors.Is(err, ErrIOTimeout) {
+				t.Errorf("returned %v, expected %v", err, ErrIOTimeout)
+			}
+		})
+	}
+}
+
+func TestEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []string
+		want []string
+	}{
+		{name: "empty"},
+		{name: "sorted", ids: []string{"c", "a", "b"}, want: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", tt.ids...)
+			entries := fc.Entries()
+			if len(entries) != len(tt.want) {
+				t.Fatalf("Entries returned %v, expected %v", entries, tt.want)
+			}
+			for i, e := range entries {
+				if e.ID != tt.want[i] || e.Checksum != fc.Get(e.ID) {
+					t.Errorf("entry %d is %+v, expected %s with its check-sum", i, e, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		invalid []string
+	}{
+		{name: "none"},
+		{name: "some", invalid: []string{"x", "y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a")
+			fc.PutRaw("b", "raw")
+			for _, id := range tt.invalid {
+				fc.PutRaw(id, "")
+			}
+			if got := fc.Get("b"); got != "raw" {
+				t.Errorf("PutRaw stored %q, expected raw", got)
+			}
+			if ids := fc.InvalidEntries(); len(ids) != len(tt.invalid) {
+				t.Errorf("InvalidEntries returned %v, expected %v", ids, tt.invalid)
+			}
+			n, err := fc.PurgeInvalid()
+			if err != nil {
+				t.Fatalf("PurgeInvalid failed; error = %v", err)
+			}
+			if n != len(tt.invalid) || fc.Size() != 2 || len(fc.InvalidEntries()) != 0 {
+				t.Errorf("PurgeInvalid deleted %d and left %d entries, expected %d and 2", n, fc.Size(), len(tt.invalid))
+			}
+		})
+	}
+}
+
+func TestDumpFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		pred func(id, checksum string) bool
+		want []string
+	}{
+		{name: "none", pred: func(string, string) bool { return false }},
+		{name: "all", pred: func(string, string) bool { return true }, want: []string{"a", "b", "c"}},
+		{name: "by id", pred: func(id, _ string) bool { return id != "b" }, want: []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a", "b", "c")
+			r, err := fc.DumpFilter(tt.pred)
+			if err != nil {
+				t.Fatalf("DumpFilter failed; error = %v", err)
+			}
+			entries := map[string]string{}
+			if err = json.NewDecoder(r).Decode(&entries); err != nil {
+				t.Fatalf("the dump is not a JSON object; error = %v", err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Errorf("the dump has %v, expected %v", entries, tt.want)
+			}
+			for _, id := range tt.want {
+				if entries[id] != fc.Get(id) {
+					t.Errorf("%s has %q in the dump, expected %q", id, entries[id], fc.Get(id))
+				}
+			}
+		})
+	}
+}
+
+// readOnlyFileSystem is a FileSystem where no file can be created
+type readOnlyFileSystem struct {
+	OSFileSystem
+}
+
+func (readOnlyFileSystem) CreateTemp(string, string) (File, error) {
+	return nil, fs.ErrPermission
+}
+
+func TestWithFallbackToMemory(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []Option
+		wantErr      bool
+		wantDegraded bool
+	}{
+		{name: "error", wantErr: true},
+		{name: "fallback", opts: []Option{WithFallbackToMemory()}, wantDegraded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, append(tt.opts, WithFileSystem(readOnlyFileSystem{}))...)
+			putAll(fc, "1", "a")
+			if err := fc.Save(); errors.Is(err, fs.ErrPermission) != tt.wantErr {
+				t.Errorf("Save returned %v, expected %v %t", err, fs.ErrPermission, tt.wantErr)
+			}
+			if fc.Degraded() != tt.wantDegraded {
+				t.Errorf("Degraded is %t, expected %t", fc.Degraded(), tt.wantDegraded)
+			}
+			if fc.Get("a") == "" {
+				t.Error("the cache lost its check-sum")
+			}
+		})
+	}
+}
+
+func TestMemUsageBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		checkSum func(i int) string
+	}{
+		{name: "distinct check-sums", checkSum: func(i int) string { return fmt.Sprintf("checksum-%d", i) }},
+		{name: "shared check-sums", checkSum: func(int) string { return "checksum-0" }},
+	}
+	usage := map[string]int64{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			if n := fc.MemUsageBytes(); n != 0 {
+				t.Errorf("an empty cache uses %d bytes", n)
+			}
+			var prev int64
+			for i := 0; i < 10; i++ {
+				fc.PutRaw(fmt.Sprintf("id-%d", i), tt.checkSum(i))
+				n := fc.MemUsageBytes()
+				if n <= prev {
+					t.Fatalf("MemUsageBytes is %d after %d entries, expected more than %d", n, i+1, prev)
+				}
+				prev = n
+			}
+			usage[tt.name] = prev
+			fc.Section("s").Put(&testModel{ID: "a", Payload: "1"})
+			if n := fc.MemUsageBytes(); n <= prev {
+				t.Errorf("MemUsageBytes is %d with a section, expected more than %d", n, prev)
+			}
+		})
+	}
+	if s, d := usage["shared check-sums"], usage["distinct check-sums"]; s >= d {
+		t.Errorf("shared check-sums use %d bytes, expected less than the %d of distinct ones", s, d)
+	}
+}
+
+func TestWithInPlaceWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantSame bool
+	}{
+		{name: "temp and rename", wantSame: false},
+		{name: "in place", opts: []Option{WithInPlaceWrite()}, wantSame: true},
+		{name: "in place compressed", opts: []Option{WithInPlaceWrite(), WithCompression()}, wantSame: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", "a", "b", "c")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			before, err := os.Stat(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Fewer entries, so a rewrite in place must also truncate the file
+			if err = fc.ResetExcept([]string{"a"}); err != nil {
+				t.Fatalf("ResetExcept failed; error = %v", err)
+			}
+			after, err := os.Stat(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := os.SameFile(before, after); same != tt.wantSame {
+				t.Errorf("the file was rewritten in place %t, expected %t", same, tt.wantSame)
+			}
+			if c := reopen(t, fc); c.Size() != 1 || c.Get("a") != fc.Get("a") {
+				t.Errorf("Read got %v, expected only a", c.Entries())
+			}
+		})
+	}
+}
+
+func TestOperationLogFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func(fc *FileCache) error
+		message string
+		fields  []string
+	}{
+		{name: "save", op: func(fc *FileCache) error { putAll(fc, "2", "a"); return fc.Save() },
+			message: "saved state-cache", fields: []string{"file", "entries", "bytes", "duration_ms"}},
+		{name: "read", op: func(fc *FileCache) error { return fc.Read() },
+			message: "read state-cache", fields: []string{"file", "entries", "bytes", "duration_ms"}},
+		{name: "delete", op: func(fc *FileCache) error { return fc.Delete("a") },
+			message: "deleted check-sum", fields: []string{"id", "entries"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			core, logs := observer.New(zap.DebugLevel)
+			fc.SetLogger(zap.New(core).Sugar())
+			if err := tt.op(fc); err != nil {
+				t.Fatalf("%s failed; error = %v", tt.name, err)
+			}
+			entries := logs.FilterMessage(tt.message).All()
+			if len(entries) != 1 {
+				t.Fatalf("logged %q %d times, expected once", tt.message, len(entries))
+			}
+			fields := entries[0].ContextMap()
+			for _, f := range tt.fields {
+				if _, ok := fields[f]; !ok {
+					t.Errorf("%q has no field %s, it has %v", tt.message, f, fields)
+				}
+			}
+		})
+	}
+}
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		oldID   string
+		newID   string
+		wantErr error
+		wantB   string
+	}{
+		{name: "rename", oldID: "a", newID: "c", wantB: "y"},
+		{name: "same id", oldID: "a", newID: "a", wantB: "y"},
+		{name: "not found", oldID: "x", newID: "c", wantErr: ErrNotFound, wantB: "y"},
+		{name: "exists", oldID: "a", newID: "b", wantErr: ErrExists, wantB: "y"},
+		{name: "overwrite", opts: []Option{WithRenameOverwrite()}, oldID: "a", newID: "b", wantB: "x"},
+		{name: "denied", opts: []Option{WithDenyList("c")}, oldID: "a", newID: "c", wantErr: ErrDenied, wantB: "y"},
+		{name: "denied overwrite", opts: []Option{WithDenyList("b"), WithRenameOverwrite()}, oldID: "a", newID: "b",
+			wantErr: ErrDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			fc.PutRaw("a", "x")
+			fc.PutRaw("b", "y")
+			fc.Pin("a")
+			created, _ := fc.CreatedAt("a")
+			before := fc.Entries()
+			if err := fc.Rename(tt.oldID, tt.newID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rename returned %v, expected %v", err, tt.wantErr)
+			}
+			if got := fc.Get("b"); got != tt.wantB {
+				t.Errorf("b has %q, expected %q", got, tt.wantB)
+			}
+			if tt.wantErr != nil && !reflect.DeepEqual(fc.Entries(), before) {
+				t.Errorf("a failed rename changed the cache to %v", fc.Entries())
+			}
+			if tt.wantErr != nil || tt.oldID == tt.newID {
+				return
+			}
+			if fc.Get(tt.oldID) != "" || fc.Get(tt.newID) != "x" {
+				t.Errorf("%s has %q and %s has %q, expected the check-sum moved", tt.oldID, fc.Get(tt.oldID), tt.newID, fc.Get(tt.newID))
+			}
+			if at, _ := fc.CreatedAt(tt.newID); !fc.IsPinned(tt.newID) || fc.IsPinned(tt.oldID) || !at.Equal(created) {
+				t.Error("the pin or the timestamps did not move with the check-sum")
+			}
+		})
+	}
+}
+
+func TestWithChangeHysteresis(t *testing.T) {
+	changed, same := &testModel{ID: "a", Payload: "2"}, &testModel{ID: "a", Payload: "1"}
+	tests := []struct {
+		name   string
+		n      int
+		checks []*testModel
+		want   []bool
+	}{
+		{name: "off", checks: []*testModel{changed}, want: []bool{true}},
+		{name: "three", n: 3, checks: []*testModel{changed, changed, changed, changed}, want: []bool{false, false, true, true}},
+		{name: "started over", n: 2, checks: []*testModel{changed, same, changed, changed}, want: []bool{false, false, false, true}},
+		{name: "new id", n: 3, checks: []*testModel{{ID: "b", Payload: "1"}}, want: []bool{true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, WithChangeHysteresis(tt.n))
+			putAll(fc, "1", "a")
+			for i, m := range tt.checks {
+				if got := fc.IsChanged(m); got != tt.want[i] {
+					t.Errorf("check %d is changed %t, expected %t", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWithDenyList(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted bool
+	}{
+		{name: "map store"},
+		{name: "sorted store", sorted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a", "denied")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			opts := []Option{WithDenyList("denied")}
+			if tt.sorted {
+				opts = append(opts, WithSortedArrayStore())
+			}
+			c := reopen(t, fc, opts...)
+			if c.Get("denied") != "" || c.Size() != 1 {
+				t.Errorf("Read kept the denied id, the cache has %v", c.Entries())
+			}
+			if disk := onDisk(t, c); len(disk) != 1 || disk["denied"] != "" {
+				t.Errorf("the file has %v after Read, expected the denied id dropped", disk)
+			}
+			denied := &testModel{ID: "denied", Payload: "1"}
+			c.Put(denied)
+			if !c.IsChanged(denied) || c.Get("denied") != "" {
+				t.Error("Put stored a check-sum for a denied id")
+			}
+		})
+	}
+}
+
+func TestSaveSkipsUnchangedContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(fc *FileCache)
+		wantWrite bool
+	}{
+		{name: "same check-sum", change: func(fc *FileCache) { putAll(fc, "1", "a") }},
+		{name: "changed and back", change: func(fc *FileCache) { putAll(fc, "2", "a"); putAll(fc, "1", "a") }},
+		{name: "changed", change: func(fc *FileCache) { putAll(fc, "2", "a") }, wantWrite: true},
+		{name: "read in between", change: func(fc *FileCache) { _ = fc.Read(); putAll(fc, "1", "a") }, wantWrite: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			fc := newTestCache(t)
+			fc.now = func() time.Time { return now }
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			before, err := os.Stat(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(fc)
+			n, err := fc.SaveN()
+			if err != nil {
+				t.Fatalf("SaveN failed; error = %v", err)
+			}
+			after, err := os.Stat(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written := !os.SameFile(before, after); written != tt.wantWrite || (n > 0) != tt.wantWrite {
+				t.Errorf("the file was rewritten %t with %d bytes, expected %t", written, n, tt.wantWrite)
+			}
+			if fc.IsDirty() {
+				t.Error("a skipped save left the cache dirty")
+			}
+		})
+	}
+}
+
+func TestCheckSumOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   PushModel
+		wantErr bool
+	}{
+		{name: "model", model: &testModel{ID: "a", Payload: "1"}},
+		{name: "not encodable", model: &badModel{ID: "bad"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			cs, err := fc.CheckSumOf(tt.model)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckSumOf returned %v, expected an error %t", err, tt.wantErr)
+			}
+			if fc.Size() != 0 {
+				t.Error("CheckSumOf changed the cache")
+			}
+			if tt.wantErr {
+				return
+			}
+			fc.Put(tt.model)
+			if got := fc.Get(tt.model.GetID()); got != cs {
+				t.Errorf("Put stored %q, CheckSumOf returned %q", got, cs)
+			}
+		})
+	}
+}
+
+func TestOrderedEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{name: "not recorded", want: []string{"a", "b", "c"}},
+		{name: "recorded", opts: []Option{WithOrderedEntries()}, want: []string{"c", "a", "b"}},
+		{name: "recorded binary", opts: []Option{WithOrderedEntries(), WithBinaryFormat()}, want: []string{"c", "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One creation time for all, so the ids are only ordered by the sequence
+			now := time.Unix(1000, 0)
+			fc := newTestCache(t, tt.opts...)
+			fc.now = func() time.Time { return now }
+			putAll(fc, "1", "c", "a", "b")
+			putAll(fc, "2", "c")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			for _, c := range []*FileCache{fc, reopen(t, fc, tt.opts...)} {
+				entries := c.OrderedEntries()
+				if len(entries) != len(tt.want) {
+					t.Fatalf("OrderedEntries returned %v, expected %v", entries, tt.want)
+				}
+				for i, e := range entries {
+					if e.ID != tt.want[i] {
+						t.Errorf("entry %d is %s, expected %s", i, e.ID, tt.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		invalidate  func(fc *FileCache)
+		wantChanged []string
+	}{
+		{name: "one", invalidate: func(fc *FileCache) { fc.Invalidate("a") }, wantChanged: []string{"a"}},
+		{name: "unknown", invalidate: func(fc *FileCache) { fc.Invalidate("x") }},
+		{name: "all", invalidate: func(fc *FileCache) { fc.InvalidateAll() }, wantChanged: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, WithChangeHysteresis(3))
+			putAll(fc, "1", "a", "b")
+			created, _ := fc.CreatedAt("a")
+			tt.invalidate(fc)
+
+			changed := map[string]bool{}
+			for _, id := range []string{"a", "b"} {
+				m := &testModel{ID: id, Payload: "1"}
+				// Reported at once, in spite of the hysteresis
+				if changed[id] = fc.IsChanged(m); changed[id] {
+					fc.Put(m)
+					if fc.IsChanged(m) {
+						t.Errorf("%s is still changed after it was put again", id)
+					}
+				}
+			}
+			want := map[string]bool{}
+			for _, id := range tt.wantChanged {
+				want[id] = true
+			}
+			for id, got := range changed {
+				if got != want[id] {
+					t.Errorf("%s is changed %t, expected %t", id, got, want[id])
+				}
+			}
+			if at, _ := fc.CreatedAt("a"); !at.Equal(created) {
+				t.Error("the metadata was not kept")
+			}
+		})
+	}
+}
+
+func TestWithKeyTransform(t *testing.T) {
+	upper := WithKeyTransform("upper", strings.ToUpper)
+	tests := []struct {
+		name     string
+		saveOpts []Option
+		readOpts []Option
+		wantErr  error
+	}{
+		{name: "same transform", saveOpts: []Option{upper}, readOpts: []Option{upper}},
+		{name: "transform added", readOpts: []Option{upper}, wantErr: ErrKeyTransformMismatch},
+		{name: "transform removed", saveOpts: []Option{upper}, wantErr: ErrKeyTransformMismatch},
+		{name: "other transform", saveOpts: []Option{upper}, readOpts: []Option{WithKeyTransform("lower", strings.ToLower)},
+			wantErr: ErrKeyTransformMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.saveOpts...)
+			m := &testModel{ID: "a", Payload: "1"}
+			fc.Put(m)
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			c := NewFileCache(fc.filename, &checksum.Murmur3CheckSum{}, nil, tt.readOpts...)
+			if err := c.Read(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read returned %v, expected %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if c.IsChanged(m) || c.Get("a") == "" || c.Entries()[0].ID != "A" {
+				t.Errorf("the transformed key is not found, the cache has %v", c.Entries())
+			}
+		})
+	}
+}
+
+func TestCompressionMinBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantGzip bool
+	}{
+		{name: "always", opts: []Option{WithCompression()}, wantGzip: true},
+		{name: "below the minimum", opts: []Option{WithCompression(1 << 20)}},
+		{name: "above the minimum", opts: []Option{WithCompression(10)}, wantGzip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", "a", "b")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			b, err := os.ReadFile(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isGzip := bytes.HasPrefix(b, gzipMagic); isGzip != tt.wantGzip {
+				t.Errorf("the file is gzipped %t, expected %t", isGzip, tt.wantGzip)
+			}
+			if c := reopen(t, fc); c.Size() != 2 {
+				t.Errorf("Size is %d after Read, expected 2", c.Size())
+			}
+		})
+	}
+}
+
+func TestSaveN(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		ids  []string
+	}{
+		{name: "json", ids: []string{"a", "b", "c"}},
+		{name: "compressed", opts: []Option{WithCompression()}, ids: []string{"a", "b", "c"}},
+		{name: "binary", opts: []Option{WithBinaryFormat()}, ids: []string{"a", "b", "c"}},
+		{name: "not dirty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", tt.ids...)
+			n, err := fc.SaveN()
+			if err != nil {
+				t.Fatalf("SaveN failed; error = %v", err)
+			}
+			var size int64
+			if info, err := os.Stat(fc.filename); err == nil {
+				size = info.Size()
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal(err)
+			}
+			if n != size {
+				t.Errorf("SaveN returned %d, expected the file size %d", n, size)
+			}
+			if n, err = fc.SaveN(); n != 0 || err != nil {
+				t.Errorf("SaveN of a clean cache returned %d, %v, expected 0, <nil>", n, err)
+			}
+		})
+	}
+}
+
+func TestWithMaxChecksumLen(t *testing.T) {
+	sumLen := len((&checksum.Murmur3CheckSum{}).SumString("x"))
+	tests := []struct {
+		name       string
+		opts       []Option
+		put        func(fc *FileCache) error
+		wantErr    error
+		wantStored bool
+	}{
+		{name: "no limit", put: func(fc *FileCache) error { return fc.PutRawE("a", strings.Repeat("f", 1000)) }, wantStored: true},
+		{name: "raw within", opts: []Option{WithMaxChecksumLen(4)}, put: func(fc *FileCache) error { return fc.PutRawE("a", "ffff") }, wantStored: true},
+		{name: "raw too long", opts: []Option{WithMaxChecksumLen(4)},
+			put: func(fc *FileCache) error { return fc.PutRawE("a", "fffff") }, wantErr: ErrChecksumTooLong},
+		{name: "raw denied", opts: []Option{WithDenyList("a")},
+			put: func(fc *FileCache) error { return fc.PutRawE("a", "ffff") }, wantErr: ErrDenied},
+		{name: "model within", opts: []Option{WithMaxChecksumLen(sumLen)},
+			put: func(fc *FileCache) error { return fc.PutE(&testModel{ID: "a", Payload: "1"}) }, wantStored: true},
+		{name: "model too long", opts: []Option{WithMaxChecksumLen(sumLen - 1)},
+			put: func(fc *FileCache) error { return fc.PutE(&testModel{ID: "a", Payload: "1"}) }, wantErr: ErrChecksumTooLong},
+		{name: "load map too long", opts: []Option{WithMaxChecksumLen(4)},
+			put: func(fc *FileCache) error { return fc.LoadMap(map[string]string{"a": "fffff"}) }, wantErr: ErrChecksumTooLong},
+		{name: "replace all too long", opts: []Option{WithMaxChecksumLen(4)},
+			put: func(fc *FileCache) error { return fc.ReplaceAll(map[string]string{"a": "0123456789"}) }, wantErr: ErrChecksumTooLong},
+		{name: "prefill logs", opts: []Option{WithMaxChecksumLen(sumLen - 1)},
+			put: func(fc *FileCache) error { fc.Prefill([]PushModel{&testModel{ID: "a", Payload: "1"}}); return nil }},
+		{name: "put logs", opts: []Option{WithMaxChecksumLen(sumLen - 1)},
+			put: func(fc *FileCache) error { fc.Put(&testModel{ID: "a", Payload: "1"}); return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			if err := tt.put(fc); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("put returned %v, expected %v", err, tt.wantErr)
+			}
+			if stored := fc.Get("a") != ""; stored != tt.wantStored {
+				t.Errorf("a check-sum was stored %t, expected %t", stored, tt.wantStored)
+			}
+			if !tt.wantStored && fc.IsDirty() {
+				t.Error("a refused put marked the cache as dirty")
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		id         string
+		fn         func(old string, exists bool) (string, bool)
+		wantOld    string
+		wantExists bool
+		wantCS     string
+		wantDirty  bool
+		wantErr    error
+	}{
+		{name: "update", id: "a", fn: func(old string, _ bool) (string, bool) { return old + "-tag", true },
+			wantOld: "1", wantExists: true, wantCS: "1-tag", wantDirty: true},
+		{name: "unchanged", id: "a", fn: func(old string, _ bool) (string, bool) { return old, true },
+			wantOld: "1", wantExists: true, wantCS: "1"},
+		{name: "delete", id: "a", fn: func(string, bool) (string, bool) { return "", false },
+			wantOld: "1", wantExists: true, wantDirty: true},
+		{name: "absent", id: "b", fn: func(string, bool) (string, bool) { return "2", true },
+			wantCS: "2", wantDirty: true},
+		{name: "absent not kept", id: "b", fn: func(string, bool) (string, bool) { return "", false }},
+		{name: "denied", opts: []Option{WithDenyList("b")}, id: "b", fn: func(string, bool) (string, bool) { return "2", true },
+			wantErr: ErrDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := NewMemoryCache(&checksum.Murmur3CheckSum{}, nil, tt.opts...)
+			fc.PutRaw("a", "1")
+			fc.isDirty = false
+			var gotOld string
+			var gotExists bool
+			err := fc.Update(tt.id, func(old string, exists bool) (string, bool) {
+				gotOld, gotExists = old, exists
+				return tt.fn(old, exists)
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Update returned %v, expected %v", err, tt.wantErr)
+			}
+			if gotOld != tt.wantOld || gotExists != tt.wantExists {
+				t.Errorf("fn got %q, %t, expected %q, %t", gotOld, gotExists, tt.wantOld, tt.wantExists)
+			}
+			if got := fc.Get(tt.id); got != tt.wantCS {
+				t.Errorf("Get returned %q, expected %q", got, tt.wantCS)
+			}
+			if fc.IsDirty() != tt.wantDirty {
+				t.Errorf("IsDirty returned %t, expected %t", fc.IsDirty(), tt.wantDirty)
+			}
+		})
+	}
+}
+
+func TestLoadMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		entries map[string]string
+		wantErr error
+		wantIDs []string
+	}{
+		{name: "replace", entries: map[string]string{"b": "2", "c": "3"}, wantIDs: []string{"b", "c"}},
+		{name: "empty", entries: map[string]string{}, wantIDs: []string{}},
+		{name: "too long", opts: []Option{WithMaxChecksumLen(1)}, entries: map[string]string{"b": "22"},
+			wantErr: ErrChecksumTooLong, wantIDs: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := NewMemoryCache(&checksum.Murmur3CheckSum{}, nil, tt.opts...)
+			fc.PutRaw("a", "1")
+			fc.isDirty = false
+			if err := fc.LoadMap(tt.entries); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoadMap returned %v, expected %v", err, tt.wantErr)
+			}
+			// The cache has a copy, changing the map afterwards changes nothing
+			tt.entries["x"] = "9"
+			ids := []string{}
+			for _, e := range fc.Entries() {
+				ids = append(ids, e.ID)
+				if want := map[string]string{"a": "1", "b": "2", "c": "3"}[e.ID]; fc.Get(e.ID) != want {
+					t.Errorf("Get(%s) returned %q, expected %q", e.ID, fc.Get(e.ID), want)
+				}
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") || fc.Size() != int64(len(tt.wantIDs)) {
+				t.Errorf("the ids are %v with Size %d, expected %v", ids, fc.Size(), tt.wantIDs)
+			}
+			if want := tt.wantErr == nil; fc.IsDirty() != want {
+				t.Errorf("IsDirty returned %t, expected %t", fc.IsDirty(), want)
+			}
+		})
+	}
+}
+
+func TestSwap(t *testing.T) {
+	sumLen := len((&checksum.Murmur3CheckSum{}).SumString("x"))
+	tests := []struct {
+		name        string
+		opts        []Option
+		model       *testModel
+		wantExisted bool
+		wantErr     error
+	}{
+		{name: "new", model: &testModel{ID: "b", Payload: "1"}},
+		{name: "updated", model: &testModel{ID: "a", Payload: "2"}, wantExisted: true},
+		{name: "unchanged", model: &testModel{ID: "a", Payload: "1"}, wantExisted: true},
+		{name: "too long", opts: []Option{WithMaxChecksumLen(sumLen - 1)}, model: &testModel{ID: "a", Payload: "2"},
+			wantErr: ErrChecksumTooLong},
+		{name: "denied", opts: []Option{WithDenyList("b")}, model: &testModel{ID: "b", Payload: "1"}, wantErr: ErrDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := NewMemoryCache(&checksum.Murmur3CheckSum{}, nil, tt.opts...)
+			fc.PutRaw("a", "1")
+			fc.isDirty = false
+			before := fc.Get(tt.model.ID)
+			old, existed, err := fc.Swap(tt.model)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Swap returned %v, expected %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if fc.Get(tt.model.ID) != before || fc.IsDirty() {
+					t.Errorf("a failed Swap changed the check-sum to %q, or marked the cache as dirty", fc.Get(tt.model.ID))
+				}
+				return
+			}
+			if old != before || existed != tt.wantExisted {
+				t.Errorf("Swap returned %q, %t, expected %q, %t", old, existed, before, tt.wantExisted)
+			}
+			want, err := fc.CheckSumOf(tt.model)
+			if err != nil {
+				t.Fatalf("CheckSumOf failed; error = %v", err)
+			}
+			if got := fc.Get(tt.model.ID); got != want {
+				t.Errorf("Get returned %q after Swap, expected %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWithBeforeSave(t *testing.T) {
+	scrub := func(entries map[string]string) map[string]string {
+		delete(entries, "secret")
+		return entries
+	}
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantDisk map[string]string
+	}{
+		{name: "none", wantDisk: map[string]string{"a": "1", "secret": "2"}},
+		{name: "remove", opts: []Option{WithBeforeSave(scrub)}, wantDisk: map[string]string{"a": "1"}},
+		{name: "remove from the sorted store", opts: []Option{WithBeforeSave(scrub), WithSortedArrayStore()},
+			wantDisk: map[string]string{"a": "1"}},
+		{name: "annotate", opts: []Option{WithBeforeSave(func(entries map[string]string) map[string]string {
+			entries["saved-by"] = "test"
+			return entries
+		})}, wantDisk: map[string]string{"a": "1", "secret": "2", "saved-by": "test"}},
+		{name: "nil", opts: []Option{WithBeforeSave(func(map[string]string) map[string]string { return nil })},
+			wantDisk: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			fc.PutRaw("a", "1")
+			fc.PutRaw("secret", "2")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if disk := onDisk(t, fc); !reflect.DeepEqual(disk, tt.wantDisk) {
+				t.Errorf("the state-file has %v, expected %v", disk, tt.wantDisk)
+			}
+			if fc.Get("secret") != "2" || fc.Get("saved-by") != "" || fc.Size() != 2 {
+				t.Errorf("the hook changed the cache, Size is %d", fc.Size())
+			}
+		})
+	}
+}
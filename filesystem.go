@@ -0,0 +1,109 @@
+package pushstate
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// File is a file opened by a FileSystem
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Name() string
+	Stat() (fs.FileInfo, error)
+}
+
+// FileSystem is the file operations the cache needs, so the state-file can be kept elsewhere than
+// on the local disk, or in memory in tests.  The methods behave like their counterparts in os.
+// A File returned by CreateTemp that also has a Chown(uid, gid int) error method, is chowned when
+// WithPreserveOwnership is used. A FileSystem that also has a ReadDir(name string) ([]fs.DirEntry, error)
+// method, can have its stale temporary files removed by WithStaleTempCleanup, and one that also has an
+// EvalSymlinks(name string) (string, error) method, can follow symlinks with WithFollowSymlinks.
+type FileSystem interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	CreateTemp(dir, pattern string) (File, error)
+	Rename(oldpath, newpath string) error
+	Stat(name string) (fs.FileInfo, error)
+	Remove(name string) error
+	Chmod(name string, mode fs.FileMode) error
+}
+
+// OSFileSystem is the FileSystem of the operating system, which is the default
+type OSFileSystem struct{}
+
+// OpenFile calls os.OpenFile
+func (OSFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// Do not return a nil *os.File as a non-nil File
+		return nil, err
+	}
+	return f, nil
+}
+
+// CreateTemp calls os.CreateTemp
+func (OSFileSystem) CreateTemp(dir, pattern string) (File, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ReadDir calls os.ReadDir
+func (OSFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// EvalSymlinks calls filepath.EvalSymlinks
+func (OSFileSystem) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+// resolveSymlinks returns the path name refers to after following any symlinks, or name itself when
+// fsys can not follow them or the path does not exist yet
+func resolveSymlinks(fsys FileSystem, name string) string {
+	resolver, ok := fsys.(interface {
+		EvalSymlinks(name string) (string, error)
+	})
+	if !ok {
+		return name
+	}
+	target, err := resolver.EvalSymlinks(name)
+	if err != nil {
+		return name
+	}
+	return target
+}
+
+// Rename calls os.Rename
+func (OSFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Stat calls os.Stat
+func (OSFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Remove calls os.Remove
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Chmod calls os.Chmod
+func (OSFileSystem) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// openRead opens name read-only in fsys
+func openRead(fsys FileSystem, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
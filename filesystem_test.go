@@ -0,0 +1,175 @@
+package pushstate
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// memFileSystem is a FileSystem in memory
+type memFileSystem struct {
+	files map[string][]byte
+	temps int
+	// Protect the files
+	memLock sync.Mutex
+}
+
+func newMemFileSystem() *memFileSystem {
+	return &memFileSystem{files: map[string][]byte{}}
+}
+
+// memFile is a file of a memFileSystem; what is written to it is stored when it is closed
+type memFile struct {
+	fsys *memFileSystem
+	name string
+	r    *bytes.Reader
+	w    *bytes.Buffer
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+func (f *memFile) Close() error {
+	if f.w != nil {
+		f.fsys.memLock.Lock()
+		defer f.fsys.memLock.Unlock()
+
+		f.fsys.files[f.name] = f.w.Bytes()
+	}
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.fsys.Stat(f.name)
+}
+
+// memFileInfo is the fs.FileInfo of a file in a memFileSystem
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string       { return filepath.Base(fi.name) }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return 0640 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (m *memFileSystem) OpenFile(name string, flag int, _ fs.FileMode) (File, error) {
+	m.memLock.Lock()
+	defer m.memLock.Unlock()
+
+	b, ok := m.files[name]
+	if !ok && flag&os.O_CREATE == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f := &memFile{fsys: m, name: name, r: bytes.NewReader(b)}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		f.w = &bytes.Buffer{}
+	}
+	return f, nil
+}
+
+func (m *memFileSystem) CreateTemp(dir, pattern string) (File, error) {
+	m.memLock.Lock()
+	defer m.memLock.Unlock()
+
+	m.temps++
+	return &memFile{fsys: m, name: filepath.Join(dir, fmt.Sprintf("%s%d", pattern, m.temps)), w: &bytes.Buffer{}}, nil
+}
+
+func (m *memFileSystem) Rename(oldpath, newpath string) error {
+	m.memLock.Lock()
+	defer m.memLock.Unlock()
+
+	b, ok := m.files[oldpath]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = b
+	return nil
+}
+
+func (m *memFileSystem) Stat(name string) (fs.FileInfo, error) {
+	m.memLock.Lock()
+	defer m.memLock.Unlock()
+
+	b, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return memFileInfo{name: name, size: int64(len(b))}, nil
+}
+
+func (m *memFileSystem) Remove(name string) error {
+	m.memLock.Lock()
+	defer m.memLock.Unlock()
+
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFileSystem) Chmod(string, fs.FileMode) error {
+	return nil
+}
+
+func TestWithFileSystem(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "temp and rename"},
+		{name: "compressed", opts: []Option{WithCompression()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := newMemFileSystem()
+			filename := filepath.Join(t.TempDir(), "state.json")
+			opts := append([]Option{WithFileSystem(mem)}, tt.opts...)
+			fc := NewFileCache(filename, &checksum.Murmur3CheckSum{}, nil, opts...)
+			putAll(fc, "1", "a", "b")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if err := fc.Delete("b"); err != nil {
+				t.Fatalf("Delete failed; error = %v", err)
+			}
+			if _, err := os.Stat(filename); !os.IsNotExist(err) {
+				t.Errorf("the state-file is on the local disk; error = %v", err)
+			}
+			if len(mem.files) != 1 || mem.files[filename] == nil {
+				t.Errorf("the file system has %d files, expected only the state-file", len(mem.files))
+			}
+
+			c := NewFileCache(filename, &checksum.Murmur3CheckSum{}, nil, opts...)
+			if err := c.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if c.Size() != 1 || c.Get("a") != fc.Get("a") {
+				t.Errorf("Read got %v, expected only a", c.Entries())
+			}
+		})
+	}
+}
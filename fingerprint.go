@@ -0,0 +1,55 @@
+package pushstate
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// Fingerprint returns one check-sum over all the ids and their check-sums, computed with the cache's
+// checksum.CheckSum. Caches with the same content have the same fingerprint, whatever order the ids
+// were put in, so replicas can be compared without transferring their content. Sections and
+// metadata are not part of it.
+func (fc *FileCache) Fingerprint() (string, error) {
+	if fc.checkSum == nil {
+		return "", errors.New("fingerprint failed; error = no check-sum")
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if scs, ok := fc.checkSum.(StreamCheckSum); ok {
+		h := scs.NewHash()
+		if err := fc.writeFingerprint(h); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	buf := &bytes.Buffer{}
+	if err := fc.writeFingerprint(buf); err != nil {
+		return "", err
+	}
+	return fc.checkSum.SumBytes(buf.Bytes()), nil
+}
+
+// writeFingerprint writes the sorted ids and check-sums to w, one JSON-quoted pair per line so no
+// two different caches write the same bytes; the caller must hold the lock
+func (fc *FileCache) writeFingerprint(w io.Writer) error {
+	for _, id := range fc.entries.ids() {
+		cs, _ := fc.entries.get(id)
+		pair, err := json.Marshal([2]string{id, cs})
+		if err != nil {
+			return fmt.Errorf("fingerprint failed; error = %v", err)
+		}
+		if _, err = w.Write(append(pair, '\n')); err != nil {
+			return fmt.Errorf("fingerprint failed; error = %v", err)
+		}
+	}
+	return nil
+}
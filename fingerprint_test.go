@@ -0,0 +1,51 @@
+package pushstate
+
+import (
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		name      string
+		cs        func() checksum.CheckSum
+		other     func(fc *FileCache)
+		wantEqual bool
+	}{
+		{name: "other order", other: func(fc *FileCache) { fc.PutRaw("b", "2"); fc.PutRaw("a", "1") }, wantEqual: true},
+		{name: "stream check-sum", cs: func() checksum.CheckSum { return &SHA256StreamCheckSum{} },
+			other: func(fc *FileCache) { fc.PutRaw("b", "2"); fc.PutRaw("a", "1") }, wantEqual: true},
+		{name: "changed check-sum", other: func(fc *FileCache) { fc.PutRaw("a", "1"); fc.PutRaw("b", "3") }},
+		{name: "extra id", other: func(fc *FileCache) { fc.PutRaw("a", "1"); fc.PutRaw("b", "2"); fc.PutRaw("c", "3") }},
+		{name: "moved separator", other: func(fc *FileCache) { fc.PutRaw("a1", ""); fc.PutRaw("b", "2") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := func() checksum.CheckSum { return &checksum.Murmur3CheckSum{} }
+			if tt.cs != nil {
+				cs = tt.cs
+			}
+			fc := NewMemoryCache(cs(), nil)
+			fc.PutRaw("a", "1")
+			fc.PutRaw("b", "2")
+			other := NewMemoryCache(cs(), nil)
+			tt.other(other)
+			want, err := fc.Fingerprint()
+			if err != nil {
+				t.Fatalf("Fingerprint failed; error = %v", err)
+			}
+			got, err := other.Fingerprint()
+			if err != nil {
+				t.Fatalf("Fingerprint failed; error = %v", err)
+			}
+			if (got == want) != tt.wantEqual {
+				t.Errorf("fingerprints %s and %s are equal %t, expected %t", got, want, got == want, tt.wantEqual)
+			}
+		})
+	}
+}
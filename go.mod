@@ -5,6 +5,7 @@ go 1.19
 require (
 	github.com/tkandal/checksum v0.0.0-20210513065452-4cf534839115
 	go.uber.org/zap v1.18.1
+	golang.org/x/sync v0.7.0
 )
 
 require (
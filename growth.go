@@ -0,0 +1,24 @@
+package pushstate
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// checkGrowth warns when the cache has grown beyond the growth threshold, and rearms the warning when
+// it has shrunk well below it, so a size going back and forth over the threshold is not logged every
+// time; the caller must hold the lock
+func (fc *FileCache) checkGrowth() {
+	if fc.growthThreshold <= 0 {
+		return
+	}
+	size := int64(fc.entries.len())
+	if size <= fc.growthThreshold-fc.growthThreshold/10 {
+		fc.growthWarned = false
+		return
+	}
+	if size > fc.growthThreshold && !fc.growthWarned {
+		fc.growthWarned = true
+		fc.warnw(fc.log, "state-cache has grown beyond the threshold", "entries", size,
+			"threshold", fc.growthThreshold)
+	}
+}
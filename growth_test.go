@@ -0,0 +1,50 @@
+package pushstate
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithGrowthWarning(t *testing.T) {
+	tests := []struct {
+		name       string
+		threshold  int64
+		steps      []int
+		wantWarned int
+	}{
+		{name: "disabled", steps: []int{20}},
+		{name: "at the threshold", threshold: 10, steps: []int{10}},
+		{name: "beyond the threshold", threshold: 10, steps: []int{15}, wantWarned: 1},
+		{name: "around the threshold", threshold: 10, steps: []int{11, 10, 11, 10, 11}, wantWarned: 1},
+		{name: "shrunk and grown again", threshold: 10, steps: []int{11, 8, 11}, wantWarned: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			fc := newTestCache(t, WithGrowthWarning(tt.threshold))
+			fc.SetLogger(zap.New(core).Sugar())
+			// Each step puts or deletes the highest ids until the cache has that many
+			size := 0
+			for _, step := range tt.steps {
+				for ; size < step; size++ {
+					putAll(fc, "1", fmt.Sprint(size))
+				}
+				for ; size > step; size-- {
+					if err := fc.Delete(fmt.Sprint(size - 1)); err != nil {
+						t.Fatalf("Delete failed; error = %v", err)
+					}
+				}
+			}
+			if got := logs.FilterMessage("state-cache has grown beyond the threshold").Len(); got != tt.wantWarned {
+				t.Errorf("warned %d times, expected %d", got, tt.wantWarned)
+			}
+		})
+	}
+}
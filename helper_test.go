@@ -0,0 +1,55 @@
+package pushstate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// testModel is the model of the tests
+type testModel struct {
+	ID      string `json:"id"`
+	Payload string `json:"payload"`
+}
+
+func (m *testModel) GetID() string {
+	return m.ID
+}
+
+// newTestCache creates a cache with a state-file in a temporary directory of t
+func newTestCache(t *testing.T, opts ...Option) *FileCache {
+	t.Helper()
+	return NewFileCache(filepath.Join(t.TempDir(), "state.json"), &checksum.Murmur3CheckSum{}, nil, opts...)
+}
+
+// reopen creates a new cache with the state-file of fc, and reads it
+func reopen(t *testing.T, fc *FileCache, opts ...Option) *FileCache {
+	t.Helper()
+	c := NewFileCache(fc.filename, &checksum.Murmur3CheckSum{}, nil, opts...)
+	if err := c.Read(); err != nil {
+		t.Fatalf("Read failed; error = %v", err)
+	}
+	return c
+}
+
+// onDisk returns the check-sums in the state-file of fc
+func onDisk(t *testing.T, fc *FileCache) map[string]string {
+	t.Helper()
+	sf, _, err := readFile(OSFileSystem{}, fc.filename)
+	if err != nil {
+		t.Fatalf("read %s failed; error = %v", fc.filename, err)
+	}
+	return sf.Entries
+}
+
+// putAll puts a model with the payload for every id
+func putAll(fc *FileCache, payload string, ids ...string) {
+	for _, id := range ids {
+		fc.Put(&testModel{ID: id, Payload: payload})
+	}
+}
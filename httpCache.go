@@ -0,0 +1,210 @@
+package pushstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// HTTPCache is a Cacher that calls a remote Handler, so several processes can share one cache.
+// Models are sent as JSON, and their check-sums are computed by the remote cache.
+// The methods of Cacher without an error return log failures; IsChanged then returns true, so a
+// model is rather pushed once too often than not at all.
+type HTTPCache struct {
+	baseURL string
+	client  *http.Client
+	log     *zap.SugaredLogger
+	retries int
+	backoff time.Duration
+}
+
+// HTTPOption configures an HTTPCache
+type HTTPOption func(*HTTPCache)
+
+// WithHTTPRetries retries a request up to n times when it fails or the server responds with a 5xx
+// status, waiting backoff before the first retry and twice as long before each next
+func WithHTTPRetries(n int, backoff time.Duration) HTTPOption {
+	return func(hc *HTTPCache) {
+		hc.retries = n
+		hc.backoff = backoff
+	}
+}
+
+// NewHTTPCache creates a cache that calls the Handler at baseURL; a nil client is replaced by
+// http.DefaultClient and a nil log by a nop logger
+func NewHTTPCache(baseURL string, client *http.Client, log *zap.SugaredLogger, opts ...HTTPOption) *HTTPCache {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+	hc := &HTTPCache{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+		log:     log,
+	}
+	for _, opt := range opts {
+		opt(hc)
+	}
+	return hc
+}
+
+// IsChanged asks the remote cache whether the model is new or changed
+func (hc *HTTPCache) IsChanged(pm PushModel) bool {
+	body, err := json.Marshal(pm)
+	if err != nil {
+		hc.log.Errorf("encode %s failed; error = %v", pm.GetID(), err)
+		return true
+	}
+	resp := &isChangedResponse{}
+	if err = hc.doJSON(http.MethodPost, routeIsChanged+url.PathEscape(pm.GetID()), body, resp); err != nil {
+		hc.log.Warnf("is-changed %s failed, assume changed; error = %v", pm.GetID(), err)
+		return true
+	}
+	return resp.Changed
+}
+
+// Put stores the check-sum of the model in the remote cache
+func (hc *HTTPCache) Put(pm PushModel) {
+	body, err := json.Marshal(pm)
+	if err != nil {
+		hc.log.Errorf("encode %s failed; error = %v", pm.GetID(), err)
+		return
+	}
+	if _, err = hc.do(http.MethodPut, routeEntries+url.PathEscape(pm.GetID()), body); err != nil {
+		hc.log.Errorf("put %s failed; error = %v", pm.GetID(), err)
+	}
+}
+
+// Read makes the remote cache read its check-sums
+func (hc *HTTPCache) Read() error {
+	_, err := hc.do(http.MethodPost, routeRead, nil)
+	return err
+}
+
+// Save makes the remote cache save its check-sums
+func (hc *HTTPCache) Save() error {
+	_, err := hc.do(http.MethodPost, routeSave, nil)
+	return err
+}
+
+// Size returns the number of check-sums in the remote cache, or 0 when the request fails
+func (hc *HTTPCache) Size() int64 {
+	resp := &sizeResponse{}
+	if err := hc.doJSON(http.MethodGet, routeSize, nil, resp); err != nil {
+		hc.log.Errorf("size failed; error = %v", err)
+		return 0
+	}
+	return resp.Size
+}
+
+// Get returns the check-sum for the given id, or "" when the request fails
+func (hc *HTTPCache) Get(id string) string {
+	resp := &getResponse{}
+	if err := hc.doJSON(http.MethodGet, routeEntries+url.PathEscape(id), nil, resp); err != nil {
+		hc.log.Errorf("get %s failed; error = %v", id, err)
+		return ""
+	}
+	return resp.Checksum
+}
+
+// Delete deletes the check-sum for the given id in the remote cache
+func (hc *HTTPCache) Delete(id string) error {
+	_, err := hc.do(http.MethodDelete, routeEntries+url.PathEscape(id), nil)
+	return err
+}
+
+// Reset empties the remote cache
+func (hc *HTTPCache) Reset() error {
+	_, err := hc.do(http.MethodPost, routeReset, nil)
+	return err
+}
+
+// Dump dumps the whole content of the remote cache to an io.Reader
+func (hc *HTTPCache) Dump() (io.Reader, error) {
+	b, err := hc.do(http.MethodGet, routeDump, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// WriteTo writes the whole content of the remote cache to w
+func (hc *HTTPCache) WriteTo(w io.Writer) (int64, error) {
+	b, err := hc.do(http.MethodGet, routeDump, nil)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (hc *HTTPCache) doJSON(method string, route string, body []byte, v interface{}) error {
+	b, err := hc.do(method, route, body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return &CacheError{Op: "decode response from", Path: hc.baseURL + route, Err: err}
+	}
+	return nil
+}
+
+// do sends the request, retrying as configured, and returns the body of a 2xx response.
+// A response with another status is returned as a *CacheError.
+func (hc *HTTPCache) do(method string, route string, body []byte) ([]byte, error) {
+	u := hc.baseURL + route
+	backoff := hc.backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		var b []byte
+		var retry bool
+		b, retry, err = hc.doOnce(method, u, body)
+		if err == nil {
+			return b, nil
+		}
+		if !retry || attempt >= hc.retries {
+			return nil, &CacheError{Op: method, Path: u, Err: err}
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// doOnce sends the request once, and returns whether a failure may be retried
+func (hc *HTTPCache) doOnce(method string, u string, body []byte) ([]byte, bool, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, resp.StatusCode >= 500, fmt.Errorf("unexpected status %s; body = %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return b, false, nil
+}
@@ -0,0 +1,72 @@
+package pushstate
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestHTTPCache(t *testing.T) {
+	fc := newTestCache(t)
+	srv := httptest.NewServer(Handler(fc))
+	defer srv.Close()
+	hc := NewHTTPCache(srv.URL+"/", srv.Client(), nil)
+
+	m := &testModel{ID: "a/b", Payload: "1"}
+	if !hc.IsChanged(m) {
+		t.Error("a new model is not changed")
+	}
+	hc.Put(m)
+	if hc.IsChanged(m) || hc.Get("a/b") != fc.Get("a/b") || hc.Size() != 1 {
+		t.Errorf("the remote cache does not have the model, it has %v", fc.Entries())
+	}
+	if err := hc.Delete("a/b"); err != nil || fc.Size() != 0 {
+		t.Errorf("Delete returned %v and left %d entries", err, fc.Size())
+	}
+}
+
+func TestHTTPCacheRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		retries      int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "no failures", retries: 2, wantRequests: 1},
+		{name: "retried", failures: 2, retries: 2, wantRequests: 3},
+		{name: "too many failures", failures: 3, retries: 2, wantErr: true, wantRequests: 3},
+		{name: "no retries", failures: 1, wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			h := Handler(newTestCache(t))
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= tt.failures {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				h.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+
+			hc := NewHTTPCache(srv.URL, srv.Client(), nil, WithHTTPRetries(tt.retries, time.Millisecond))
+			err := hc.Reset()
+			var ce *CacheError
+			if errors.As(err, &ce) != tt.wantErr {
+				t.Errorf("Reset returned %v, expected a *CacheError %t", err, tt.wantErr)
+			}
+			if n := atomic.LoadInt32(&requests); n != tt.wantRequests {
+				t.Errorf("sent %d requests, expected %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
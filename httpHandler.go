@@ -0,0 +1,203 @@
+package pushstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// The routes served by Handler and used by HTTPCache
+const (
+	routeEntries   = "/entries/"
+	routeIsChanged = "/is-changed/"
+	routeSize      = "/size"
+	routeRead      = "/read"
+	routeSave      = "/save"
+	routeReset     = "/reset"
+	routeDump      = "/dump"
+)
+
+// rawModel is a PushModel received as JSON; it encodes to the same JSON, so its check-sum is the
+// same as the check-sum of the model it was encoded from
+type rawModel struct {
+	id  string
+	raw json.RawMessage
+}
+
+func (m *rawModel) GetID() string {
+	return m.id
+}
+
+func (m *rawModel) MarshalJSON() ([]byte, error) {
+	return m.raw, nil
+}
+
+type sizeResponse struct {
+	Size int64 `json:"size"`
+}
+
+type isChangedResponse struct {
+	Changed bool `json:"changed"`
+}
+
+type getResponse struct {
+	Checksum string `json:"checksum"`
+}
+
+// HandlerOption configures a Handler
+type HandlerOption func(*handlerConfig)
+
+type handlerConfig struct {
+	log *zap.SugaredLogger
+}
+
+// WithHandlerLogger logs the failures that can not be returned to the client, e.g. a dump that fails
+// after it has started; the default is a nop logger
+func WithHandlerLogger(log *zap.SugaredLogger) HandlerOption {
+	return func(hc *handlerConfig) {
+		if log != nil {
+			hc.log = log
+		}
+	}
+}
+
+// startedWriter records whether anything is written to w; even an empty write sends the header
+type startedWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (sw *startedWriter) Write(p []byte) (int, error) {
+	sw.started = true
+	return sw.w.Write(p)
+}
+
+// Handler serves c over HTTP, for HTTPCache:
+//
+//	GET    /entries/{id}     the check-sum of id
+//	PUT    /entries/{id}     put the model in the body
+//	DELETE /entries/{id}     delete id
+//	POST   /is-changed/{id}  whether the model in the body is new or changed
+//	GET    /size             the number of check-sums
+//	POST   /read, /save, /reset
+//	GET    /dump             the whole content
+//
+// Failures are returned as 500 with the error as the body. A dump that fails after some of it is sent
+// can not change the status any more; the failure is logged, and the response is aborted so the client
+// does not take the part that was sent for the whole content.
+func Handler(c Cacher, opts ...HandlerOption) http.Handler {
+	cfg := &handlerConfig{log: zap.NewNop().Sugar()}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case strings.HasPrefix(path, routeEntries):
+			serveEntry(c, w, r, strings.TrimPrefix(path, routeEntries))
+		case strings.HasPrefix(path, routeIsChanged):
+			if !allowMethod(w, r, http.MethodPost) {
+				return
+			}
+			m, ok := readModel(w, r, strings.TrimPrefix(path, routeIsChanged))
+			if ok {
+				writeJSON(w, &isChangedResponse{Changed: c.IsChanged(m)})
+			}
+		case path == routeSize:
+			if allowMethod(w, r, http.MethodGet) {
+				writeJSON(w, &sizeResponse{Size: c.Size()})
+			}
+		case path == routeRead:
+			serveAction(w, r, c.Read)
+		case path == routeSave:
+			serveAction(w, r, c.Save)
+		case path == routeReset:
+			serveAction(w, r, c.Reset)
+		case path == routeDump:
+			if !allowMethod(w, r, http.MethodGet) {
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			sw := &startedWriter{w: w}
+			n, err := c.WriteTo(sw)
+			if err == nil {
+				return
+			}
+			if !sw.started {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// The status is already sent when the copy fails half-way
+			cfg.log.Warnw("dump failed after it was started", "bytes", n, "error", err)
+			panic(http.ErrAbortHandler)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func serveEntry(c Cacher, w http.ResponseWriter, r *http.Request, id string) {
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, &getResponse{Checksum: c.Get(id)})
+	case http.MethodPut:
+		if m, ok := readModel(w, r, id); ok {
+			c.Put(m)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case http.MethodDelete:
+		if err := c.Delete(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func serveAction(w http.ResponseWriter, r *http.Request, action func() error) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if err := action(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func readModel(w http.ResponseWriter, r *http.Request, id string) (PushModel, bool) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, fmt.Sprintf("decode model failed; error = %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	return &rawModel{id: id, raw: raw}, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
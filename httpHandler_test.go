@@ -0,0 +1,79 @@
+package pushstate
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// failingDump is a Cacher whose WriteTo writes prefix, if any, and then fails
+type failingDump struct {
+	Cacher
+	prefix string
+}
+
+func (f *failingDump) WriteTo(w io.Writer) (int64, error) {
+	if f.prefix == "" {
+		return 0, errors.New("dump broke")
+	}
+	n, _ := io.WriteString(w, f.prefix)
+	return int64(n), errors.New("dump broke")
+}
+
+func TestHandlerDumpFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		wantCode int
+		wantBody string
+		wantLog  int
+	}{
+		{name: "before any write", prefix: "", wantCode: http.StatusInternalServerError, wantBody: "dump broke\n"},
+		{name: "half-way", prefix: `{"entries":{`, wantLog: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			h := Handler(&failingDump{Cacher: newTestCache(t), prefix: tt.prefix}, WithHandlerLogger(zap.New(core).Sugar()))
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/dump")
+			if tt.wantCode == 0 {
+				// The aborted response must not read as a complete dump
+				if err == nil {
+					_, err = io.ReadAll(resp.Body)
+					_ = resp.Body.Close()
+				}
+				if err == nil {
+					t.Fatal("a dump that failed half-way was read without error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("GET /dump failed; error = %v", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				if resp.StatusCode != tt.wantCode || string(body) != tt.wantBody {
+					t.Errorf("got %d %q; want %d %q", resp.StatusCode, body, tt.wantCode, tt.wantBody)
+				}
+			}
+			if logs.Len() != tt.wantLog {
+				t.Fatalf("got %d warnings; want %d", logs.Len(), tt.wantLog)
+			}
+			if tt.wantLog > 0 && !strings.Contains(logs.All()[0].Message, "dump failed") {
+				t.Errorf("unexpected warning %q", logs.All()[0].Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package pushstate
+
+import (
+	"context"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// Ingest puts the models received from ch until ch is closed or ctx is done, and returns the number
+// of models stored; a model that is refused, e.g. after Drain, is logged, and a denied id is not
+// counted either.  With WithIngestOnlyChanged only new or changed models are put and counted, and
+// with WithSaveEvery the cache is saved periodically and when Ingest returns.  When ctx is done,
+// Ingest returns the context's error, or the error of the last save.
+func (fc *FileCache) Ingest(ctx context.Context, ch <-chan PushModel) (int, error) {
+	fc.cacheLock.Lock()
+	saveEvery := fc.saveEvery
+	fc.cacheLock.Unlock()
+
+	var tick <-chan time.Time
+	if saveEvery > 0 {
+		ticker := time.NewTicker(saveEvery)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	count := 0
+	for {
+		select {
+		case <-ctx.Done():
+			if err := fc.saveIngested(saveEvery); err != nil {
+				return count, err
+			}
+			return count, ctx.Err()
+		case <-tick:
+			if err := fc.saveIngested(saveEvery); err != nil {
+				return count, err
+			}
+		case m, ok := <-ch:
+			if !ok {
+				return count, fc.saveIngested(saveEvery)
+			}
+			if fc.ingest(m) {
+				count++
+			}
+		}
+	}
+}
+
+// ingest puts m, unless only changed models are ingested and m is not, and returns whether it was
+// stored; a denied id, or one that fails to put, is not
+func (fc *FileCache) ingest(m PushModel) bool {
+	if err := fc.checkID(m); err != nil {
+		fc.warnw(fc.logger(), "ingest of model failed", "error", err)
+		return false
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.ingestOnlyChanged {
+		if changed, _ := fc.changeStatus(m); !changed {
+			return false
+		}
+	}
+	stored, err := fc.putModel(m)
+	if err != nil {
+		fc.warnw(fc.log, "ingest of model failed", "error", err)
+	}
+	return stored
+}
+
+// saveIngested saves the cache when it is dirty and Ingest is configured to save
+func (fc *FileCache) saveIngested(saveEvery time.Duration) error {
+	if saveEvery <= 0 {
+		return nil
+	}
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if !fc.isDirty {
+		return nil
+	}
+	if err := fc.saveToFile(fc.filename, fc.entries); err != nil {
+		return err
+	}
+	fc.isDirty = false
+	return nil
+}
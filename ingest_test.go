@@ -0,0 +1,71 @@
+package pushstate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestIngest(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		prefix []string
+		models []string
+		want   int
+		dirty  bool
+	}{
+		{name: "all", models: []string{"a", "b", "c"}, want: 3, dirty: true},
+		{name: "denied are not counted", opts: []Option{WithDenyList("b")}, models: []string{"a", "b"}, want: 1, dirty: true},
+		{name: "only denied", opts: []Option{WithDenyList("a")}, models: []string{"a"}, want: 0},
+		{name: "only changed", opts: []Option{WithIngestOnlyChanged()}, prefix: []string{"a"}, models: []string{"a", "b"}, want: 1, dirty: true},
+		{name: "too long", opts: []Option{WithMaxChecksumLen(4)}, models: []string{"a"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", tt.prefix...)
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			ch := make(chan PushModel, len(tt.models))
+			for _, id := range tt.models {
+				ch <- &testModel{ID: id, Payload: "1"}
+			}
+			close(ch)
+			n, err := fc.Ingest(context.Background(), ch)
+			if err != nil {
+				t.Fatalf("Ingest failed; error = %v", err)
+			}
+			if n != tt.want {
+				t.Errorf("Ingest returned %d, expected %d", n, tt.want)
+			}
+			if fc.IsDirty() != tt.dirty {
+				t.Errorf("the cache is dirty %v, expected %v", fc.IsDirty(), tt.dirty)
+			}
+		})
+	}
+}
+
+func TestIngestSaveEvery(t *testing.T) {
+	fc := newTestCache(t, WithSaveEvery(time.Hour))
+	ch := make(chan PushModel)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := fc.Ingest(ctx, ch)
+		done <- err
+	}()
+	ch <- &testModel{ID: "a", Payload: "1"}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Ingest returned %v, expected %v", err, context.Canceled)
+	}
+	if onDisk(t, fc)["a"] == "" {
+		t.Errorf("Ingest did not save when ctx was done")
+	}
+}
@@ -0,0 +1,51 @@
+package pushstate
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// stringPool lets equal check-sums share one backing string, which saves memory in caches
+// where many ids have the same check-sum
+type stringPool struct {
+	strs map[string]*pooledString
+}
+
+type pooledString struct {
+	str  string
+	refs int
+}
+
+func newStringPool() *stringPool {
+	return &stringPool{strs: map[string]*pooledString{}}
+}
+
+// intern returns the pooled copy of s and counts a reference to it. The entry is only assigned when
+// s is new, since assigning it again would make the map's key refer to the caller's copy of s.
+func (p *stringPool) intern(s string) string {
+	ps, ok := p.strs[s]
+	if !ok {
+		ps = &pooledString{str: s}
+		p.strs[s] = ps
+	}
+	ps.refs++
+	return ps.str
+}
+
+// release drops a reference to s, and removes s from the pool when it is no longer referenced
+func (p *stringPool) release(s string) {
+	ps, ok := p.strs[s]
+	if !ok {
+		return
+	}
+	ps.refs--
+	if ps.refs <= 0 {
+		delete(p.strs, s)
+	}
+}
+
+// internAll replaces every value in cache with its pooled copy
+func (p *stringPool) internAll(cache map[string]string) {
+	for id, cs := range cache {
+		cache[id] = p.intern(cs)
+	}
+}
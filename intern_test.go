@@ -0,0 +1,111 @@
+package pushstate
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// stringData returns the address of the bytes of s
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+// freshString returns a copy of s with bytes of its own
+func freshString(s string) string {
+	return string([]byte(s))
+}
+
+func TestStringPool(t *testing.T) {
+	p := newStringPool()
+	first := p.intern(freshString("checksum"))
+	for i := 0; i < 10; i++ {
+		if s := p.intern(freshString("checksum")); stringData(s) != stringData(first) {
+			t.Fatalf("intern %d returned a copy of its own", i)
+		}
+	}
+	for key, ps := range p.strs {
+		if stringData(key) != stringData(ps.str) {
+			t.Errorf("the key of the pool refers to another copy than the pooled string")
+		}
+		if ps.refs != 11 {
+			t.Errorf("the pooled string has %d references, expected 11", ps.refs)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		p.release("checksum")
+	}
+	if len(p.strs) != 1 {
+		t.Fatalf("the pooled string was removed while still referenced")
+	}
+	p.release("checksum")
+	if len(p.strs) != 0 {
+		t.Errorf("the pooled string was not removed when no longer referenced")
+	}
+	p.release("unknown")
+}
+
+func TestCacheSharesCheckSums(t *testing.T) {
+	fc := newTestCache(t)
+	for i := 0; i < 100; i++ {
+		fc.PutRaw(fmt.Sprintf("id-%d", i), freshString("same"))
+	}
+	sums := fc.entries.(*mapStore).sums
+	want := stringData(sums["id-0"])
+	for id, cs := range sums {
+		if stringData(cs) != want {
+			t.Fatalf("%s has a check-sum of its own", id)
+		}
+	}
+}
+
+// heapInUse returns the bytes of the live heap after a garbage collection
+func heapInUse() uint64 {
+	runtime.GC()
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func BenchmarkInternDuplicates(b *testing.B) {
+	const ids = 100000
+	sums := make([]string, 10)
+	for i := range sums {
+		sums[i] = fmt.Sprintf("%064d", i)
+	}
+	benchmarks := []struct {
+		name   string
+		intern bool
+	}{
+		{name: "pooled", intern: true},
+		{name: "unpooled", intern: false},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			var retained uint64
+			for n := 0; n < b.N; n++ {
+				before := heapInUse()
+				p := newStringPool()
+				cache := make(map[string]string, ids)
+				for i := 0; i < ids; i++ {
+					cs := freshString(sums[i%len(sums)])
+					if bm.intern {
+						cs = p.intern(cs)
+					}
+					cache[fmt.Sprintf("id-%d", i)] = cs
+				}
+				retained += heapInUse() - before
+				runtime.KeepAlive(cache)
+				runtime.KeepAlive(p)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N)/ids, "heap-B/entry")
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package pushstate
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// LockedAccess gives access to the cache while WithLock holds its lock
+type LockedAccess interface {
+	// Get returns the check-sum for the given id
+	Get(id string) string
+	// Put puts the model's check-sum in the cache
+	Put(m PushModel)
+	// Delete deletes the check-sum for the given id in memory; it is saved with the next Save
+	Delete(id string)
+	// IsChanged checks if the model is new or changed
+	IsChanged(m PushModel) bool
+}
+
+type lockedAccess struct {
+	fc *FileCache
+}
+
+// WithLock calls fn while holding the cache's lock, so several operations on the accessor are atomic
+// together, e.g. an IsChanged followed by a conditional Put.
+// The accessor must not be used after fn returns, and fn must not call the cache itself, since that
+// deadlocks.
+func (fc *FileCache) WithLock(fn func(LockedAccess)) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	fn(&lockedAccess{fc: fc})
+}
+
+func (la *lockedAccess) Get(id string) string {
+	cs, _ := la.fc.entries.get(la.fc.key(id))
+	return cs
+}
+
+func (la *lockedAccess) Put(m PushModel) {
+	if err := la.fc.checkID(m); err != nil {
+		la.fc.warnw(la.fc.log, "put of model failed", "error", err)
+		return
+	}
+	if _, err := la.fc.putModel(m); err != nil {
+		la.fc.warnw(la.fc.log, "put of model failed", "error", err)
+	}
+}
+
+func (la *lockedAccess) Delete(id string) {
+	if la.fc.draining {
+		la.fc.warnw(la.fc.log, "delete check-sum failed", "id", id, "error", ErrDraining)
+		return
+	}
+	la.fc.deleteCheckSum(la.fc.key(id))
+	la.fc.markDirty()
+}
+
+func (la *lockedAccess) IsChanged(m PushModel) bool {
+	if err := la.fc.checkID(m); err != nil {
+		la.fc.warnw(la.fc.log, "check of model failed", "error", err)
+		return true
+	}
+	changed, _ := la.fc.changeStatus(m)
+	return changed
+}
@@ -0,0 +1,65 @@
+package pushstate
+
+import (
+	"sync"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithLock(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       func(la LockedAccess)
+		wantA    bool
+		wantSize int64
+	}{
+		{name: "put if changed", fn: func(la LockedAccess) {
+			m := &testModel{ID: "b", Payload: "1"}
+			if la.IsChanged(m) {
+				la.Put(m)
+			}
+		}, wantA: true, wantSize: 2},
+		{name: "delete", fn: func(la LockedAccess) { la.Delete("a") }, wantSize: 0},
+		{name: "get", fn: func(la LockedAccess) {
+			if la.Get("a") == "" {
+				la.Delete("a")
+			}
+		}, wantA: true, wantSize: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a")
+			fc.WithLock(tt.fn)
+			if (fc.Get("a") != "") != tt.wantA || fc.Size() != tt.wantSize {
+				t.Errorf("a is there %t and Size is %d, expected %t and %d", fc.Get("a") != "", fc.Size(), tt.wantA, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestWithLockIsAtomic(t *testing.T) {
+	fc := newTestCache(t)
+	puts := 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fc.WithLock(func(la LockedAccess) {
+				m := &testModel{ID: "a", Payload: "1"}
+				if la.IsChanged(m) {
+					la.Put(m)
+					puts++
+				}
+			})
+		}()
+	}
+	wg.Wait()
+	if puts != 1 {
+		t.Errorf("the model was put %d times, expected once", puts)
+	}
+}
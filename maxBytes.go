@@ -0,0 +1,109 @@
+package pushstate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// encodedSize returns the number of bytes sf is written as
+func encodedSize(sf *stateFile, wo writeOptions) (int64, error) {
+	cw := &countingWriter{w: io.Discard}
+	if err := encodeStateFile(cw, sf, wo); err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
+
+// fitMaxFileBytes checks that sf is written in at most the configured number of bytes, and evicts
+// the least recently updated, unpinned entries until it is when spill eviction is configured.
+// Evicted entries are removed from a copy of the entries of sf, and returned in the order they were
+// evicted, so evict can remove them from the store once the save succeeds; the caller must hold the
+// lock.
+func (fc *FileCache) fitMaxFileBytes(sf *stateFile, wo writeOptions) ([]Entry, error) {
+	size, err := encodedSize(sf, wo)
+	if err != nil {
+		return nil, &CacheError{Op: "encode", Path: fc.filename, Err: err}
+	}
+	if size <= fc.maxFileBytes {
+		return nil, nil
+	}
+	if !fc.spillEviction {
+		return nil, &CacheError{Op: "save", Path: fc.filename, Err: fmt.Errorf("%w; %d bytes exceeds the maximum of %d bytes",
+			ErrCacheFull, size, fc.maxFileBytes)}
+	}
+
+	candidates := make([]string, 0, len(sf.Entries))
+	for id := range sf.Entries {
+		if !fc.pinned[id] {
+			candidates = append(candidates, id)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ti, tj := sf.Meta[candidates[i]].UpdatedAt, sf.Meta[candidates[j]].UpdatedAt
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return candidates[i] < candidates[j]
+	})
+
+	// The entries of sf may be the store's own maps, which must not change before the save succeeds
+	entries := copyMap(sf.Entries)
+	meta := make(map[string]entryMeta, len(sf.Meta))
+	for id, m := range sf.Meta {
+		meta[id] = m
+	}
+	sf.Entries, sf.Meta = entries, meta
+
+	var evicted []Entry
+	for size > fc.maxFileBytes {
+		if len(candidates) == 0 {
+			return nil, &CacheError{Op: "save", Path: fc.filename, Err: fmt.Errorf("%w; %d bytes exceeds the maximum of %d bytes after eviction",
+				ErrCacheFull, size, fc.maxFileBytes)}
+		}
+		// Evict about as many entries as the excess bytes make up on average, and measure again
+		perEntry := size / int64(len(sf.Entries))
+		if perEntry < 1 {
+			perEntry = 1
+		}
+		n := int((size - fc.maxFileBytes + perEntry - 1) / perEntry)
+		if n > len(candidates) {
+			n = len(candidates)
+		}
+		for _, id := range candidates[:n] {
+			evicted = append(evicted, Entry{ID: id, Checksum: sf.Entries[id]})
+			delete(sf.Entries, id)
+			delete(sf.Meta, id)
+		}
+		candidates = candidates[n:]
+
+		if size, err = encodedSize(sf, wo); err != nil {
+			return nil, &CacheError{Op: "encode", Path: fc.filename, Err: err}
+		}
+	}
+	return evicted, nil
+}
+
+// evict removes the entries job evicted to fit WithMaxFileBytes from the store it saved, after the
+// state-file without them is written. An entry that was put again since, or whose check-sum is one of
+// WithBeforeSave, is kept in the store. The caller must hold the lock.
+func (fc *FileCache) evict(job *saveJob) {
+	if len(job.evicted) == 0 {
+		return
+	}
+	for _, e := range job.evicted {
+		if fc.beforeSave == nil {
+			if cs, ok := job.store.get(e.ID); !ok || cs != e.Checksum {
+				continue
+			}
+			job.store.remove(e.ID)
+		}
+		fc.subs.publish(ChangeEvent{ID: e.ID, Kind: Deleted, OldChecksum: e.Checksum})
+	}
+	fc.warnw(fc.log, fmt.Sprintf("evicted check-sums to fit %s within %d bytes", job.filename, fc.maxFileBytes),
+		"evicted", len(job.evicted), "entries", len(job.sf.Entries))
+}
@@ -0,0 +1,111 @@
+package pushstate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithMaxFileBytes(t *testing.T) {
+	// The size of a file with three entries, which is the maximum of the tests
+	probe := newTestCache(t)
+	putAll(probe, "1", "id-7", "id-8", "id-9")
+	if err := probe.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(probe.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	max := info.Size() + 40
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		pin      string
+		wantErr  error
+		wantKept []string
+	}{
+		{name: "full", wantErr: ErrCacheFull},
+		{name: "spill", opts: []Option{WithSpillEviction()}, wantKept: []string{"id-7", "id-8", "id-9"}},
+		{name: "spill pinned", opts: []Option{WithSpillEviction()}, pin: "id-0", wantKept: []string{"id-0", "id-9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			fc := newTestCache(t, append(tt.opts, WithMaxFileBytes(max))...)
+			fc.now = func() time.Time { return now }
+			for i := 0; i < 10; i++ {
+				now = now.Add(time.Second)
+				putAll(fc, "1", fmt.Sprintf("id-%d", i))
+			}
+			if tt.pin != "" {
+				fc.Pin(tt.pin)
+			}
+			if err := fc.Save(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Save returned %v, expected %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if _, err := os.Stat(fc.filename); !os.IsNotExist(err) {
+					t.Errorf("a failed save wrote the file; error = %v", err)
+				}
+				return
+			}
+			info, err := os.Stat(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() > max {
+				t.Errorf("the file has %d bytes, expected at most %d", info.Size(), max)
+			}
+			for _, id := range tt.wantKept {
+				if fc.Get(id) == "" || onDisk(t, fc)[id] == "" {
+					t.Errorf("%s was evicted", id)
+				}
+			}
+			if fc.Get("id-1") != "" {
+				t.Error("id-1, the least recently updated, was not evicted")
+			}
+		})
+	}
+}
+
+func TestWithMaxFileBytesFailedSave(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		max     int64
+		pin     string
+		wantErr error
+	}{
+		{name: "full after eviction", max: 10, pin: "id-0", wantErr: ErrCacheFull},
+		{name: "write fails", opts: []Option{WithFileSystem(readOnlyFileSystem{})}, max: 200, wantErr: fs.ErrPermission},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, append(tt.opts, WithMaxFileBytes(tt.max), WithSpillEviction())...)
+			for i := 0; i < 10; i++ {
+				putAll(fc, "1", fmt.Sprintf("id-%d", i))
+			}
+			if tt.pin != "" {
+				fc.Pin(tt.pin)
+			}
+			if err := fc.Save(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Save returned %v, expected %v", err, tt.wantErr)
+			}
+			if n := fc.Size(); n != 10 {
+				t.Errorf("Size is %d after a failed save, expected the 10 entries to be kept", n)
+			}
+			if !fc.IsDirty() {
+				t.Error("the cache is not dirty after a failed save")
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package pushstate
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// MaxIDLenCache is a Cacher that refuses ids longer than a maximum before they reach the wrapped
+// Cacher, so a backend with a key length limit fails at the call site with ErrIDTooLong instead of
+// somewhere far from it.  Delete and PutE return the error; Put logs it and stores nothing, Get
+// returns an empty check-sum and IsChanged logs it and reports the model as changed, as for any
+// model that can not be checked.  The warnings are throttled to one per maxIDLenLogWindow, since a
+// feed with long ids tends to have many of them.
+type MaxIDLenCache struct {
+	cacher   Cacher
+	maxLen   int
+	log      *zap.SugaredLogger
+	throttle *logThrottle
+	now      func() time.Time
+}
+
+// maxIDLenLogWindow is the window identical warnings of MaxIDLenCache are collapsed within
+const maxIDLenLogWindow = time.Minute
+
+// NewMaxIDLenCache wraps c and refuses ids longer than maxLen bytes; a nil log is replaced by a nop logger
+func NewMaxIDLenCache(c Cacher, maxLen int, log *zap.SugaredLogger) *MaxIDLenCache {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+	return &MaxIDLenCache{
+		cacher:   c,
+		maxLen:   maxLen,
+		log:      log,
+		throttle: &logThrottle{window: maxIDLenLogWindow, messages: map[string]*throttled{}},
+		now:      time.Now,
+	}
+}
+
+// warnw logs a throttled warning about id, which is too long
+func (mc *MaxIDLenCache) warnw(msg string, id string) {
+	mc.throttle.warnw(mc.log, mc.now(), msg, "bytes", len(id), "max", mc.maxLen, "error", ErrIDTooLong)
+}
+
+// checkID returns ErrIDTooLong when id is longer than the maximum
+func (mc *MaxIDLenCache) checkID(id string) error {
+	if len(id) > mc.maxLen {
+		return fmt.Errorf("%w; %d bytes, at most %d", ErrIDTooLong, len(id), mc.maxLen)
+	}
+	return nil
+}
+
+// IsChanged checks if the model is new or changed
+func (mc *MaxIDLenCache) IsChanged(m PushModel) bool {
+	if mc.checkID(m.GetID()) != nil {
+		mc.warnw("check of model with too long id", m.GetID())
+		return true
+	}
+	return mc.cacher.IsChanged(m)
+}
+
+// Put puts the model's check-sum in the cache
+func (mc *MaxIDLenCache) Put(m PushModel) {
+	if mc.PutE(m) != nil {
+		mc.warnw("put of model with too long id", m.GetID())
+	}
+}
+
+// PutE puts the model's check-sum in the cache, and returns ErrIDTooLong when its id is too long
+func (mc *MaxIDLenCache) PutE(m PushModel) error {
+	if err := mc.checkID(m.GetID()); err != nil {
+		return err
+	}
+	mc.cacher.Put(m)
+	return nil
+}
+
+// Read reads the check-sums from persistent storage
+func (mc *MaxIDLenCache) Read() error {
+	return mc.cacher.Read()
+}
+
+// Save saves the check-sums to persistent storage
+func (mc *MaxIDLenCache) Save() error {
+	return mc.cacher.Save()
+}
+
+// Size returns the number of check-sums
+func (mc *MaxIDLenCache) Size() int64 {
+	return mc.cacher.Size()
+}
+
+// Get returns the check-sum for the given id, which is empty when the id is too long
+func (mc *MaxIDLenCache) Get(id string) string {
+	if mc.checkID(id) != nil {
+		return ""
+	}
+	return mc.cacher.Get(id)
+}
+
+// Delete deletes the check-sum for the given id
+func (mc *MaxIDLenCache) Delete(id string) error {
+	if err := mc.checkID(id); err != nil {
+		return err
+	}
+	return mc.cacher.Delete(id)
+}
+
+// Reset empties the cache
+func (mc *MaxIDLenCache) Reset() error {
+	return mc.cacher.Reset()
+}
+
+// Dump dumps the whole content to an io.Reader
+func (mc *MaxIDLenCache) Dump() (io.Reader, error) {
+	return mc.cacher.Dump()
+}
+
+// WriteTo writes the whole content to w
+func (mc *MaxIDLenCache) WriteTo(w io.Writer) (int64, error) {
+	return mc.cacher.WriteTo(w)
+}
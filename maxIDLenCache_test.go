@@ -0,0 +1,81 @@
+package pushstate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestMaxIDLenCache(t *testing.T) {
+	long := strings.Repeat("x", 9)
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+		wantGet bool
+	}{
+		{name: "at the maximum", id: "12345678", wantGet: true},
+		{name: "too long", id: long, wantErr: ErrIDTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMaxIDLenCache(newTestCache(t), 8, nil)
+			if err := mc.PutE(&testModel{ID: tt.id, Payload: "p"}); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PutE got %v; want %v", err, tt.wantErr)
+			}
+			if got := mc.Get(tt.id) != ""; got != tt.wantGet {
+				t.Errorf("Get got a check-sum %t; want %t", got, tt.wantGet)
+			}
+			if err := mc.Delete(tt.id); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Delete got %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaxIDLenCacheWarnings(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	mc := NewMaxIDLenCache(newTestCache(t), 2, zap.New(core).Sugar())
+	now := time.Unix(0, 0)
+	mc.now = func() time.Time { return now }
+
+	for _, id := range []string{"abc", "abcd", "abcde"} {
+		mc.Put(&testModel{ID: id})
+	}
+	if !mc.IsChanged(&testModel{ID: "abc"}) {
+		t.Error("a model with a too long id is not changed")
+	}
+	now = now.Add(maxIDLenLogWindow)
+	mc.Put(&testModel{ID: "abc"})
+
+	tests := []struct {
+		msg      string
+		repeated int64
+	}{
+		{msg: "put of model with too long id"},
+		{msg: "check of model with too long id"},
+		{msg: "put of model with too long id", repeated: 2},
+	}
+	entries := logs.All()
+	if len(entries) != len(tests) {
+		t.Fatalf("got %d log lines; want %d", len(entries), len(tests))
+	}
+	for i, tt := range tests {
+		e := entries[i]
+		if e.Level != zapcore.WarnLevel || e.Message != tt.msg {
+			t.Errorf("line %d got %s %q; want warn %q", i, e.Level, e.Message, tt.msg)
+		}
+		if got, _ := e.ContextMap()["repeated"].(int64); got != tt.repeated {
+			t.Errorf("line %d got repeated %d; want %d", i, got, tt.repeated)
+		}
+	}
+}
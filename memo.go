@@ -0,0 +1,70 @@
+package pushstate
+
+import (
+	"reflect"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// ChecksumKeyer is implemented by models that supply their own key for the check-sum memo of
+// WithChecksumCache, instead of being keyed by pointer identity
+type ChecksumKeyer interface {
+	ChecksumKey() string
+}
+
+// checkSumMemo remembers recently computed check-sums of models for a while
+type checkSumMemo struct {
+	ttl       time.Duration
+	sums      map[interface{}]memoEntry
+	lastSweep time.Time
+}
+
+type memoEntry struct {
+	checkSum string
+	at       time.Time
+}
+
+// memoKey returns the memo key of m, and false when m is neither a ChecksumKeyer nor a pointer
+func memoKey(m PushModel) (interface{}, bool) {
+	if k, ok := m.(ChecksumKeyer); ok {
+		return k.ChecksumKey(), true
+	}
+	if reflect.ValueOf(m).Kind() == reflect.Ptr {
+		return m, true
+	}
+	return nil, false
+}
+
+// memoCheckSum returns the check-sum of m, reusing one computed within the memo's ttl; the
+// caller must hold the lock
+func (fc *FileCache) memoCheckSum(m PushModel) string {
+	if fc.memo == nil {
+		return fc.makeCheckSum(m)
+	}
+	key, ok := memoKey(m)
+	if !ok {
+		return fc.makeCheckSum(m)
+	}
+	now := fc.now()
+	// A check-sum from the future means the clock went backwards, and it would be reused until the
+	// clock catches up, so it is computed again instead
+	if e, ok := fc.memo.sums[key]; ok {
+		if age, skewed := fc.age(e.at, now); !skewed && age < fc.memo.ttl {
+			return e.checkSum
+		}
+	}
+	if age, skewed := fc.age(fc.memo.lastSweep, now); skewed || age >= fc.memo.ttl {
+		for k, e := range fc.memo.sums {
+			if age, skewed := fc.age(e.at, now); skewed || age >= fc.memo.ttl {
+				delete(fc.memo.sums, k)
+			}
+		}
+		fc.memo.lastSweep = now
+	}
+	cs := fc.makeCheckSum(m)
+	fc.memo.sums[key] = memoEntry{checkSum: cs, at: now}
+	return cs
+}
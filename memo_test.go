@@ -0,0 +1,59 @@
+package pushstate
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// valueModel is a model passed by value, which the memo never remembers
+type valueModel struct {
+	ID      string `json:"id"`
+	Payload string `json:"payload"`
+}
+
+func (m valueModel) GetID() string {
+	return m.ID
+}
+
+func TestWithChecksumCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		elapsed     time.Duration
+		byValue     bool
+		wantChanged bool
+	}{
+		{name: "remembered", elapsed: time.Second, wantChanged: false},
+		{name: "expired", elapsed: time.Minute, wantChanged: true},
+		{name: "by value", elapsed: time.Second, byValue: true, wantChanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			fc := newTestCache(t, WithChecksumCache(time.Minute))
+			fc.now = func() time.Time { return now }
+
+			m := &testModel{ID: "a", Payload: "1"}
+			var model PushModel = m
+			if tt.byValue {
+				model = valueModel{ID: "a", Payload: "1"}
+			}
+			fc.Put(model)
+			if fc.IsChanged(model) {
+				t.Fatal("a is changed right after it was put")
+			}
+			// Changed in place, which the memo does not see until its entry expires
+			m.Payload = "2"
+			if tt.byValue {
+				model = valueModel{ID: "a", Payload: "2"}
+			}
+			now = now.Add(tt.elapsed)
+			if changed := fc.IsChanged(model); changed != tt.wantChanged {
+				t.Errorf("IsChanged is %t, expected %t", changed, tt.wantChanged)
+			}
+		})
+	}
+}
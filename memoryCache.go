@@ -0,0 +1,20 @@
+package pushstate
+
+import (
+	"io"
+
+	"github.com/tkandal/checksum"
+	"go.uber.org/zap"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// NewMemoryCache creates a cache whose state-file is kept in memory, e.g. for tests or for a program
+// that only needs the check-sums while it runs.  Save keeps the state-file, Read restores the last
+// one saved, and Dump and WriteTo return it; it is lost when the cache is.  The options are those of
+// NewFileCache, see NewStreamCache for the ones that do not apply.
+func NewMemoryCache(cs checksum.CheckSum, log *zap.SugaredLogger, opts ...Option) *FileCache {
+	return NewStreamCache(nil, io.Discard, cs, log, opts...)
+}
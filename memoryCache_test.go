@@ -0,0 +1,50 @@
+package pushstate
+
+import (
+	"io"
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestMemoryCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		save     bool
+		wantSize int64
+		wantDump bool
+	}{
+		{name: "unsaved", wantSize: 0},
+		{name: "saved", save: true, wantSize: 2, wantDump: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMemoryCache(&checksum.Murmur3CheckSum{}, nil)
+			putAll(mc, "p", "a", "b")
+			if tt.save {
+				if err := mc.Save(); err != nil {
+					t.Fatalf("Save failed; error = %v", err)
+				}
+			}
+			mc.Put(&testModel{ID: "c"})
+			if err := mc.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if n := mc.Size(); n != tt.wantSize {
+				t.Errorf("Size after Read is %d; want %d", n, tt.wantSize)
+			}
+			r, err := mc.Dump()
+			if err != nil {
+				t.Fatalf("Dump failed; error = %v", err)
+			}
+			b, _ := io.ReadAll(r)
+			if got := len(b) > 0; got != tt.wantDump {
+				t.Errorf("Dump has content %t; want %t", got, tt.wantDump)
+			}
+		})
+	}
+}
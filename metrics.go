@@ -0,0 +1,97 @@
+package pushstate
+
+import (
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// stats are the counters of a FileCache, protected by its lock. They are kept in the header of the
+// state-file with WithPersistStats.
+type stats struct {
+	Checks       uint64 `json:"checks"`
+	Changed      uint64 `json:"changed"`
+	Puts         uint64 `json:"puts"`
+	Deletes      uint64 `json:"deletes"`
+	Reads        uint64 `json:"reads"`
+	Saves        uint64 `json:"saves"`
+	SkippedSaves uint64 `json:"skippedSaves"`
+	SaveErrors   uint64 `json:"saveErrors"`
+	BytesWritten uint64 `json:"bytesWritten"`
+}
+
+// count counts a check of a model, and whether it was changed
+func (s *stats) count(changed bool) {
+	s.Checks++
+	if changed {
+		s.Changed++
+	}
+}
+
+// MetricsSnapshot is the counters and sizes of a FileCache at one point in time.
+// The counters start at 0 when the cache is created, unless WithPersistStats restores them.
+type MetricsSnapshot struct {
+	// Entries is the number of check-sums
+	Entries int64 `json:"entries"`
+	// Pinned is the number of pinned ids
+	Pinned int `json:"pinned"`
+	// Sections is the number of sections
+	Sections int `json:"sections"`
+	// MemUsageBytes is the estimate of MemUsageBytes
+	MemUsageBytes int64 `json:"memUsageBytes"`
+	// Dirty is true when there are changes that are not saved
+	Dirty bool `json:"dirty"`
+	// Degraded is true when the cache has fallen back to memory only
+	Degraded bool `json:"degraded"`
+	// LastSave is when the cache was last saved, or zero
+	LastSave time.Time `json:"lastSave"`
+	// Checks is the number of models checked for changes, and Changed how many of them were changed
+	Checks  uint64 `json:"checks"`
+	Changed uint64 `json:"changed"`
+	// Puts is the number of check-sums put, also unchanged ones, and Deletes the number deleted
+	Puts    uint64 `json:"puts"`
+	Deletes uint64 `json:"deletes"`
+	// Reads is the number of times the file was read
+	Reads uint64 `json:"reads"`
+	// Saves is the number of times the file was written, SkippedSaves how many saves did not
+	// write it since the content was unchanged, and SaveErrors how many writes failed
+	Saves        uint64 `json:"saves"`
+	SkippedSaves uint64 `json:"skippedSaves"`
+	SaveErrors   uint64 `json:"saveErrors"`
+	// BytesWritten is the number of bytes written by all the saves, and FileBytes the size of the
+	// state-file written by the last one, or 0 when it has not written any
+	BytesWritten uint64 `json:"bytesWritten"`
+	FileBytes    int64  `json:"fileBytes"`
+	// DroppedEvents is the number of change events dropped because a subscriber was too slow
+	DroppedEvents uint64 `json:"droppedEvents"`
+}
+
+// MetricsSnapshot returns the counters and sizes of the cache, taken under the lock so they are
+// consistent with each other
+func (fc *FileCache) MetricsSnapshot() MetricsSnapshot {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	return MetricsSnapshot{
+		Entries:       int64(fc.entries.len()),
+		Pinned:        len(fc.pinned),
+		Sections:      len(fc.sections),
+		MemUsageBytes: fc.memUsageBytes(),
+		Dirty:         fc.isDirty,
+		Degraded:      fc.degraded,
+		LastSave:      fc.lastSave,
+		Checks:        fc.stats.Checks,
+		Changed:       fc.stats.Changed,
+		Puts:          fc.stats.Puts,
+		Deletes:       fc.stats.Deletes,
+		Reads:         fc.stats.Reads,
+		Saves:         fc.stats.Saves,
+		SkippedSaves:  fc.stats.SkippedSaves,
+		SaveErrors:    fc.stats.SaveErrors,
+		BytesWritten:  fc.stats.BytesWritten,
+		FileBytes:     fc.fileBytes,
+		DroppedEvents: fc.DroppedEvents(),
+	}
+}
@@ -0,0 +1,95 @@
+package pushstate
+
+import (
+	"os"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestMetricsSnapshot(t *testing.T) {
+	fc := newTestCache(t)
+	m := &testModel{ID: "a", Payload: "1"}
+	_ = fc.IsChanged(m)
+	fc.Put(m)
+	_ = fc.IsChanged(m)
+	putAll(fc, "1", "b")
+	fc.Pin("a")
+	fc.Section("s").Put(m)
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	putAll(fc, "1", "b")
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	if err := fc.Delete("b"); err != nil {
+		t.Fatalf("Delete failed; error = %v", err)
+	}
+	if err := fc.Read(); err != nil {
+		t.Fatalf("Read failed; error = %v", err)
+	}
+	info, err := os.Stat(fc.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := fc.MetricsSnapshot()
+	tests := []struct {
+		name      string
+		got, want int64
+	}{
+		{name: "entries", got: got.Entries, want: 1},
+		{name: "pinned", got: int64(got.Pinned), want: 1},
+		{name: "sections", got: int64(got.Sections), want: 1},
+		{name: "checks", got: int64(got.Checks), want: 2},
+		{name: "changed", got: int64(got.Changed), want: 1},
+		{name: "puts", got: int64(got.Puts), want: 3},
+		{name: "deletes", got: int64(got.Deletes), want: 1},
+		{name: "reads", got: int64(got.Reads), want: 1},
+		{name: "saves", got: int64(got.Saves), want: 2},
+		{name: "skipped saves", got: int64(got.SkippedSaves), want: 1},
+		{name: "file bytes", got: got.FileBytes, want: info.Size()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s is %d, expected %d", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+	if got.Dirty || got.LastSave.IsZero() || got.MemUsageBytes <= 0 {
+		t.Errorf("the snapshot is %+v", got)
+	}
+}
+
+func TestWithPersistStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantChecks uint64
+		wantPuts   uint64
+		wantReads  uint64
+	}{
+		{name: "per run", wantReads: 1},
+		{name: "persisted", opts: []Option{WithPersistStats()}, wantChecks: 1, wantPuts: 2, wantReads: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			m := &testModel{ID: "a", Payload: "1"}
+			_ = fc.IsChanged(m)
+			putAll(fc, "1", "a", "b")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			got := reopen(t, fc, tt.opts...).MetricsSnapshot()
+			if got.Checks != tt.wantChecks || got.Puts != tt.wantPuts || got.Reads != tt.wantReads {
+				t.Errorf("checks, puts and reads are %d, %d and %d, expected %d, %d and %d",
+					got.Checks, got.Puts, got.Reads, tt.wantChecks, tt.wantPuts, tt.wantReads)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package pushstate
+
+import (
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// MirrorCache is a Cacher that writes to two Cachers and reads from the primary one, for moving
+// from one backend to another.  Put, Delete, Reset, Read and Save go to both, the primary first;
+// an error from the secondary is logged and does not fail the call.  IsChanged, Size, Get, Dump
+// and WriteTo only use the primary.
+type MirrorCache struct {
+	primary   Cacher
+	secondary Cacher
+	log       *zap.SugaredLogger
+	// Protect which Cacher is the primary
+	mirrorLock sync.RWMutex
+}
+
+// NewMirrorCache mirrors the writes to primary to secondary; a nil log is replaced by a nop logger
+func NewMirrorCache(primary Cacher, secondary Cacher, log *zap.SugaredLogger) *MirrorCache {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+	return &MirrorCache{primary: primary, secondary: secondary, log: log}
+}
+
+// Promote swaps the primary and the secondary, so reads come from the new backend
+func (mc *MirrorCache) Promote() {
+	mc.mirrorLock.Lock()
+	defer mc.mirrorLock.Unlock()
+
+	mc.primary, mc.secondary = mc.secondary, mc.primary
+}
+
+// cachers returns the primary and the secondary
+func (mc *MirrorCache) cachers() (Cacher, Cacher) {
+	mc.mirrorLock.RLock()
+	defer mc.mirrorLock.RUnlock()
+
+	return mc.primary, mc.secondary
+}
+
+// both calls op on the primary and then on the secondary, and returns the primary's error
+func (mc *MirrorCache) both(name string, op func(Cacher) error) error {
+	primary, secondary := mc.cachers()
+	if err := op(primary); err != nil {
+		return err
+	}
+	if err := op(secondary); err != nil {
+		mc.log.Warnf("%s on secondary failed; error = %v", name, err)
+	}
+	return nil
+}
+
+// IsChanged checks if the model is new or changed in the primary
+func (mc *MirrorCache) IsChanged(m PushModel) bool {
+	primary, _ := mc.cachers()
+	return primary.IsChanged(m)
+}
+
+// Put puts the model's check-sum in both caches
+func (mc *MirrorCache) Put(m PushModel) {
+	primary, secondary := mc.cachers()
+	primary.Put(m)
+	secondary.Put(m)
+}
+
+// Read reads the check-sums of both caches from persistent storage
+func (mc *MirrorCache) Read() error {
+	return mc.both("read", func(c Cacher) error {
+		return c.Read()
+	})
+}
+
+// Save saves the check-sums of both caches to persistent storage
+func (mc *MirrorCache) Save() error {
+	return mc.both("save", func(c Cacher) error {
+		return c.Save()
+	})
+}
+
+// Size returns the number of check-sums in the primary
+func (mc *MirrorCache) Size() int64 {
+	primary, _ := mc.cachers()
+	return primary.Size()
+}
+
+// Get returns the check-sum for the given id in the primary
+func (mc *MirrorCache) Get(id string) string {
+	primary, _ := mc.cachers()
+	return primary.Get(id)
+}
+
+// Delete deletes the check-sum for the given id in both caches
+func (mc *MirrorCache) Delete(id string) error {
+	return mc.both("delete", func(c Cacher) error {
+		return c.Delete(id)
+	})
+}
+
+// Reset empties both caches
+func (mc *MirrorCache) Reset() error {
+	return mc.both("reset", func(c Cacher) error {
+		return c.Reset()
+	})
+}
+
+// Dump dumps the whole content of the primary to an io.Reader
+func (mc *MirrorCache) Dump() (io.Reader, error) {
+	primary, _ := mc.cachers()
+	return primary.Dump()
+}
+
+// WriteTo writes the whole content of the primary to w
+func (mc *MirrorCache) WriteTo(w io.Writer) (int64, error) {
+	primary, _ := mc.cachers()
+	return primary.WriteTo(w)
+}
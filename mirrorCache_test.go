@@ -0,0 +1,74 @@
+package pushstate
+
+import (
+	"errors"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// failingDeletes is a Cacher whose deletes fail
+type failingDeletes struct {
+	Cacher
+}
+
+func (c *failingDeletes) Delete(string) error {
+	return errors.New("delete failed")
+}
+
+func TestMirrorCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		promote bool
+	}{
+		{name: "primary"},
+		{name: "promoted", promote: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, secondary := newTestCache(t), newTestCache(t)
+			mc := NewMirrorCache(primary, secondary, nil)
+			m := &testModel{ID: "a", Payload: "1"}
+			mc.Put(m)
+			putAll(primary, "1", "only-primary")
+			if primary.Get("a") == "" || secondary.Get("a") == "" {
+				t.Fatal("Put did not go to both caches")
+			}
+			if tt.promote {
+				mc.Promote()
+			}
+			want := primary
+			if tt.promote {
+				want = secondary
+			}
+			if mc.Size() != want.Size() {
+				t.Errorf("Size is %d, expected %d from the reading cache", mc.Size(), want.Size())
+			}
+			if err := mc.Delete("a"); err != nil || primary.Get("a") != "" || secondary.Get("a") != "" {
+				t.Errorf("Delete returned %v, or did not go to both caches", err)
+			}
+		})
+	}
+}
+
+func TestMirrorCacheErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		primary   Cacher
+		secondary Cacher
+		wantErr   bool
+	}{
+		{name: "secondary fails", primary: newTestCache(t), secondary: &failingDeletes{Cacher: newTestCache(t)}},
+		{name: "primary fails", primary: &failingDeletes{Cacher: newTestCache(t)}, secondary: newTestCache(t), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMirrorCache(tt.primary, tt.secondary, nil)
+			if err := mc.Delete("a"); (err != nil) != tt.wantErr {
+				t.Errorf("Delete returned %v, expected an error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
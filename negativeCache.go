@@ -0,0 +1,135 @@
+package pushstate
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// NegativeCache is a Cacher that remembers for a while that a model's id had no check-sum, so
+// repeated IsChanged calls for the same new model report it as changed without asking the wrapped
+// Cacher again.  Only models that are a ChecksumKeyer are remembered, by their id and ChecksumKey, so
+// the caller decides when two models are the same.  Put of a model forgets its id, and Read and Reset
+// forget every id, since they may give the id a check-sum; everything else goes straight to the
+// wrapped Cacher.
+type NegativeCache struct {
+	cacher Cacher
+	ttl    time.Duration
+	misses map[string]negativeEntry
+	now    func() time.Time
+	// Protect the misses
+	negativeLock sync.Mutex
+}
+
+type negativeEntry struct {
+	key string
+	at  time.Time
+}
+
+// NewNegativeCache wraps c and remembers the ids without a check-sum for ttl
+func NewNegativeCache(c Cacher, ttl time.Duration) *NegativeCache {
+	return &NegativeCache{cacher: c, ttl: ttl, misses: map[string]negativeEntry{}, now: time.Now}
+}
+
+// missed returns true when the id of m was remembered without a check-sum for the same key
+func (nc *NegativeCache) missed(id string, key string) bool {
+	nc.negativeLock.Lock()
+	defer nc.negativeLock.Unlock()
+
+	e, ok := nc.misses[id]
+	if !ok || e.key != key {
+		return false
+	}
+	// A miss from the future, after the clock went backwards, is not trusted
+	if age := nc.now().Sub(e.at); age < 0 || age >= nc.ttl {
+		delete(nc.misses, id)
+		return false
+	}
+	return true
+}
+
+// forget forgets the misses of ids, or of every id when ids is empty
+func (nc *NegativeCache) forget(ids ...string) {
+	nc.negativeLock.Lock()
+	defer nc.negativeLock.Unlock()
+
+	if len(ids) == 0 {
+		nc.misses = map[string]negativeEntry{}
+		return
+	}
+	for _, id := range ids {
+		delete(nc.misses, id)
+	}
+}
+
+// IsChanged checks if the model is new or changed, and answers at once for a new model that was
+// checked within the ttl
+func (nc *NegativeCache) IsChanged(m PushModel) bool {
+	k, ok := m.(ChecksumKeyer)
+	if !ok {
+		return nc.cacher.IsChanged(m)
+	}
+	id, key := m.GetID(), k.ChecksumKey()
+	if nc.missed(id, key) {
+		return true
+	}
+	changed := nc.cacher.IsChanged(m)
+	if changed && nc.cacher.Get(id) == "" {
+		nc.negativeLock.Lock()
+		nc.misses[id] = negativeEntry{key: key, at: nc.now()}
+		nc.negativeLock.Unlock()
+	}
+	return changed
+}
+
+// Put puts the model's check-sum in the cache, and forgets that its id had none
+func (nc *NegativeCache) Put(m PushModel) {
+	nc.forget(m.GetID())
+	nc.cacher.Put(m)
+}
+
+// Read reads the check-sums from persistent storage, and forgets every id without one
+func (nc *NegativeCache) Read() error {
+	defer nc.forget()
+	return nc.cacher.Read()
+}
+
+// Save saves the check-sums to persistent storage
+func (nc *NegativeCache) Save() error {
+	return nc.cacher.Save()
+}
+
+// Size returns the number of check-sums
+func (nc *NegativeCache) Size() int64 {
+	return nc.cacher.Size()
+}
+
+// Get returns the check-sum for the given id
+func (nc *NegativeCache) Get(id string) string {
+	return nc.cacher.Get(id)
+}
+
+// Delete deletes the check-sum for the given id
+func (nc *NegativeCache) Delete(id string) error {
+	return nc.cacher.Delete(id)
+}
+
+// Reset empties the cache, and forgets every id without a check-sum
+func (nc *NegativeCache) Reset() error {
+	defer nc.forget()
+	return nc.cacher.Reset()
+}
+
+// Dump dumps the whole content to an io.Reader
+func (nc *NegativeCache) Dump() (io.Reader, error) {
+	return nc.cacher.Dump()
+}
+
+// WriteTo writes the whole content to w
+func (nc *NegativeCache) WriteTo(w io.Writer) (int64, error) {
+	return nc.cacher.WriteTo(w)
+}
@@ -0,0 +1,78 @@
+package pushstate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// keyedModel is a ChecksumKeyer whose key is its version
+type keyedModel struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+}
+
+func (m *keyedModel) GetID() string {
+	return m.ID
+}
+
+func (m *keyedModel) ChecksumKey() string {
+	return m.Version
+}
+
+// countingCacher counts the calls of IsChanged
+type countingCacher struct {
+	Cacher
+	calls int
+}
+
+func (c *countingCacher) IsChanged(m PushModel) bool {
+	c.calls++
+	return c.Cacher.IsChanged(m)
+}
+
+func TestNegativeCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		second      PushModel
+		between     func(nc *NegativeCache)
+		elapsed     time.Duration
+		wantCalls   int
+		wantChanged bool
+	}{
+		{name: "remembered", second: &keyedModel{ID: "a", Version: "1"}, wantCalls: 1, wantChanged: true},
+		{name: "expired", second: &keyedModel{ID: "a", Version: "1"}, elapsed: time.Hour, wantCalls: 2, wantChanged: true},
+		{name: "other key", second: &keyedModel{ID: "a", Version: "2"}, wantCalls: 2, wantChanged: true},
+		{name: "not a keyer", second: &testModel{ID: "a", Payload: "1"}, wantCalls: 2, wantChanged: true},
+		{name: "put forgets", second: &keyedModel{ID: "a", Version: "1"},
+			between: func(nc *NegativeCache) { nc.Put(&keyedModel{ID: "a", Version: "1"}) }, wantCalls: 2},
+		{name: "reset forgets", second: &keyedModel{ID: "a", Version: "1"},
+			between: func(nc *NegativeCache) { _ = nc.Reset() }, wantCalls: 2, wantChanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			cc := &countingCacher{Cacher: NewMemoryCache(&checksum.Murmur3CheckSum{}, nil)}
+			nc := NewNegativeCache(cc, time.Minute)
+			nc.now = func() time.Time { return now }
+			if !nc.IsChanged(&keyedModel{ID: "a", Version: "1"}) {
+				t.Fatal("a new model is not changed")
+			}
+			if tt.between != nil {
+				tt.between(nc)
+			}
+			now = now.Add(tt.elapsed)
+			if got := nc.IsChanged(tt.second); got != tt.wantChanged {
+				t.Errorf("IsChanged returned %t, expected %t", got, tt.wantChanged)
+			}
+			if cc.calls != tt.wantCalls {
+				t.Errorf("the wrapped IsChanged ran %d times, expected %d", cc.calls, tt.wantCalls)
+			}
+		})
+	}
+}
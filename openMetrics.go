@@ -0,0 +1,77 @@
+package pushstate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// openMetric is one metric family of WriteOpenMetrics
+type openMetric struct {
+	name  string
+	kind  string
+	help  string
+	unit  string
+	value float64
+}
+
+// WriteOpenMetrics writes the counters and sizes of MetricsSnapshot to w in the OpenMetrics text
+// format, e.g. to serve them to a scraper from an http.Handler without a client library
+func (fc *FileCache) WriteOpenMetrics(w io.Writer) error {
+	ms := fc.MetricsSnapshot()
+	lastSave := float64(0)
+	if !ms.LastSave.IsZero() {
+		lastSave = float64(ms.LastSave.UnixNano()) / 1e9
+	}
+	metrics := []openMetric{
+		{name: "pushstate_entries", kind: "gauge", help: "Number of check-sums in the cache.", value: float64(ms.Entries)},
+		{name: "pushstate_pinned", kind: "gauge", help: "Number of pinned ids.", value: float64(ms.Pinned)},
+		{name: "pushstate_memory_bytes", kind: "gauge", unit: "bytes", help: "Estimated memory used by the cache.", value: float64(ms.MemUsageBytes)},
+		{name: "pushstate_file_bytes", kind: "gauge", unit: "bytes", help: "Size of the state-file written by the last save.", value: float64(ms.FileBytes)},
+		{name: "pushstate_dirty", kind: "gauge", help: "1 when the cache has changes that are not saved.", value: boolValue(ms.Dirty)},
+		{name: "pushstate_degraded", kind: "gauge", help: "1 when the cache has fallen back to memory only.", value: boolValue(ms.Degraded)},
+		{name: "pushstate_last_save_timestamp_seconds", kind: "gauge", unit: "seconds", help: "Time of the last save.", value: lastSave},
+		{name: "pushstate_checks", kind: "counter", help: "Models checked for changes.", value: float64(ms.Checks)},
+		{name: "pushstate_changed", kind: "counter", help: "Models checked that were new or changed.", value: float64(ms.Changed)},
+		{name: "pushstate_puts", kind: "counter", help: "Check-sums put.", value: float64(ms.Puts)},
+		{name: "pushstate_deletes", kind: "counter", help: "Check-sums deleted.", value: float64(ms.Deletes)},
+		{name: "pushstate_reads", kind: "counter", help: "Reads of the state-file.", value: float64(ms.Reads)},
+		{name: "pushstate_saves", kind: "counter", help: "Writes of the state-file.", value: float64(ms.Saves)},
+		{name: "pushstate_skipped_saves", kind: "counter", help: "Saves skipped since the content was unchanged.", value: float64(ms.SkippedSaves)},
+		{name: "pushstate_save_errors", kind: "counter", help: "Writes of the state-file that failed.", value: float64(ms.SaveErrors)},
+		{name: "pushstate_written_bytes", kind: "counter", unit: "bytes", help: "Bytes written by all the saves.", value: float64(ms.BytesWritten)},
+		{name: "pushstate_dropped_events", kind: "counter", help: "Change events dropped for slow subscribers.", value: float64(ms.DroppedEvents)},
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		// Per the format, a unit is the last part of the family name, and a counter's sample has _total
+		_, _ = fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.kind)
+		if m.unit != "" {
+			_, _ = fmt.Fprintf(bw, "# UNIT %s %s\n", m.name, m.unit)
+		}
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n", m.name, m.help)
+		sample := m.name
+		if m.kind == "counter" {
+			sample += "_total"
+		}
+		_, _ = fmt.Fprintf(bw, "%s %v\n", sample, m.value)
+	}
+	_, _ = bw.WriteString("# EOF\n")
+	// bufio.Writer keeps the first write error, and returns it from Flush
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write open metrics failed; error = %v", err)
+	}
+	return nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package pushstate
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// parseOpenMetrics checks that b is in the OpenMetrics text format as WriteOpenMetrics writes it, with
+// a TYPE before the samples of a family and a final EOF, and returns the samples by name
+func parseOpenMetrics(t *testing.T, b []byte) map[string]float64 {
+	t.Helper()
+	text := string(b)
+	if !strings.HasSuffix(text, "# EOF\n") {
+		t.Fatalf("the output does not end with # EOF")
+	}
+	samples := map[string]float64{}
+	family, kind := "", ""
+	for _, line := range strings.Split(strings.TrimSuffix(text, "# EOF\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 4)
+		if fields[0] == "#" {
+			if len(fields) < 4 {
+				t.Fatalf("malformed line %q", line)
+			}
+			switch fields[1] {
+			case "TYPE":
+				family, kind = fields[2], fields[3]
+			case "UNIT":
+				if fields[2] != family || !strings.HasSuffix(family, "_"+fields[3]) {
+					t.Errorf("the unit of %q does not end its family name", line)
+				}
+			case "HELP":
+				if fields[2] != family {
+					t.Errorf("help %q is not of the family %q", line, family)
+				}
+			default:
+				t.Errorf("unknown line %q", line)
+			}
+			continue
+		}
+		if len(fields) != 2 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		want := family
+		if kind == "counter" {
+			want += "_total"
+		}
+		if fields[0] != want {
+			t.Errorf("sample %q is not of the family %q", line, family)
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Errorf("sample %q has an invalid value; error = %v", line, err)
+		}
+		samples[fields[0]] = v
+	}
+	return samples
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	fc := newTestCache(t)
+	putAll(fc, "1", "a", "b")
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := fc.WriteOpenMetrics(buf); err != nil {
+		t.Fatalf("WriteOpenMetrics failed; error = %v", err)
+	}
+	samples := parseOpenMetrics(t, buf.Bytes())
+	ms := fc.MetricsSnapshot()
+
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{name: "pushstate_entries", want: 2},
+		{name: "pushstate_saves_total", want: 1},
+		{name: "pushstate_puts_total", want: 2},
+		{name: "pushstate_written_bytes_total", want: float64(ms.BytesWritten)},
+		{name: "pushstate_file_bytes", want: float64(ms.FileBytes)},
+		{name: "pushstate_dirty", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := samples[tt.name]
+			if !ok {
+				t.Fatalf("the output has no %s", tt.name)
+			}
+			if got != tt.want {
+				t.Errorf("%s is %v, expected %v", tt.name, got, tt.want)
+			}
+		})
+	}
+	if ms.BytesWritten == 0 {
+		t.Error("the save wrote no bytes")
+	}
+}
+
+func TestWriteOpenMetricsError(t *testing.T) {
+	if err := newTestCache(t).WriteOpenMetrics(failingWriter{}); err == nil {
+		t.Error("WriteOpenMetrics to a failing writer returned <nil>, expected an error")
+	}
+}
@@ -0,0 +1,441 @@
+package pushstate
+
+import (
+	"github.com/tkandal/checksum"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// Option configures a FileCache
+type Option func(*FileCache)
+
+// WithNoChmod skips changing the mode of the state-file after it is saved, for filesystems
+// where chmod always fails
+func WithNoChmod() Option {
+	return func(fc *FileCache) {
+		fc.noChmod = true
+	}
+}
+
+// WithParallelChecksum computes the check-sums in PutBatch and FilterChanged with the given number
+// of workers; the check-sum implementation must then be safe for concurrent use
+func WithParallelChecksum(workers int) Option {
+	return func(fc *FileCache) {
+		fc.workers = workers
+	}
+}
+
+// WithValidatePath makes NewFileCacheE check that the directory of the state-file exists and is
+// writable, so a wrong path fails at construction instead of at the first save
+func WithValidatePath() Option {
+	return func(fc *FileCache) {
+		fc.validatePath = true
+	}
+}
+
+// WithCompression gzips the state-file when it is saved; Read detects a gzipped file by itself.
+// With minBytes, a file smaller than that uncompressed is written plain, since gzip costs more than
+// it saves on small files.
+func WithCompression(minBytes ...int64) Option {
+	return func(fc *FileCache) {
+		fc.compress = true
+		if len(minBytes) > 0 {
+			fc.compressMin = minBytes[0]
+		}
+	}
+}
+
+// WithStableChecksum computes check-sums from a canonical form of the model's JSON where object keys
+// are sorted, so reordering the fields of a struct does not change its check-sum.
+// Enabling it changes the check-sums of all models with more than one field.
+func WithStableChecksum() Option {
+	return func(fc *FileCache) {
+		fc.stableChecksum = true
+	}
+}
+
+// WithCopyBufferSize makes WriteTo copy the file with a pooled buffer of n bytes instead of the
+// default 32KB, e.g. to reduce the number of writes to a network connection
+func WithCopyBufferSize(n int) Option {
+	return func(fc *FileCache) {
+		fc.copyBufSize = n
+	}
+}
+
+// WithChecksumCache makes IsChanged and ChangeStatus reuse a model's check-sum for ttl after it was
+// computed, instead of encoding the model again on every check. Models are remembered by their
+// ChecksumKey when they implement ChecksumKeyer, else by pointer identity, and models passed by value
+// are never remembered.
+// The tradeoff is that a model changed in place within ttl is reported as unchanged, and that every
+// remembered model is kept in memory until its entry expires.
+func WithChecksumCache(ttl time.Duration) Option {
+	return func(fc *FileCache) {
+		fc.memo = &checkSumMemo{ttl: ttl, sums: map[interface{}]memoEntry{}}
+	}
+}
+
+// WithIOTimeout makes Read and the saves give up with ErrIOTimeout when a file operation takes
+// longer than d, e.g. on a hung network mount. The operation itself can not be interrupted, so its
+// goroutine is leaked until the system call returns, and a save that times out may still replace
+// the file later. Saves copy the cache first, so they do not see changes made after the timeout.
+func WithIOTimeout(d time.Duration) Option {
+	return func(fc *FileCache) {
+		fc.ioTimeout = d
+	}
+}
+
+// WithBatchAbortOnError makes PutBatch put nothing when a model in the batch fails, instead of
+// putting all the models that did not fail
+func WithBatchAbortOnError() Option {
+	return func(fc *FileCache) {
+		fc.batchAbort = true
+	}
+}
+
+// WithLogThrottle collapses identical warnings within d into one logged line; the next time the
+// warning is logged it carries the number of suppressed warnings as the field "repeated", or a line
+// of its own does when the window ends without another one
+func WithLogThrottle(d time.Duration) Option {
+	return func(fc *FileCache) {
+		fc.throttle = &logThrottle{window: d, messages: map[string]*throttled{}}
+	}
+}
+
+// WithFallbackToMemory makes the cache log a warning and continue in memory only, without saving,
+// when the state-file can not be written because of permissions or a read-only filesystem, instead
+// of returning the error. Degraded tells when that has happened.
+func WithFallbackToMemory() Option {
+	return func(fc *FileCache) {
+		fc.fallbackToMemory = true
+	}
+}
+
+// WithPreserveOwnership gives the rewritten state-file the owner and group of the file it replaces,
+// where the process is permitted to; it does nothing on platforms without file ownership
+func WithPreserveOwnership() Option {
+	return func(fc *FileCache) {
+		fc.preserveOwnership = true
+	}
+}
+
+// WithBinaryFormat saves the state-file in a compact binary format instead of JSON, which is smaller
+// and faster to read. Read detects the format by itself, and Dump and WriteTo then give the binary
+// content.
+func WithBinaryFormat() Option {
+	return func(fc *FileCache) {
+		fc.binary = true
+	}
+}
+
+// WithReadIntegrity saves a check-sum of the whole content in the state-file's header, and makes
+// Read return ErrIntegrity when the content does not match it, which catches bit rot and truncation
+// that still decodes. A file saved without the check-sum is read without verifying it.
+func WithReadIntegrity() Option {
+	return func(fc *FileCache) {
+		fc.readIntegrity = true
+	}
+}
+
+// WithFileSystem keeps the state-file in fsys instead of the file system of the operating system.
+// A nil fsys is ignored.
+func WithFileSystem(fsys FileSystem) Option {
+	return func(fc *FileCache) {
+		if fsys != nil {
+			fc.fs = fsys
+		}
+	}
+}
+
+// WithInPlaceWrite truncates and rewrites the state-file itself, instead of writing a temporary file
+// and renaming it, for file systems where rename is expensive or not supported.  The write is done
+// while holding the cache's lock, so the cache itself never writes the file concurrently, but the
+// write is no longer atomic: a crash or a full disk during a save leaves a truncated state-file,
+// which fails to Read.
+func WithInPlaceWrite() Option {
+	return func(fc *FileCache) {
+		fc.inPlaceWrite = true
+	}
+}
+
+// WithDeleteEventsOnReset makes Reset publish a Deleted event for every id that was removed, instead
+// of a single Cleared event
+func WithDeleteEventsOnReset() Option {
+	return func(fc *FileCache) {
+		fc.deleteEventsOnReset = true
+	}
+}
+
+// WithMaxFileBytes makes a save fail with ErrCacheFull when the state-file would be larger than n
+// bytes, which then keeps the previous file.  The content is encoded once more on every save to
+// measure it.
+func WithMaxFileBytes(n int64) Option {
+	return func(fc *FileCache) {
+		fc.maxFileBytes = n
+	}
+}
+
+// WithSpillEviction makes a save that exceeds WithMaxFileBytes evict the least recently updated
+// check-sums until the file fits, instead of failing.  Pinned ids are never evicted, and the
+// evicted ids are only removed from the cache, with a Deleted event for each, once the save succeeds.
+func WithSpillEviction() Option {
+	return func(fc *FileCache) {
+		fc.spillEviction = true
+	}
+}
+
+// WithRenameOverwrite makes Rename replace the check-sum of the new id, instead of returning ErrExists
+func WithRenameOverwrite() Option {
+	return func(fc *FileCache) {
+		fc.renameOverwrite = true
+	}
+}
+
+// WithChangeHysteresis makes IsChanged report a stored id as changed only after its model has
+// differed from the check-sum n consecutive times, which suppresses flapping sources.  A check that
+// matches the check-sum starts the count over, and Put, Delete, Read and Reset clear it.
+// New ids are always reported at once.
+func WithChangeHysteresis(n int) Option {
+	return func(fc *FileCache) {
+		fc.hysteresis = n
+	}
+}
+
+// WithDenyList makes the cache never hold a check-sum for the ids, e.g. after a request to delete
+// them.  Read drops them and rewrites the file at once when it contained any, and Put ignores them,
+// so IsChanged always reports them as new.
+func WithDenyList(ids ...string) Option {
+	return func(fc *FileCache) {
+		if fc.denied == nil {
+			fc.denied = map[string]bool{}
+		}
+		for _, id := range ids {
+			fc.denied[id] = true
+		}
+	}
+}
+
+// WithAlgorithmName names the algorithm of the cache's check-sum, which is stored with every
+// check-sum that is put, so the cache can later move to another algorithm with WithLegacyCheckSum
+func WithAlgorithmName(name string) Option {
+	return func(fc *FileCache) {
+		fc.algorithm = name
+	}
+}
+
+// WithLegacyCheckSum accepts entries stored with the algorithm name, and compares models against
+// them with cs.  Entries are upgraded to the cache's own algorithm as they are put, so a cache
+// moves to a new algorithm gradually.  Register the previous check-sum with the name "" to accept
+// entries stored before the algorithm was named, and name the new one with WithAlgorithmName.
+// An entry with an algorithm that is not registered is reported as changed.
+func WithLegacyCheckSum(name string, cs checksum.CheckSum) Option {
+	return func(fc *FileCache) {
+		if fc.legacy == nil {
+			fc.legacy = map[string]checksum.CheckSum{}
+		}
+		fc.legacy[name] = cs
+	}
+}
+
+// WithIngestOnlyChanged makes Ingest put only the models that are new or changed
+func WithIngestOnlyChanged() Option {
+	return func(fc *FileCache) {
+		fc.ingestOnlyChanged = true
+	}
+}
+
+// WithSaveEvery makes Ingest save the cache every d while it runs, and when it returns
+func WithSaveEvery(d time.Duration) Option {
+	return func(fc *FileCache) {
+		fc.saveEvery = d
+	}
+}
+
+// WithOrderedEntries records the order ids are added in, and saves it in the file, for
+// OrderedEntries.  It costs a sequence number of 8 bytes per id in memory, and a few bytes per id
+// in the file.
+func WithOrderedEntries() Option {
+	return func(fc *FileCache) {
+		fc.orderedEntries = true
+	}
+}
+
+// WithKeyTransform stores every id as fn(id), e.g. a hash of ids that are long or sensitive.  The
+// methods that take ids transform them too, while Entries, Dump and the other methods that return
+// the content, return the stored keys.  The name is saved in the file, and Read returns
+// ErrKeyTransformMismatch for a file saved with another transform, or without one, since its keys
+// would never be found.  fn must be deterministic and is called with the lock held.
+func WithKeyTransform(name string, fn func(id string) string) Option {
+	return func(fc *FileCache) {
+		fc.keyTransformName = name
+		fc.keyTransform = fn
+	}
+}
+
+// WithEntryTTL makes IsChanged report a model as changed, with ReasonExpired, when its check-sum
+// was put more than ttl ago, so unchanged models are still pushed again now and then.  Putting the
+// same check-sum again refreshes the entry's update time.  A timestamp in the future, after the
+// wall clock went backwards, is logged as clock skew and replaced by the current time, so the entry
+// neither expires at once nor waits for the clock to catch up.
+func WithEntryTTL(ttl time.Duration) Option {
+	return func(fc *FileCache) {
+		fc.entryTTL = ttl
+	}
+}
+
+// WithBatchDuplicatePolicy sets what PutBatch does when a batch has several models with the same id;
+// the default is DuplicateLastWins
+func WithBatchDuplicatePolicy(p DuplicatePolicy) Option {
+	return func(fc *FileCache) {
+		fc.duplicatePolicy = p
+	}
+}
+
+// WithTempPrefix sets the prefix of the temporary files the state-file is written to before it is
+// renamed; the default is the name of the state-file
+func WithTempPrefix(prefix string) Option {
+	return func(fc *FileCache) {
+		fc.tempFilePrefix = prefix
+	}
+}
+
+// WithStaleTempCleanup removes temporary files with the cache's prefix that are older than olderThan
+// when the cache is created, e.g. the ones left behind by a crash during a save
+func WithStaleTempCleanup(olderThan time.Duration) Option {
+	return func(fc *FileCache) {
+		fc.staleTempAge = olderThan
+	}
+}
+
+// WithRejectEmptyID refuses models whose GetID returns an empty string, instead of letting all of
+// them share the check-sum of the id "". PutE, IsChangedE and PutBatch return ErrEmptyID for them,
+// every other way of putting, also Ingest, WithLock and Section, logs it and stores nothing, and
+// IsChanged logs it and reports them as changed.
+func WithRejectEmptyID() Option {
+	return func(fc *FileCache) {
+		fc.rejectEmptyID = true
+	}
+}
+
+// WithMaxChecksumLen refuses check-sums longer than n bytes, e.g. from a faulty checksum.CheckSum, so
+// they can not make the state-file balloon. PutE, PutRawE, PutBatch, ReplaceAll and LoadMap return
+// ErrChecksumTooLong for them; every other way of putting, also Section, logs it and stores nothing.
+func WithMaxChecksumLen(n int) Option {
+	return func(fc *FileCache) {
+		fc.maxChecksumLen = n
+	}
+}
+
+// WithPersistStats keeps the counters of MetricsSnapshot in the header of the state-file, so they
+// survive a restart: Read restores them from the file. By default they start at 0 on every run.
+// Since a save that writes nothing but new counters is skipped, the counters on disk are those of
+// the last save with a real change.
+func WithPersistStats() Option {
+	return func(fc *FileCache) {
+		fc.persistStats = true
+	}
+}
+
+// WithGrowthWarning logs a warning when a put makes the cache hold more than threshold check-sums,
+// e.g. because ids are never deleted. It warns once per crossing: it does not warn again until the
+// cache has shrunk to 90% of the threshold and grown past it again.
+func WithGrowthWarning(threshold int64) Option {
+	return func(fc *FileCache) {
+		fc.growthThreshold = threshold
+	}
+}
+
+// WithTombstones keeps a tombstone for every id that is deleted, for ttl after the delete, so a
+// lagging replica can tell that the id was removed on purpose and not resurrect it. That is an id
+// deleted with Delete, WithLock, Update or the other methods that delete ids, and the old id of
+// Rename, while Reset and ReplaceAll keep no tombstones for the ids they remove. ChangeStatus reports
+// a model of a tombstoned id with ReasonTombstone, and Put removes the tombstone. The tombstones are
+// kept in the state-file, and the expired ones are removed when it is saved.
+func WithTombstones(ttl time.Duration) Option {
+	return func(fc *FileCache) {
+		fc.tombstoneTTL = ttl
+	}
+}
+
+// WithFollowSymlinks writes the state-file to the target of a symlink instead of replacing the
+// symlink with a regular file, as a save does by default. The temporary file is created next to the
+// target, so the rename stays within its directory.
+func WithFollowSymlinks() Option {
+	return func(fc *FileCache) {
+		fc.followSymlinks = true
+	}
+}
+
+// WithCanonicalNumbers is WithStableChecksum, and also writes every integer-valued number of the
+// model's JSON as a plain integer in the canonical form, so e.g. a large integer held in a float64,
+// which encoding/json writes as 1e+21, gets the same check-sum as the same integer held in an int.
+// Compared to WithStableChecksum alone, it only changes the check-sums of models with an integer
+// written with an exponent or a fraction, e.g. a float64 of 1e21 or more, or a json.Number of "5.0".
+func WithCanonicalNumbers() Option {
+	return func(fc *FileCache) {
+		fc.stableChecksum = true
+		fc.canonicalNumbers = true
+	}
+}
+
+// WithMergePolicy sets what ReadFiles does when several files have different check-sums for an id,
+// where the first and the last is in the order of the paths; the default is DuplicateLastWins
+func WithMergePolicy(p DuplicatePolicy) Option {
+	return func(fc *FileCache) {
+		fc.mergePolicy = p
+	}
+}
+
+// WithAutoSave saves the cache every interval in the background when it is dirty, until Close is
+// called; a failed save is logged, and tried again at the next interval
+func WithAutoSave(interval time.Duration) Option {
+	return func(fc *FileCache) {
+		if fc.autoSave == nil {
+			fc.autoSave = &autoSaver{}
+		}
+		fc.autoSave.every = interval
+	}
+}
+
+// WithAutoSaveJitter moves every interval of WithAutoSave randomly by up to fraction of it in either
+// direction, so caches started together spread their saves out; an interval of 1m with a fraction
+// of 0.1 is between 54s and 66s.  The fraction is between 0 and 1, and is clamped to that range.
+// It has no effect without WithAutoSave.
+func WithAutoSaveJitter(fraction float64) Option {
+	return func(fc *FileCache) {
+		if fraction < 0 {
+			fraction = 0
+		} else if fraction > 1 {
+			fraction = 1
+		}
+		if fc.autoSave == nil {
+			fc.autoSave = &autoSaver{}
+		}
+		fc.autoSave.jitter = fraction
+	}
+}
+
+// WithSortedArrayStore keeps the check-sums and their metadata in slices sorted by id instead of maps,
+// and finds an id by binary search.  A very large cache then needs less memory, and Read decodes a
+// state-file of the current format straight into the slices without building a map of the entries
+// first, so it is also read faster.  In turn a lookup is slower than in a map, and Put and Delete of
+// an id that is not the last cost a copy of the slices after it.  Check-sums are not shared by ids
+// with the same check-sum, and a save still makes a map of the entries while the file is written.
+// See BenchmarkStore for the difference.
+func WithSortedArrayStore() Option {
+	return func(fc *FileCache) {
+		fc.sortedArray = true
+	}
+}
+
+// WithBeforeSave calls fn with a copy of the check-sums every time the state-file is about to be
+// written, and writes the check-sums fn returns instead, e.g. to remove ids that must not be stored;
+// the cache itself is not changed.  fn is called with the cache locked, so it must be quick and must
+// not block or use the cache.
+func WithBeforeSave(fn func(entries map[string]string) map[string]string) Option {
+	return func(fc *FileCache) {
+		fc.beforeSave = fn
+	}
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package pushstate
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// fileOwner returns the owner and group of the file described by info, and false when it has none
+func fileOwner(info fs.FileInfo) (int, int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package pushstate
+
+import (
+	"os"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithPreserveOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of a file needs root")
+	}
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantUID int
+	}{
+		{name: "new owner", wantUID: 0},
+		{name: "preserved", opts: []Option{WithPreserveOwnership()}, wantUID: 1234},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if err := os.Chown(fc.filename, 1234, 1234); err != nil {
+				t.Fatal(err)
+			}
+			putAll(fc, "2", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			info, err := os.Stat(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uid, gid, _ := fileOwner(info); uid != tt.wantUID || gid != tt.wantUID {
+				t.Errorf("the owner is %d:%d, expected %d:%d", uid, gid, tt.wantUID, tt.wantUID)
+			}
+		})
+	}
+}
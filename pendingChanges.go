@@ -0,0 +1,50 @@
+package pushstate
+
+import (
+	"sort"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// PendingChanges reads the state-file and returns the sorted ids that the next save would add to it,
+// change in it and remove from it, without saving or changing the cache. The check-sums compared are
+// the ones a save writes, so the ids WithBeforeSave removes are not added, and denied ids in the file
+// are removed. A missing file has no ids, so all the cached ids are added. Entries that
+// WithMaxFileBytes may evict to fit the file are not foreseen.
+func (fc *FileCache) PendingChanges() ([]string, []string, []string, error) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	var sf *stateFile
+	filename, fsys := fc.filename, fc.fs
+	err := fc.withIOTimeout(func() error {
+		var err error
+		sf, _, err = readFile(fsys, filename)
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	next := fc.saveSnapshot(fc.entries).Entries
+	added, changed, removed := []string{}, []string{}, []string{}
+	for id, cs := range next {
+		old, ok := sf.Entries[id]
+		if !ok {
+			added = append(added, id)
+		} else if old != cs {
+			changed = append(changed, id)
+		}
+	}
+	for id := range sf.Entries {
+		if _, ok := next[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed, nil
+}
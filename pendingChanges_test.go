@@ -0,0 +1,75 @@
+package pushstate
+
+import (
+	"reflect"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestPendingChanges(t *testing.T) {
+	scrub := WithBeforeSave(func(entries map[string]string) map[string]string {
+		delete(entries, "secret")
+		return entries
+	})
+	tests := []struct {
+		name                    string
+		opts                    []Option
+		change                  func(fc *FileCache)
+		added, changed, removed []string
+	}{
+		{name: "nothing", change: func(fc *FileCache) {}},
+		{name: "added, changed and removed", change: func(fc *FileCache) {
+			putAll(fc, "2", "b")
+			putAll(fc, "1", "d")
+			fc.deleteCheckSum("c")
+		}, added: []string{"d"}, changed: []string{"b"}, removed: []string{"c"}},
+		{name: "before save hides an id", opts: []Option{scrub}, change: func(fc *FileCache) {
+			putAll(fc, "1", "secret", "d")
+		}, added: []string{"d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			putAll(fc, "1", "a", "b", "c")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			tt.change(fc)
+			added, changed, removed, err := fc.PendingChanges()
+			if err != nil {
+				t.Fatalf("PendingChanges failed; error = %v", err)
+			}
+			for _, diff := range []struct {
+				kind      string
+				got, want []string
+			}{{"added", added, tt.added}, {"changed", changed, tt.changed}, {"removed", removed, tt.removed}} {
+				if diff.want == nil {
+					diff.want = []string{}
+				}
+				if !reflect.DeepEqual(diff.got, diff.want) {
+					t.Errorf("%s is %v, expected %v", diff.kind, diff.got, diff.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingChangesDenied(t *testing.T) {
+	fc := newTestCache(t)
+	putAll(fc, "1", "a", "denied")
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	denying := NewFileCache(fc.filename, fc.checkSum, nil, WithDenyList("denied"))
+	putAll(denying, "1", "a")
+	_, _, removed, err := denying.PendingChanges()
+	if err != nil {
+		t.Fatalf("PendingChanges failed; error = %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"denied"}) {
+		t.Errorf("removed is %v, expected the denied id", removed)
+	}
+}
@@ -0,0 +1,100 @@
+package pushstate
+
+import (
+	"sort"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// Pin protects the entry for id from being removed by GarbageCollect; pins are saved in the
+// state-file, and an id may be pinned before it has an entry
+func (fc *FileCache) Pin(id string) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		fc.warnw(fc.log, "pin failed", "id", id, "error", ErrDraining)
+		return
+	}
+	id = fc.key(id)
+	if !fc.pinned[id] {
+		fc.pinned[id] = true
+		fc.markDirty()
+	}
+}
+
+// Unpin removes the protection of the entry for id
+func (fc *FileCache) Unpin(id string) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		fc.warnw(fc.log, "unpin failed", "id", id, "error", ErrDraining)
+		return
+	}
+	id = fc.key(id)
+	if fc.pinned[id] {
+		delete(fc.pinned, id)
+		fc.markDirty()
+	}
+}
+
+// IsPinned returns true when id is pinned
+func (fc *FileCache) IsPinned(id string) bool {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	return fc.pinned[fc.key(id)]
+}
+
+// GarbageCollect deletes the check-sums of all ids that are neither in seen nor pinned, saves the
+// cache once and returns the deleted ids, as stored, see WithKeyTransform
+func (fc *FileCache) GarbageCollect(seen []string) ([]string, error) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return nil, ErrDraining
+	}
+	keep := map[string]bool{}
+	for _, id := range seen {
+		keep[fc.key(id)] = true
+	}
+	return fc.collect(keep)
+}
+
+// collect deletes the check-sums of all ids that are neither in keep nor pinned, and saves the cache
+// once when any were deleted; the caller must hold the lock
+func (fc *FileCache) collect(keep map[string]bool) ([]string, error) {
+	var removed []string
+	fc.entries.each(func(id string, _ string) {
+		if !keep[id] && !fc.pinned[id] {
+			removed = append(removed, id)
+		}
+	})
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(removed)
+	for _, id := range removed {
+		fc.deleteCheckSum(id)
+	}
+	fc.markDirty()
+	if err := fc.saveToFile(fc.filename, fc.entries); err != nil {
+		return removed, err
+	}
+	fc.isDirty = false
+	return removed, nil
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package pushstate
+
+import (
+	"reflect"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestGarbageCollect(t *testing.T) {
+	tests := []struct {
+		name        string
+		pin         []string
+		seen        []string
+		wantRemoved []string
+	}{
+		{name: "nothing seen", wantRemoved: []string{"a", "b", "c"}},
+		{name: "seen", seen: []string{"a"}, wantRemoved: []string{"b", "c"}},
+		{name: "pinned", pin: []string{"b"}, seen: []string{"a"}, wantRemoved: []string{"c"}},
+		{name: "all kept", pin: []string{"c"}, seen: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a", "b", "c")
+			for _, id := range tt.pin {
+				fc.Pin(id)
+			}
+			removed, err := fc.GarbageCollect(tt.seen)
+			if err != nil {
+				t.Fatalf("GarbageCollect failed; error = %v", err)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("GarbageCollect removed %v, expected %v", removed, tt.wantRemoved)
+			}
+			if n := fc.Size(); n != int64(3-len(tt.wantRemoved)) {
+				t.Errorf("Size is %d, expected %d", n, 3-len(tt.wantRemoved))
+			}
+		})
+	}
+}
+
+func TestPinIsSaved(t *testing.T) {
+	fc := newTestCache(t)
+	fc.Pin("a")
+	fc.Pin("b")
+	fc.Unpin("b")
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	c := reopen(t, fc)
+	if !c.IsPinned("a") || c.IsPinned("b") {
+		t.Errorf("IsPinned is %t for a and %t for b after Read, expected true and false", c.IsPinned("a"), c.IsPinned("b"))
+	}
+}
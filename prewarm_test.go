@@ -0,0 +1,44 @@
+package pushstate
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestPrewarm(t *testing.T) {
+	tests := []struct {
+		name    string
+		save    bool
+		wantErr error
+	}{
+		{name: "existing file", save: true},
+		{name: "missing file", wantErr: fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			if tt.save {
+				putAll(fc, "1", "a", "b")
+				if err := fc.Save(); err != nil {
+					t.Fatalf("Save failed; error = %v", err)
+				}
+			}
+			err := fc.Prewarm()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Prewarm returned %v, expected %v", err, tt.wantErr)
+			}
+			var ce *CacheError
+			if err != nil && (!errors.As(err, &ce) || ce.Op != "prewarm" || ce.Path != fc.filename) {
+				t.Errorf("Prewarm returned %v, expected a prewarm *CacheError of %s", err, fc.filename)
+			}
+			if tt.save && fc.IsDirty() {
+				t.Error("Prewarm made the cache dirty")
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package pushstatetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/tkandal/pushstate"
+	"math/rand"
+	"sync"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// StressConfig configures StressTest
+type StressConfig struct {
+	// Cache is the cache to hammer; it should be empty
+	Cache pushstate.Cacher
+	// Workers is the number of concurrent goroutines, default 8
+	Workers int
+	// Operations is the number of operations per worker, default 1000
+	Operations int
+	// IDs is the number of ids per worker, default 100
+	IDs int
+	// Seed makes the sequence of operations reproducible
+	Seed int64
+}
+
+// Model is a minimal pushstate.PushModel
+type Model struct {
+	ID      string `json:"id"`
+	Payload string `json:"payload"`
+}
+
+// GetID returns the model's id
+func (m *Model) GetID() string {
+	return m.ID
+}
+
+// StressTest hammers the cache with concurrent puts, deletes and reads, and checks that the cache
+// agrees with what each worker has done at every step and that Size equals the net inserts at the end.
+// Run it with the race detector to also catch data races in a Cacher implementation.
+func StressTest(ctx context.Context, cfg StressConfig) error {
+	if cfg.Cache == nil {
+		return fmt.Errorf("no cache to stress")
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 8
+	}
+	if cfg.Operations <= 0 {
+		cfg.Operations = 1000
+	}
+	if cfg.IDs <= 0 {
+		cfg.IDs = 100
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make([]error, cfg.Workers)
+	present := make([]int, cfg.Workers)
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			present[w], errs[w] = stressWorker(ctx, cfg, w)
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	var want int64
+	for _, n := range present {
+		want += int64(n)
+	}
+	if got := cfg.Cache.Size(); got != want {
+		return fmt.Errorf("size is %d after the stress test, expected %d", got, want)
+	}
+	return nil
+}
+
+// stressWorker runs one worker's operations; each worker owns its own ids, so what it has put and
+// deleted is known exactly, and it returns the number of its ids left in the cache
+func stressWorker(ctx context.Context, cfg StressConfig, w int) (int, error) {
+	rnd := rand.New(rand.NewSource(cfg.Seed + int64(w)))
+	models := map[string]*Model{}
+
+	for op := 0; op < cfg.Operations; op++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		id := fmt.Sprintf("%d-%d", w, rnd.Intn(cfg.IDs))
+		switch rnd.Intn(4) {
+		case 0, 1:
+			m := &Model{ID: id, Payload: fmt.Sprintf("%d", rnd.Int63())}
+			cfg.Cache.Put(m)
+			models[id] = m
+			if cfg.Cache.IsChanged(m) {
+				return 0, fmt.Errorf("%s is changed right after it was put", id)
+			}
+		case 2:
+			if err := cfg.Cache.Delete(id); err != nil {
+				return 0, fmt.Errorf("delete %s failed; error = %v", id, err)
+			}
+			delete(models, id)
+			if cs := cfg.Cache.Get(id); cs != "" {
+				return 0, fmt.Errorf("%s has check-sum %s after it was deleted", id, cs)
+			}
+		default:
+			m, ok := models[id]
+			if ok && cfg.Cache.IsChanged(m) {
+				return 0, fmt.Errorf("%s is changed, but it has not been touched since it was put", id)
+			}
+			if !ok && cfg.Cache.Get(id) != "" {
+				return 0, fmt.Errorf("%s has a check-sum, but it was never put", id)
+			}
+			// Read another worker's id to mix reads with that worker's writes
+			_ = cfg.Cache.Get(fmt.Sprintf("%d-%d", rnd.Intn(cfg.Workers), rnd.Intn(cfg.IDs)))
+		}
+	}
+	return len(models), nil
+}
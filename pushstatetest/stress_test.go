@@ -0,0 +1,48 @@
+package pushstatetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tkandal/pushstate"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// lostDeletes is a Cacher that forgets to delete
+type lostDeletes struct {
+	pushstate.Cacher
+}
+
+func (c *lostDeletes) Delete(string) error {
+	return nil
+}
+
+func TestStressTestFails(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		cache   pushstate.Cacher
+		wantErr error
+	}{
+		{name: "no cache", ctx: context.Background()},
+		{name: "cancelled", ctx: cancelled, cache: fileCache(t), wantErr: context.Canceled},
+		{name: "lost deletes", ctx: context.Background(), cache: &lostDeletes{Cacher: fileCache(t)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := StressTest(tt.ctx, StressConfig{Cache: tt.cache, Workers: 2, Operations: 100, IDs: 10, Seed: 1})
+			if err == nil {
+				t.Fatal("StressTest did not fail")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("StressTest returned %v, expected %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package pushstatetest
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tkandal/pushstate"
+	"io"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// RunCacherSuite runs the behaviour every pushstate.Cacher must have against caches made by factory,
+// as subtests of t. The factory must return a new, empty cache on every call.
+//
+// The semantics are:
+//   - IsChanged is true for an id that has no check-sum, and for a model whose check-sum differs
+//     from the stored one, and false right after the same model is put
+//   - Put stores the model's check-sum and replaces any earlier check-sum for the id
+//   - Get returns the stored check-sum, or "" when there is none
+//   - Size returns the number of stored check-sums
+//   - Delete removes the check-sum for an id, and is not an error for an unknown id
+//   - Reset removes all check-sums
+//   - Read after Save restores the saved check-sums
+//   - Dump and WriteTo return the same content
+func RunCacherSuite(t *testing.T, factory func() pushstate.Cacher) {
+	t.Run("IsChanged", func(t *testing.T) {
+		c := factory()
+		m := &Model{ID: "a", Payload: "1"}
+		if !c.IsChanged(m) {
+			t.Errorf("a new model is not changed")
+		}
+		c.Put(m)
+		if c.IsChanged(m) {
+			t.Errorf("a model is changed right after it was put")
+		}
+		if !c.IsChanged(&Model{ID: "a", Payload: "2"}) {
+			t.Errorf("a model with a new payload is not changed")
+		}
+	})
+
+	t.Run("PutGet", func(t *testing.T) {
+		c := factory()
+		if cs := c.Get("a"); cs != "" {
+			t.Errorf("Get of an unknown id returned %q, expected an empty string", cs)
+		}
+		c.Put(&Model{ID: "a", Payload: "1"})
+		first := c.Get("a")
+		if first == "" {
+			t.Fatalf("Get returned an empty check-sum after Put")
+		}
+		c.Put(&Model{ID: "a", Payload: "2"})
+		if second := c.Get("a"); second == first {
+			t.Errorf("Put of a changed model did not replace the check-sum")
+		}
+		if n := c.Size(); n != 1 {
+			t.Errorf("Size is %d after two puts of the same id, expected 1", n)
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		c := factory()
+		if n := c.Size(); n != 0 {
+			t.Fatalf("Size of a new cache is %d, expected 0", n)
+		}
+		for i := 0; i < 10; i++ {
+			c.Put(&Model{ID: fmt.Sprintf("id-%d", i)})
+		}
+		if n := c.Size(); n != 10 {
+			t.Errorf("Size is %d, expected 10", n)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c := factory()
+		m := &Model{ID: "a", Payload: "1"}
+		c.Put(m)
+		if err := c.Delete("a"); err != nil {
+			t.Fatalf("Delete failed; error = %v", err)
+		}
+		if cs := c.Get("a"); cs != "" {
+			t.Errorf("Get returned %q after Delete, expected an empty string", cs)
+		}
+		if !c.IsChanged(m) {
+			t.Errorf("a deleted model is not changed")
+		}
+		if err := c.Delete("unknown"); err != nil {
+			t.Errorf("Delete of an unknown id failed; error = %v", err)
+		}
+		if n := c.Size(); n != 0 {
+			t.Errorf("Size is %d after Delete, expected 0", n)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		c := factory()
+		c.Put(&Model{ID: "a"})
+		c.Put(&Model{ID: "b"})
+		if err := c.Reset(); err != nil {
+			t.Fatalf("Reset failed; error = %v", err)
+		}
+		if n := c.Size(); n != 0 {
+			t.Errorf("Size is %d after Reset, expected 0", n)
+		}
+		if cs := c.Get("a"); cs != "" {
+			t.Errorf("Get returned %q after Reset, expected an empty string", cs)
+		}
+	})
+
+	t.Run("SaveRead", func(t *testing.T) {
+		c := factory()
+		c.Put(&Model{ID: "a", Payload: "1"})
+		c.Put(&Model{ID: "b", Payload: "2"})
+		want := map[string]string{"a": c.Get("a"), "b": c.Get("b")}
+		if err := c.Save(); err != nil {
+			t.Fatalf("Save failed; error = %v", err)
+		}
+		if err := c.Read(); err != nil {
+			t.Fatalf("Read failed; error = %v", err)
+		}
+		for id, cs := range want {
+			if got := c.Get(id); got != cs {
+				t.Errorf("Get(%q) returned %q after Read, expected %q", id, got, cs)
+			}
+		}
+		if n := c.Size(); n != int64(len(want)) {
+			t.Errorf("Size is %d after Read, expected %d", n, len(want))
+		}
+	})
+
+	t.Run("DumpWriteTo", func(t *testing.T) {
+		c := factory()
+		c.Put(&Model{ID: "a", Payload: "1"})
+		if err := c.Save(); err != nil {
+			t.Fatalf("Save failed; error = %v", err)
+		}
+		r, err := c.Dump()
+		if err != nil {
+			t.Fatalf("Dump failed; error = %v", err)
+		}
+		dumped, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read the dump failed; error = %v", err)
+		}
+		buf := &bytes.Buffer{}
+		n, err := c.WriteTo(buf)
+		if err != nil {
+			t.Fatalf("WriteTo failed; error = %v", err)
+		}
+		if n != int64(buf.Len()) {
+			t.Errorf("WriteTo returned %d, but wrote %d bytes", n, buf.Len())
+		}
+		if !bytes.Equal(dumped, buf.Bytes()) {
+			t.Errorf("Dump and WriteTo differ:\n%s\n%s", dumped, buf.Bytes())
+		}
+	})
+}
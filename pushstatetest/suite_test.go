@@ -0,0 +1,97 @@
+package pushstatetest
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tkandal/checksum"
+	"github.com/tkandal/pushstate"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// fileCache returns a FileCache with a state-file in a temporary directory of t
+func fileCache(t *testing.T, opts ...pushstate.Option) *pushstate.FileCache {
+	return pushstate.NewFileCache(filepath.Join(t.TempDir(), "state.json"), &checksum.Murmur3CheckSum{}, nil, opts...)
+}
+
+// caches are the Cachers of this module, each made by a factory of a new, empty cache
+func caches(t *testing.T) []struct {
+	name    string
+	factory func() pushstate.Cacher
+} {
+	return []struct {
+		name    string
+		factory func() pushstate.Cacher
+	}{
+		{name: "FileCache", factory: func() pushstate.Cacher {
+			return fileCache(t)
+		}},
+		{name: "MemoryCache", factory: func() pushstate.Cacher {
+			return pushstate.NewMemoryCache(&checksum.Murmur3CheckSum{}, nil)
+		}},
+		{name: "ShardedFileCache", factory: func() pushstate.Cacher {
+			return pushstate.NewShardedFileCache(filepath.Join(t.TempDir(), "state.json"), 4, &checksum.Murmur3CheckSum{}, nil)
+		}},
+		{name: "SortedArrayStore", factory: func() pushstate.Cacher {
+			return fileCache(t, pushstate.WithSortedArrayStore())
+		}},
+		{name: "HTTPCache", factory: func() pushstate.Cacher {
+			srv := httptest.NewServer(pushstate.Handler(fileCache(t)))
+			t.Cleanup(srv.Close)
+			return pushstate.NewHTTPCache(srv.URL, srv.Client(), nil)
+		}},
+		{name: "Section", factory: func() pushstate.Cacher {
+			return fileCache(t).Section("s")
+		}},
+		{name: "Tenant", factory: func() pushstate.Cacher {
+			return pushstate.NewTenantCache(fileCache(t)).Tenant("t")
+		}},
+		{name: "AuditCache", factory: func() pushstate.Cacher {
+			return pushstate.NewAuditCache(fileCache(t), io.Discard, pushstate.WithAuditReads())
+		}},
+		{name: "RateLimitedCache", factory: func() pushstate.Cacher {
+			return pushstate.NewRateLimitedCache(fileCache(t), 1000000, time.Minute)
+		}},
+		{name: "MirrorCache", factory: func() pushstate.Cacher {
+			return pushstate.NewMirrorCache(fileCache(t), fileCache(t), nil)
+		}},
+		{name: "SingleFlightCache", factory: func() pushstate.Cacher {
+			return pushstate.NewSingleFlightCache(fileCache(t))
+		}},
+		{name: "NegativeCache", factory: func() pushstate.Cacher {
+			return pushstate.NewNegativeCache(fileCache(t), time.Minute)
+		}},
+		{name: "MaxIDLenCache", factory: func() pushstate.Cacher {
+			return pushstate.NewMaxIDLenCache(fileCache(t), 64, nil)
+		}},
+		{name: "RoutingCache", factory: func() pushstate.Cacher {
+			return pushstate.NewRoutingCache(fileCache(t), map[string]pushstate.Cacher{"id-": fileCache(t)})
+		}},
+	}
+}
+
+func TestRunCacherSuite(t *testing.T) {
+	for _, tt := range caches(t) {
+		t.Run(tt.name, func(t *testing.T) {
+			RunCacherSuite(t, tt.factory)
+		})
+	}
+}
+
+func TestStressTest(t *testing.T) {
+	for _, tt := range caches(t) {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := StressConfig{Cache: tt.factory(), Workers: 4, Operations: 200, IDs: 20, Seed: 1}
+			if err := StressTest(context.Background(), cfg); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
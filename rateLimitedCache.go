@@ -0,0 +1,127 @@
+package pushstate
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// RateLimitedCache is a Cacher that limits how many changes IsChanged reports per window, to protect
+// the systems that are pushed to.  A token bucket holds up to budget tokens and is refilled at
+// budget tokens per window.  IsChanged consumes a token for every new or changed model it reports;
+// when the bucket is empty it reports the model as unchanged instead, and since its check-sum is
+// not put, it is reported again when asked in a later window.
+// A token is consumed by every changed verdict, also when the caller does not go on to push and
+// Put the model, or asks twice for the same model.  Put and everything else go straight to the
+// wrapped Cacher.
+type RateLimitedCache struct {
+	cacher    Cacher
+	budget    float64
+	window    time.Duration
+	tokens    float64
+	last      time.Time
+	throttled uint64
+	now       func() time.Time
+	// Protect the bucket
+	limitLock sync.Mutex
+}
+
+// NewRateLimitedCache wraps c and reports at most budget changes per window, starting with a full bucket
+func NewRateLimitedCache(c Cacher, budget int, window time.Duration) *RateLimitedCache {
+	rc := &RateLimitedCache{
+		cacher: c,
+		budget: float64(budget),
+		window: window,
+		tokens: float64(budget),
+		now:    time.Now,
+	}
+	rc.last = rc.now()
+	return rc
+}
+
+// Throttled returns the number of changes reported as unchanged because the budget was spent
+func (rc *RateLimitedCache) Throttled() uint64 {
+	rc.limitLock.Lock()
+	defer rc.limitLock.Unlock()
+
+	return rc.throttled
+}
+
+// take refills the bucket for the time since the last call, and takes a token if there is one
+func (rc *RateLimitedCache) take() bool {
+	rc.limitLock.Lock()
+	defer rc.limitLock.Unlock()
+
+	now := rc.now()
+	if rc.window > 0 {
+		rc.tokens += rc.budget * float64(now.Sub(rc.last)) / float64(rc.window)
+		if rc.tokens > rc.budget {
+			rc.tokens = rc.budget
+		}
+	}
+	rc.last = now
+	if rc.tokens < 1 {
+		rc.throttled++
+		return false
+	}
+	rc.tokens--
+	return true
+}
+
+// IsChanged checks if the model is new or changed, and reports it as unchanged when the budget of
+// the window is spent
+func (rc *RateLimitedCache) IsChanged(m PushModel) bool {
+	if !rc.cacher.IsChanged(m) {
+		return false
+	}
+	return rc.take()
+}
+
+// Put puts the model's check-sum in the cache
+func (rc *RateLimitedCache) Put(m PushModel) {
+	rc.cacher.Put(m)
+}
+
+// Read reads the check-sums from persistent storage
+func (rc *RateLimitedCache) Read() error {
+	return rc.cacher.Read()
+}
+
+// Save saves the check-sums to persistent storage
+func (rc *RateLimitedCache) Save() error {
+	return rc.cacher.Save()
+}
+
+// Size returns the number of check-sums
+func (rc *RateLimitedCache) Size() int64 {
+	return rc.cacher.Size()
+}
+
+// Get returns the check-sum for the given id
+func (rc *RateLimitedCache) Get(id string) string {
+	return rc.cacher.Get(id)
+}
+
+// Delete deletes the check-sum for the given id
+func (rc *RateLimitedCache) Delete(id string) error {
+	return rc.cacher.Delete(id)
+}
+
+// Reset empties the cache
+func (rc *RateLimitedCache) Reset() error {
+	return rc.cacher.Reset()
+}
+
+// Dump dumps the whole content to an io.Reader
+func (rc *RateLimitedCache) Dump() (io.Reader, error) {
+	return rc.cacher.Dump()
+}
+
+// WriteTo writes the whole content to w
+func (rc *RateLimitedCache) WriteTo(w io.Writer) (int64, error) {
+	return rc.cacher.WriteTo(w)
+}
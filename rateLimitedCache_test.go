@@ -0,0 +1,60 @@
+package pushstate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestRateLimitedCache(t *testing.T) {
+	tests := []struct {
+		name          string
+		elapsed       time.Duration
+		wantChanged   int
+		wantThrottled uint64
+	}{
+		{name: "budget spent", wantChanged: 3, wantThrottled: 7},
+		{name: "half refilled", elapsed: 30 * time.Second, wantChanged: 3 + 1, wantThrottled: 6},
+		{name: "refilled", elapsed: time.Hour, wantChanged: 3 + 3, wantThrottled: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			rc := NewRateLimitedCache(newTestCache(t), 3, time.Minute)
+			rc.now = func() time.Time { return now }
+			rc.last = now
+
+			changed := 0
+			for i := 0; i < 10; i++ {
+				if i == 5 {
+					now = now.Add(tt.elapsed)
+				}
+				if rc.IsChanged(&testModel{ID: fmt.Sprint(i), Payload: "1"}) {
+					changed++
+				}
+			}
+			if changed != tt.wantChanged || rc.Throttled() != tt.wantThrottled {
+				t.Errorf("reported %d changes and throttled %d, expected %d and %d", changed, rc.Throttled(),
+					tt.wantChanged, tt.wantThrottled)
+			}
+		})
+	}
+}
+
+func TestRateLimitedCacheUnchanged(t *testing.T) {
+	rc := NewRateLimitedCache(newTestCache(t), 1, time.Minute)
+	m := &testModel{ID: "a", Payload: "1"}
+	rc.Put(m)
+	for i := 0; i < 3; i++ {
+		if rc.IsChanged(m) {
+			t.Fatal("an unchanged model is reported as changed")
+		}
+	}
+	if rc.Throttled() != 0 || !rc.IsChanged(&testModel{ID: "b", Payload: "1"}) {
+		t.Error("unchanged models used up the budget")
+	}
+}
@@ -0,0 +1,77 @@
+package pushstate
+
+import (
+	"fmt"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// ReadFiles reads the check-sums of the state-files at paths and merges them into one cache, e.g. to
+// consolidate state that is split by shard or date.  An id found in several files is resolved as
+// WithMergePolicy says, and by default the file that comes last wins; files that agree on the
+// check-sum of an id do not conflict.  The files are checked as Read checks the state-file, and a
+// missing file is an empty one.  Nothing is changed when a file fails.  The cache's own state-file
+// is still the one that is saved, and the merged check-sums are written to it on the next save.
+func (fc *FileCache) ReadFiles(paths ...string) error {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return ErrDraining
+	}
+	merged := newStateFile()
+	pinned := map[string]bool{}
+	from := map[string]string{}
+	for _, path := range paths {
+		sf, _, err := readFile(fc.fs, path)
+		if err != nil {
+			return err
+		}
+		if err = fc.checkHeader(sf, path); err != nil {
+			return err
+		}
+		fc.dropDenied(sf)
+		for id, cs := range sf.Entries {
+			if old, ok := merged.Entries[id]; ok && old != cs {
+				switch fc.mergePolicy {
+				case DuplicateFirstWins:
+					continue
+				case DuplicateError:
+					return &CacheError{Op: "merge", Path: path, Err: fmt.Errorf("%w; %s is also in %s",
+						ErrDuplicateID, id, from[id])}
+				}
+			}
+			merged.Entries[id] = cs
+			from[id] = path
+			if m, ok := sf.Meta[id]; ok {
+				merged.Meta[id] = m
+			} else {
+				delete(merged.Meta, id)
+			}
+		}
+		for id := range sf.pinned() {
+			pinned[id] = true
+		}
+		for name, section := range sf.Sections {
+			if merged.Sections[name] == nil {
+				merged.Sections[name] = map[string]string{}
+			}
+			for id, cs := range section {
+				merged.Sections[name][id] = cs
+			}
+		}
+	}
+
+	fc.setCache(merged.Entries, merged.Meta)
+	fc.pinned = pinned
+	fc.sections = merged.Sections
+	fc.tombstones = nil
+	// The state-file does not hold the merged check-sums
+	fc.lastWritten = ""
+	fc.markDirty()
+	fc.stats.Reads++
+	fc.log.Debugw("read state-files", "files", len(paths), "entries", len(merged.Entries))
+	return nil
+}
@@ -0,0 +1,84 @@
+package pushstate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// writeStateFile saves a state-file with entries at filename
+func writeStateFile(t *testing.T, filename string, entries map[string]string) {
+	t.Helper()
+	fc := NewFileCache(filename, &checksum.Murmur3CheckSum{}, nil)
+	for id, cs := range entries {
+		fc.PutRaw(id, cs)
+	}
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+}
+
+func TestReadFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		files   []string
+		wantErr error
+		want    map[string]string
+	}{
+		{name: "last wins", files: []string{"one.json", "two.json"},
+			want: map[string]string{"a": "1", "b": "two", "c": "3"}},
+		{name: "first wins", opts: []Option{WithMergePolicy(DuplicateFirstWins)}, files: []string{"one.json", "two.json"},
+			want: map[string]string{"a": "1", "b": "one", "c": "3"}},
+		{name: "error", opts: []Option{WithMergePolicy(DuplicateError)}, files: []string{"one.json", "two.json"},
+			wantErr: ErrDuplicateID, want: map[string]string{"x": "0"}},
+		{name: "agreeing files", opts: []Option{WithMergePolicy(DuplicateError)}, files: []string{"one.json", "one.json"},
+			want: map[string]string{"a": "1", "b": "one"}},
+		{name: "missing file", files: []string{"one.json", "missing.json"}, want: map[string]string{"a": "1", "b": "one"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeStateFile(t, filepath.Join(dir, "one.json"), map[string]string{"a": "1", "b": "one"})
+			writeStateFile(t, filepath.Join(dir, "two.json"), map[string]string{"b": "two", "c": "3"})
+			fc := NewFileCache(filepath.Join(dir, "state.json"), &checksum.Murmur3CheckSum{}, nil, tt.opts...)
+			fc.PutRaw("x", "0")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+
+			var paths []string
+			for _, f := range tt.files {
+				paths = append(paths, filepath.Join(dir, f))
+			}
+			if err := fc.ReadFiles(paths...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadFiles returned %v, expected %v", err, tt.wantErr)
+			}
+			got := map[string]string{}
+			for _, e := range fc.Entries() {
+				got[e.ID] = e.Checksum
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("the cache has %v, expected %v", got, tt.want)
+			}
+			// The merge is saved to the cache's own state-file, and leaves the others alone
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if disk := onDisk(t, fc); !reflect.DeepEqual(disk, tt.want) {
+				t.Errorf("the state-file has %v, expected %v", disk, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("ReadFiles created a missing file; error = %v", err)
+			}
+		})
+	}
+}
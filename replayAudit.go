@@ -0,0 +1,83 @@
+package pushstate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// ReplayAudit rebuilds the cache from an audit stream written by an AuditCache, e.g. after the
+// state-file is lost. The puts, deletes and resets are applied in order on top of what is in the
+// cache, the audited reads are skipped, and the cache is saved. A put without a check-sum, which an
+// older AuditCache wrote for a put that did not land, is skipped as well, and so is a put this cache
+// refuses, e.g. of a denied id. The stream is validated before anything is applied, so a malformed
+// line changes nothing. It returns the number of operations applied.
+func (fc *FileCache) ReplayAudit(r io.Reader) (int, error) {
+	records, err := readAudit(r)
+	if err != nil {
+		return 0, err
+	}
+
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return 0, ErrDraining
+	}
+	applied := 0
+	for _, rec := range records {
+		switch rec.Op {
+		case "put":
+			if rec.Checksum == "" || !fc.putCheckSum(fc.key(rec.ID), rec.Checksum) {
+				continue
+			}
+		case "delete":
+			fc.deleteCheckSum(fc.key(rec.ID))
+		case "reset":
+			for _, id := range fc.entries.ids() {
+				fc.removeCheckSum(id)
+			}
+		default:
+			continue
+		}
+		applied++
+	}
+	fc.markDirty()
+	if err = fc.saveToFile(fc.filename, fc.entries); err != nil {
+		return applied, err
+	}
+	fc.isDirty = false
+	fc.log.Debugw("replayed audit", "operations", applied, "entries", fc.entries.len())
+	return applied, nil
+}
+
+// readAudit decodes and validates the lines of an audit stream
+func readAudit(r io.Reader) ([]AuditRecord, error) {
+	var records []AuditRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("decode audit line %d failed; error = %v", line, err)
+		}
+		switch rec.Op {
+		case "put", "delete", "reset", "is-changed", "size", "get", "dump", "write-to":
+		default:
+			return nil, fmt.Errorf("audit line %d has unknown operation %q", line, rec.Op)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit failed; error = %v", err)
+	}
+	return records, nil
+}
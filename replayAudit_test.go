@@ -0,0 +1,90 @@
+package pushstate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestReplayAudit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	src := newTestCache(t, WithDenyList("denied"))
+	ac := NewAuditCache(src, buf)
+	ac.Put(&testModel{ID: "a", Payload: "1"})
+	ac.Put(&testModel{ID: "b", Payload: "1"})
+	ac.Put(&testModel{ID: "denied", Payload: "1"})
+	ac.Put(&testModel{ID: "a", Payload: "2"})
+	if err := ac.Delete("b"); err != nil {
+		t.Fatalf("Delete failed; error = %v", err)
+	}
+	if strings.Contains(buf.String(), "denied") {
+		t.Errorf("a put that did not land was audited:\n%s", buf.String())
+	}
+
+	dst := newTestCache(t)
+	n, err := dst.ReplayAudit(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReplayAudit failed; error = %v", err)
+	}
+	if n != 4 {
+		t.Errorf("ReplayAudit applied %d operations, expected 4", n)
+	}
+	if got, want := dst.Get("a"), src.Get("a"); got != want {
+		t.Errorf("a has %q, expected %q", got, want)
+	}
+	if dst.Size() != 1 || onDisk(t, dst)["a"] == "" {
+		t.Errorf("the replayed cache has %d entries, expected only a", dst.Size())
+	}
+}
+
+func TestReplayAuditDenied(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ac := NewAuditCache(newTestCache(t), buf)
+	ac.Put(&testModel{ID: "a", Payload: "1"})
+	ac.Put(&testModel{ID: "denied", Payload: "1"})
+
+	dst := newTestCache(t, WithDenyList("denied"))
+	n, err := dst.ReplayAudit(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReplayAudit failed; error = %v", err)
+	}
+	if n != 1 || dst.Get("denied") != "" {
+		t.Errorf("ReplayAudit applied %d operations, expected only the put of a", n)
+	}
+}
+
+func TestReplayAuditLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		audit   string
+		applied int
+		wantErr bool
+	}{
+		{name: "put without check-sum is skipped", audit: `{"op":"put","id":"a"}` + "\n" + `{"op":"put","id":"b","checksum":"x"}`, applied: 1},
+		{name: "reads are skipped", audit: `{"op":"get","id":"a"}` + "\n" + `{"op":"size"}`, applied: 0},
+		{name: "malformed line", audit: `{"op":"put","id":"b","checksum":"x"}` + "\n" + `{"op"`, wantErr: true},
+		{name: "unknown operation", audit: `{"op":"frobnicate"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			n, err := fc.ReplayAudit(strings.NewReader(tt.audit))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReplayAudit returned %v, expected an error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if fc.Size() != 0 {
+					t.Errorf("a failed replay changed the cache")
+				}
+				return
+			}
+			if n != tt.applied {
+				t.Errorf("ReplayAudit applied %d operations, expected %d", n, tt.applied)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package pushstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// RoutingCache is a Cacher that sends every id to the Cacher of the longest prefix the id starts with,
+// or to the default Cacher when no prefix matches, e.g. to keep the ids of one tenant in another
+// backend than the rest.  IsChanged, Put, Get and Delete go to the Cacher of the id.  Read, Save and
+// Reset go to every Cacher, and return the first error after all were called.  Size is the sum of the
+// sizes, and Dump and WriteTo write one JSON object with the content of each Cacher keyed by its route,
+// "" for the default and else the prefix, e.g. {"":{...},"tenant-a/":{...}}; a Cacher that writes
+// nothing is null.  A Cacher used for several prefixes is only read, saved, reset, counted and written
+// once, under the first of its prefixes.
+type RoutingCache struct {
+	def      Cacher
+	routes   map[string]Cacher
+	prefixes []string
+	backends []Cacher
+	names    []string
+}
+
+// NewRoutingCache routes the ids with a prefix in routes to its Cacher, and all other ids to def
+func NewRoutingCache(def Cacher, routes map[string]Cacher) *RoutingCache {
+	rc := &RoutingCache{def: def, routes: make(map[string]Cacher, len(routes))}
+	for prefix, c := range routes {
+		rc.routes[prefix] = c
+		rc.prefixes = append(rc.prefixes, prefix)
+	}
+	// Longest first, so the first match is the longest; ties in length are sorted for WriteTo
+	sort.Slice(rc.prefixes, func(i, j int) bool {
+		if len(rc.prefixes[i]) != len(rc.prefixes[j]) {
+			return len(rc.prefixes[i]) > len(rc.prefixes[j])
+		}
+		return rc.prefixes[i] < rc.prefixes[j]
+	})
+	rc.addBackend("", def)
+	for _, prefix := range sortedKeys(rc.routes) {
+		rc.addBackend(prefix, rc.routes[prefix])
+	}
+	return rc
+}
+
+func (rc *RoutingCache) addBackend(name string, c Cacher) {
+	for _, b := range rc.backends {
+		if b == c {
+			return
+		}
+	}
+	rc.backends = append(rc.backends, c)
+	rc.names = append(rc.names, name)
+}
+
+// route returns the Cacher of id
+func (rc *RoutingCache) route(id string) Cacher {
+	for _, prefix := range rc.prefixes {
+		if strings.HasPrefix(id, prefix) {
+			return rc.routes[prefix]
+		}
+	}
+	return rc.def
+}
+
+// all calls op on every Cacher, and returns the first error
+func (rc *RoutingCache) all(op func(Cacher) error) error {
+	var first error
+	for _, c := range rc.backends {
+		if err := op(c); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// IsChanged checks if the model is new or changed in the Cacher of its id
+func (rc *RoutingCache) IsChanged(m PushModel) bool {
+	return rc.route(m.GetID()).IsChanged(m)
+}
+
+// Put puts the model's check-sum in the Cacher of its id
+func (rc *RoutingCache) Put(m PushModel) {
+	rc.route(m.GetID()).Put(m)
+}
+
+// Read reads the check-sums of every Cacher from persistent storage
+func (rc *RoutingCache) Read() error {
+	return rc.all(func(c Cacher) error {
+		return c.Read()
+	})
+}
+
+// Save saves the check-sums of every Cacher to persistent storage
+func (rc *RoutingCache) Save() error {
+	return rc.all(func(c Cacher) error {
+		return c.Save()
+	})
+}
+
+// Size returns the number of check-sums in all the Cachers
+func (rc *RoutingCache) Size() int64 {
+	var n int64
+	for _, c := range rc.backends {
+		n += c.Size()
+	}
+	return n
+}
+
+// Get returns the check-sum for the given id from the Cacher of the id
+func (rc *RoutingCache) Get(id string) string {
+	return rc.route(id).Get(id)
+}
+
+// Delete deletes the check-sum for the given id from the Cacher of the id
+func (rc *RoutingCache) Delete(id string) error {
+	return rc.route(id).Delete(id)
+}
+
+// Reset empties every Cacher
+func (rc *RoutingCache) Reset() error {
+	return rc.all(func(c Cacher) error {
+		return c.Reset()
+	})
+}
+
+// Dump dumps the content of every Cacher to an io.Reader
+func (rc *RoutingCache) Dump() (io.Reader, error) {
+	buf := &bytes.Buffer{}
+	if _, err := rc.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteTo writes the content of every Cacher to w, as one JSON object keyed by route. The content
+// of a Cacher is written as it is when it is JSON, as null when it is empty, and as a base64 string
+// otherwise, e.g. for a FileCache with WithBinaryFormat.
+func (rc *RoutingCache) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	if _, err := io.WriteString(cw, "{"); err != nil {
+		return cw.n, err
+	}
+	for i, c := range rc.backends {
+		key, err := json.Marshal(rc.names[i])
+		if err != nil {
+			return cw.n, err
+		}
+		if i > 0 {
+			key = append([]byte(","), key...)
+		}
+		buf := &bytes.Buffer{}
+		if _, err = c.WriteTo(buf); err != nil {
+			return cw.n, err
+		}
+		value, err := dumpValue(buf.Bytes())
+		if err != nil {
+			return cw.n, err
+		}
+		if _, err = cw.Write(append(append(key, ':'), value...)); err != nil {
+			return cw.n, err
+		}
+	}
+	_, err := io.WriteString(cw, "}\n")
+	return cw.n, err
+}
+
+// dumpValue returns the JSON value of the content b of a Cacher: null when b is empty, b itself when
+// it is valid JSON, and a base64 string of b otherwise
+func dumpValue(b []byte) ([]byte, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return []byte("null"), nil
+	}
+	if json.Valid(b) {
+		return json.Marshal(json.RawMessage(b))
+	}
+	return json.Marshal(b)
+}
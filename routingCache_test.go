@@ -0,0 +1,106 @@
+package pushstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestRoutingCacheWriteTo(t *testing.T) {
+	tests := []struct {
+		name   string
+		shared bool
+		save   bool
+		ids    []string
+		want   map[string][]string
+	}{
+		{name: "unsaved", ids: []string{"a", "t1/b"},
+			want: map[string][]string{"": nil, "t1/": nil, "t2/": nil}},
+		{name: "saved", save: true, ids: []string{"a", "t1/b", "t2/c"},
+			want: map[string][]string{"": {"a"}, "t1/": {"t1/b"}, "t2/": {"t2/c"}}},
+		{name: "shared backend", shared: true, save: true, ids: []string{"a", "t1/b", "t2/c"},
+			want: map[string][]string{"": {"a"}, "t1/": {"t1/b", "t2/c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t1, t2 := newTestCache(t), newTestCache(t)
+			if tt.shared {
+				t2 = t1
+			}
+			rc := NewRoutingCache(newTestCache(t), map[string]Cacher{"t1/": t1, "t2/": t2})
+			for _, id := range tt.ids {
+				rc.Put(&testModel{ID: id, Payload: "p"})
+			}
+			if tt.save {
+				if err := rc.Save(); err != nil {
+					t.Fatalf("Save failed; error = %v", err)
+				}
+			}
+			r, err := rc.Dump()
+			if err != nil {
+				t.Fatalf("Dump failed; error = %v", err)
+			}
+			raw, _ := io.ReadAll(r)
+			var doc map[string]*struct {
+				Entries map[string]json.RawMessage `json:"entries"`
+			}
+			if err := json.Unmarshal(raw, &doc); err != nil {
+				t.Fatalf("dump %q is not one JSON object; error = %v", raw, err)
+			}
+			got := map[string][]string{}
+			for route, sf := range doc {
+				got[route] = nil
+				if sf == nil {
+					continue
+				}
+				for id := range sf.Entries {
+					got[route] = append(got[route], id)
+				}
+				sort.Strings(got[route])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoutingCacheWriteToNotJSON(t *testing.T) {
+	binary := newTestCache(t, WithBinaryFormat())
+	rc := NewRoutingCache(newTestCache(t), map[string]Cacher{"b/": binary})
+	rc.Put(&testModel{ID: "a", Payload: "p"})
+	rc.Put(&testModel{ID: "b/c", Payload: "p"})
+	if err := rc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	r, err := rc.Dump()
+	if err != nil {
+		t.Fatalf("Dump failed; error = %v", err)
+	}
+	raw, _ := io.ReadAll(r)
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("dump %q is not one JSON object; error = %v", raw, err)
+	}
+	var content []byte
+	if err := json.Unmarshal(doc["b/"], &content); err != nil {
+		t.Fatalf("the binary content %q is not a base64 string; error = %v", doc["b/"], err)
+	}
+	want := &bytes.Buffer{}
+	if _, err := binary.WriteTo(want); err != nil {
+		t.Fatalf("WriteTo failed; error = %v", err)
+	}
+	if !bytes.Equal(content, want.Bytes()) {
+		t.Errorf("the binary content is %q; want %q", content, want.Bytes())
+	}
+	if kind := jsonKind(doc[""]); kind != "object" {
+		t.Errorf("the JSON content is %s; want object", kind)
+	}
+}
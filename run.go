@@ -0,0 +1,42 @@
+package pushstate
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// BeginRun starts tracking the ids that are checked or put, so EndRun can delete the ones that were
+// not, e.g. the models that are no longer in the source after a full push. Beginning a run while one
+// is begun starts it over.
+func (fc *FileCache) BeginRun() {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	fc.runSeen = map[string]bool{}
+}
+
+// EndRun ends the run begun by BeginRun, deletes the check-sums of the ids that were neither checked
+// nor put during it, nor pinned, and saves the cache once. It returns the deleted ids, as stored, see
+// WithKeyTransform, and ErrNoRun when no run is begun.
+func (fc *FileCache) EndRun() ([]string, error) {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	if fc.draining {
+		return nil, ErrDraining
+	}
+	if fc.runSeen == nil {
+		return nil, ErrNoRun
+	}
+	seen := fc.runSeen
+	fc.runSeen = nil
+	removed, err := fc.collect(seen)
+	fc.log.Debugw("ended run", "seen", len(seen), "removed", len(removed), "entries", fc.entries.len())
+	return removed, err
+}
+
+// touch records that id was checked or put in the current run; the caller must hold the lock
+func (fc *FileCache) touch(id string) {
+	if fc.runSeen != nil {
+		fc.runSeen[id] = true
+	}
+}
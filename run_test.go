@@ -0,0 +1,65 @@
+package pushstate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		run         func(fc *FileCache)
+		wantRemoved []string
+	}{
+		{name: "nothing touched", run: func(*FileCache) {}, wantRemoved: []string{"a", "b", "c"}},
+		{name: "checked", run: func(fc *FileCache) { _ = fc.IsChanged(&testModel{ID: "a", Payload: "2"}) },
+			wantRemoved: []string{"b", "c"}},
+		{name: "put", run: func(fc *FileCache) { putAll(fc, "1", "b", "d") }, wantRemoved: []string{"a", "c"}},
+		{name: "get does not touch", run: func(fc *FileCache) { _ = fc.Get("a") }, wantRemoved: []string{"a", "b", "c"}},
+		{name: "pinned", run: func(fc *FileCache) { fc.Pin("c") }, wantRemoved: []string{"a", "b"}},
+		{name: "begun over", run: func(fc *FileCache) { putAll(fc, "1", "a"); fc.BeginRun() },
+			wantRemoved: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a", "b", "c")
+			fc.BeginRun()
+			tt.run(fc)
+			removed, err := fc.EndRun()
+			if err != nil {
+				t.Fatalf("EndRun failed; error = %v", err)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("EndRun removed %v, expected %v", removed, tt.wantRemoved)
+			}
+			for _, id := range tt.wantRemoved {
+				if fc.Get(id) != "" {
+					t.Errorf("%s still has a check-sum", id)
+				}
+			}
+			if disk := onDisk(t, fc); int64(len(disk)) != fc.Size() {
+				t.Errorf("the file has %d check-sums, expected %d", len(disk), fc.Size())
+			}
+		})
+	}
+}
+
+func TestEndRunWithoutRun(t *testing.T) {
+	fc := newTestCache(t)
+	if _, err := fc.EndRun(); !errors.Is(err, ErrNoRun) {
+		t.Errorf("EndRun returned %v, expected %v", err, ErrNoRun)
+	}
+	fc.BeginRun()
+	if _, err := fc.EndRun(); err != nil {
+		t.Fatalf("EndRun failed; error = %v", err)
+	}
+	if _, err := fc.EndRun(); !errors.Is(err, ErrNoRun) {
+		t.Errorf("a second EndRun returned %v, expected %v", err, ErrNoRun)
+	}
+}
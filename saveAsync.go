@@ -0,0 +1,63 @@
+package pushstate
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// SaveAsync saves the cache like Save, but writes the file on a goroutine and returns at once.
+// The channel receives the result of the save, and is then closed.  The content is copied under
+// the lock, so the cache can be used while the file is written.  Only one asynchronous save runs at
+// a time; calls made while one runs wait for the next, which then saves the changes of all of them
+// at once.  The cache stays dirty when it changed while the file was written.
+func (fc *FileCache) SaveAsync() <-chan error {
+	ch := make(chan error, 1)
+
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	fc.asyncWaiters = append(fc.asyncWaiters, ch)
+	if !fc.asyncRunning {
+		fc.asyncRunning = true
+		go fc.runAsyncSaves()
+	}
+	return ch
+}
+
+// runAsyncSaves saves for the waiting SaveAsync calls until there are none left
+func (fc *FileCache) runAsyncSaves() {
+	for {
+		fc.cacheLock.Lock()
+		waiters := fc.asyncWaiters
+		fc.asyncWaiters = nil
+		if len(waiters) == 0 {
+			fc.asyncRunning = false
+			fc.cacheLock.Unlock()
+			return
+		}
+		var job *saveJob
+		var err error
+		changes := fc.changes
+		if fc.isDirty {
+			job, err = fc.prepareSave(fc.filename, fc.entries, true)
+		}
+		if err == nil && job == nil {
+			fc.isDirty = false
+		}
+		fc.cacheLock.Unlock()
+
+		if job != nil {
+			fc.runSave(job)
+
+			fc.cacheLock.Lock()
+			err = fc.finishSave(job)
+			if err == nil && fc.changes == changes {
+				fc.isDirty = false
+			}
+			fc.cacheLock.Unlock()
+		}
+		for _, ch := range waiters {
+			ch <- err
+			close(ch)
+		}
+	}
+}
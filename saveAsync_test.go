@@ -0,0 +1,59 @@
+package pushstate
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestSaveAsync(t *testing.T) {
+	tests := []struct {
+		name  string
+		dirty bool
+		ids   int
+	}{
+		{name: "clean"},
+		{name: "dirty", dirty: true, ids: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			for i := 0; i < tt.ids; i++ {
+				putAll(fc, "1", fmt.Sprint(i))
+			}
+			ch := fc.SaveAsync()
+			if err := <-ch; err != nil {
+				t.Fatalf("SaveAsync failed; error = %v", err)
+			}
+			if _, ok := <-ch; ok {
+				t.Error("the channel is not closed after the result")
+			}
+			if fc.IsDirty() || len(onDisk(t, fc)) != tt.ids {
+				t.Errorf("IsDirty is %t and the file has %d entries, expected %d", fc.IsDirty(), len(onDisk(t, fc)), tt.ids)
+			}
+		})
+	}
+}
+
+func TestSaveAsyncConcurrent(t *testing.T) {
+	fc := newTestCache(t)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			putAll(fc, "1", fmt.Sprint(i))
+			if err := <-fc.SaveAsync(); err != nil {
+				t.Errorf("SaveAsync failed; error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if fc.IsDirty() || len(onDisk(t, fc)) != 20 {
+		t.Errorf("IsDirty is %t and the file has %d entries after all saves, expected 20", fc.IsDirty(), len(onDisk(t, fc)))
+	}
+}
@@ -0,0 +1,168 @@
+package pushstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// section is a named view over a part of the FileCache's file
+type section struct {
+	fc   *FileCache
+	name string
+}
+
+// Section returns a cache for the named section in the same file as this cache.
+// Each section is kept as a nested JSON object in the file and is isolated from the other sections and
+// from the cache's own entries, but they all share the cache's lock, and Read and Save always read and
+// save the whole file with all the sections together. Sections do not notify subscribers, but their ids
+// are checked and transformed like the cache's own, by WithRejectEmptyID, WithDenyList,
+// WithMaxChecksumLen and WithKeyTransform.
+func (fc *FileCache) Section(name string) Cacher {
+	return &section{fc: fc, name: name}
+}
+
+// IsChanged checks if the model is new or changed in this section
+func (s *section) IsChanged(m PushModel) bool {
+	s.fc.cacheLock.Lock()
+	defer s.fc.cacheLock.Unlock()
+
+	if err := s.fc.checkID(m); err != nil {
+		s.fc.warnw(s.fc.log, "check of model failed", "section", s.name, "error", err)
+		return true
+	}
+	cs, ok := s.fc.sections[s.name][s.fc.key(m.GetID())]
+	if !ok {
+		return true
+	}
+	return cs != s.fc.makeCheckSum(m)
+}
+
+// Put puts the model's check-sum in this section
+func (s *section) Put(m PushModel) {
+	s.putChecksum(m)
+}
+
+// putChecksum puts the model's check-sum as Put does, and returns the check-sum and whether it was
+// stored, in one locked operation so no other put comes in between
+func (s *section) putChecksum(m PushModel) (string, bool) {
+	s.fc.cacheLock.Lock()
+	defer s.fc.cacheLock.Unlock()
+
+	if err := s.fc.checkID(m); err != nil {
+		s.fc.warnw(s.fc.log, "put of model failed", "section", s.name, "error", err)
+		return "", false
+	}
+	if s.fc.draining {
+		s.fc.warnw(s.fc.log, "put of model failed", "section", s.name, "error", ErrDraining)
+		return "", false
+	}
+	id := s.fc.key(m.GetID())
+	if s.fc.denied[id] {
+		s.fc.warnw(s.fc.log, "put of model failed", "section", s.name, "error", ErrDenied)
+		return "", false
+	}
+	cs := s.fc.makeCheckSum(m)
+	if err := s.fc.checkLen(cs); err != nil {
+		s.fc.warnw(s.fc.log, "put of model failed", "section", s.name, "error", err)
+		return "", false
+	}
+	entries, ok := s.fc.sections[s.name]
+	if !ok {
+		entries = map[string]string{}
+		s.fc.sections[s.name] = entries
+	}
+	entries[id] = cs
+	s.fc.markDirty()
+	return cs, true
+}
+
+// Read reads the whole file, including all sections
+func (s *section) Read() error {
+	return s.fc.Read()
+}
+
+// Save saves the whole file, including all sections
+func (s *section) Save() error {
+	return s.fc.Save()
+}
+
+// Size returns the number of check-sums in this section
+func (s *section) Size() int64 {
+	s.fc.cacheLock.Lock()
+	defer s.fc.cacheLock.Unlock()
+
+	return int64(len(s.fc.sections[s.name]))
+}
+
+// Get returns the check-sum for the given id in this section
+func (s *section) Get(id string) string {
+	s.fc.cacheLock.Lock()
+	defer s.fc.cacheLock.Unlock()
+
+	return s.fc.sections[s.name][s.fc.key(id)]
+}
+
+// Delete deletes the check-sum for the given id in this section and saves the file
+func (s *section) Delete(id string) error {
+	s.fc.cacheLock.Lock()
+	defer s.fc.cacheLock.Unlock()
+
+	if s.fc.draining {
+		return ErrDraining
+	}
+	delete(s.fc.sections[s.name], s.fc.key(id))
+	s.fc.markDirty()
+	if err := s.fc.saveToFile(s.fc.filename, s.fc.entries); err != nil {
+		return err
+	}
+	s.fc.isDirty = false
+	return nil
+}
+
+// Reset empties this section and saves the file
+func (s *section) Reset() error {
+	s.fc.cacheLock.Lock()
+	defer s.fc.cacheLock.Unlock()
+
+	if s.fc.draining {
+		return ErrDraining
+	}
+	delete(s.fc.sections, s.name)
+	s.fc.markDirty()
+	if err := s.fc.saveToFile(s.fc.filename, s.fc.entries); err != nil {
+		return err
+	}
+	s.fc.isDirty = false
+	return nil
+}
+
+// Dump dumps the in-memory content of this section as a JSON object to an io.Reader
+func (s *section) Dump() (io.Reader, error) {
+	buf := &bytes.Buffer{}
+	if _, err := s.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteTo writes the in-memory content of this section as a JSON object to w
+func (s *section) WriteTo(w io.Writer) (int64, error) {
+	s.fc.cacheLock.Lock()
+	defer s.fc.cacheLock.Unlock()
+
+	entries := s.fc.sections[s.name]
+	if entries == nil {
+		entries = map[string]string{}
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
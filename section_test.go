@@ -0,0 +1,93 @@
+package pushstate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestSectionIsolation(t *testing.T) {
+	fc := newTestCache(t)
+	s1, s2 := fc.Section("one"), fc.Section("two")
+	putAll(fc, "1", "a")
+	s1.Put(&testModel{ID: "a", Payload: "2"})
+	s2.Put(&testModel{ID: "b", Payload: "3"})
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+
+	c := reopen(t, fc)
+	tests := []struct {
+		name     string
+		cache    Cacher
+		id       string
+		payload  string
+		wantSize int64
+	}{
+		{name: "cache", cache: c, id: "a", payload: "1", wantSize: 1},
+		{name: "section one", cache: c.Section("one"), id: "a", payload: "2", wantSize: 1},
+		{name: "section two", cache: c.Section("two"), id: "b", payload: "3", wantSize: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := tt.cache.Size(); n != tt.wantSize {
+				t.Errorf("Size is %d, expected %d", n, tt.wantSize)
+			}
+			if tt.cache.IsChanged(&testModel{ID: tt.id, Payload: tt.payload}) {
+				t.Errorf("%s is changed after Read", tt.id)
+			}
+		})
+	}
+
+	if err := c.Section("one").Reset(); err != nil {
+		t.Fatalf("Reset failed; error = %v", err)
+	}
+	c = reopen(t, c)
+	if c.Section("one").Size() != 0 || c.Section("two").Size() != 1 || c.Size() != 1 {
+		t.Error("Reset of one section changed the others")
+	}
+}
+
+func TestSectionPut(t *testing.T) {
+	sumLen := len((&checksum.Murmur3CheckSum{}).SumString("x"))
+	tests := []struct {
+		name       string
+		opts       []Option
+		id         string
+		wantKey    string
+		wantStored bool
+	}{
+		{name: "stored", id: "a", wantKey: "a", wantStored: true},
+		{name: "within", opts: []Option{WithMaxChecksumLen(sumLen)}, id: "a", wantKey: "a", wantStored: true},
+		{name: "too long", opts: []Option{WithMaxChecksumLen(sumLen - 1)}, id: "a", wantKey: "a"},
+		{name: "empty id", opts: []Option{WithRejectEmptyID()}, id: "", wantKey: ""},
+		{name: "denied", opts: []Option{WithDenyList("a")}, id: "a", wantKey: "a"},
+		{name: "key transform", opts: []Option{WithKeyTransform("upper", strings.ToUpper)}, id: "a", wantKey: "A",
+			wantStored: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.opts...)
+			s := fc.Section("s")
+			m := &testModel{ID: tt.id, Payload: "1"}
+			s.Put(m)
+			if stored := s.Get(tt.id) != ""; stored != tt.wantStored {
+				t.Errorf("a check-sum was stored %t, expected %t", stored, tt.wantStored)
+			}
+			if stored := fc.sections["s"][tt.wantKey] != ""; stored != tt.wantStored {
+				t.Errorf("a check-sum was stored as %q %t, expected %t", tt.wantKey, stored, tt.wantStored)
+			}
+			if changed := s.IsChanged(m); changed == tt.wantStored {
+				t.Errorf("IsChanged returned %t, expected %t", changed, !tt.wantStored)
+			}
+			if !tt.wantStored && fc.IsDirty() {
+				t.Error("a refused put marked the cache as dirty")
+			}
+		})
+	}
+}
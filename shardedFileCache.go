@@ -0,0 +1,180 @@
+package pushstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tkandal/checksum"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// ShardedFileCache spreads the check-sums over several files by a hash of the id, so a save only
+// rewrites the files with changes
+type ShardedFileCache struct {
+	shards []*FileCache
+}
+
+// NewShardedFileCache creates a cache with n shards; a state-file sf of state.json gives the files
+// state-0.json to state-<n-1>.json, and the options apply to every shard
+func NewShardedFileCache(sf string, n int, cs checksum.CheckSum, log *zap.SugaredLogger, opts ...Option) *ShardedFileCache {
+	if n < 1 {
+		n = 1
+	}
+	ext := filepath.Ext(sf)
+	base := strings.TrimSuffix(sf, ext)
+	sc := &ShardedFileCache{shards: make([]*FileCache, n)}
+	for i := range sc.shards {
+		sc.shards[i] = NewFileCache(fmt.Sprintf("%s-%d%s", base, i, ext), cs, log, opts...)
+	}
+	return sc
+}
+
+func (sc *ShardedFileCache) shard(id string) *FileCache {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return sc.shards[h.Sum32()%uint32(len(sc.shards))]
+}
+
+// IsChanged checks if the model is new or changed
+func (sc *ShardedFileCache) IsChanged(m PushModel) bool {
+	return sc.shard(m.GetID()).IsChanged(m)
+}
+
+// Put puts the model's check-sum in its shard
+func (sc *ShardedFileCache) Put(m PushModel) {
+	sc.shard(m.GetID()).Put(m)
+}
+
+// Read reads all the shards
+func (sc *ShardedFileCache) Read() error {
+	for _, fc := range sc.shards {
+		if err := fc.Read(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save saves the shards that have changes
+func (sc *ShardedFileCache) Save() error {
+	for _, fc := range sc.shards {
+		if err := fc.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every shard, which stops their saving of WithAutoSave and saves them a final time, and
+// returns the errors of all the shards that failed
+func (sc *ShardedFileCache) Close() error {
+	var errs joinedError
+	for i, fc := range sc.shards {
+		if err := fc.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close of shard %d failed; error = %w", i, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// joinedError is several errors as one, like errors.Join of Go 1.20 and later
+type joinedError []error
+
+func (e joinedError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any of the errors is target, see errors.Is
+func (e joinedError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error that is assignable to target, and sets target to it, see errors.As
+func (e joinedError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the errors, for the Go versions that follow an Unwrap that returns several errors
+func (e joinedError) Unwrap() []error {
+	return e
+}
+
+// Size returns the number of check-sums in all the shards
+func (sc *ShardedFileCache) Size() int64 {
+	var n int64
+	for _, fc := range sc.shards {
+		n += fc.Size()
+	}
+	return n
+}
+
+// Get returns the check-sum for the given id
+func (sc *ShardedFileCache) Get(id string) string {
+	return sc.shard(id).Get(id)
+}
+
+// Delete deletes the check-sum for the given id and saves its shard
+func (sc *ShardedFileCache) Delete(id string) error {
+	return sc.shard(id).Delete(id)
+}
+
+// Reset empties all the shards
+func (sc *ShardedFileCache) Reset() error {
+	for _, fc := range sc.shards {
+		if err := fc.Reset(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dump dumps the check-sums of all the shards as one JSON object of ids and check-sums
+func (sc *ShardedFileCache) Dump() (io.Reader, error) {
+	buf := &bytes.Buffer{}
+	if _, err := sc.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteTo writes the check-sums of all the shards as one JSON object of ids and check-sums to w
+func (sc *ShardedFileCache) WriteTo(w io.Writer) (int64, error) {
+	entries := map[string]string{}
+	for _, fc := range sc.shards {
+		for _, e := range fc.Entries() {
+			entries[e.ID] = e.Checksum
+		}
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
@@ -0,0 +1,108 @@
+package pushstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestShardedFileCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		n          int
+		wantShards int
+	}{
+		{name: "no shards", n: 0, wantShards: 1},
+		{name: "one shard", n: 1, wantShards: 1},
+		{name: "four shards", n: 4, wantShards: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sf := filepath.Join(dir, "state.json")
+			sc := NewShardedFileCache(sf, tt.n, &checksum.Murmur3CheckSum{}, nil)
+			for i := 0; i < 20; i++ {
+				sc.Put(&testModel{ID: fmt.Sprintf("id-%d", i), Payload: "1"})
+			}
+			if err := sc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			for i := 0; i < tt.wantShards; i++ {
+				if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("state-%d.json", i))); err != nil {
+					t.Errorf("shard %d has no file; error = %v", i, err)
+				}
+			}
+
+			c := NewShardedFileCache(sf, tt.n, &checksum.Murmur3CheckSum{}, nil)
+			if err := c.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if n := c.Size(); n != 20 {
+				t.Errorf("Size is %d after Read, expected 20", n)
+			}
+			r, err := c.Dump()
+			if err != nil {
+				t.Fatalf("Dump failed; error = %v", err)
+			}
+			entries := map[string]string{}
+			if err = json.NewDecoder(r).Decode(&entries); err != nil || len(entries) != 20 {
+				t.Errorf("Dump has %d entries and %v, expected 20", len(entries), err)
+			}
+		})
+	}
+}
+
+func TestShardedFileCacheClose(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantErrs int
+	}{
+		{name: "saved", opts: []Option{WithAutoSave(time.Hour)}},
+		{name: "failed", opts: []Option{WithAutoSave(time.Hour), WithFileSystem(readOnlyFileSystem{})}, wantErrs: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			before := runtime.NumGoroutine()
+			sc := NewShardedFileCache(filepath.Join(dir, "state.json"), 4, &checksum.Murmur3CheckSum{}, nil, tt.opts...)
+			for i := 0; i < 20; i++ {
+				sc.Put(&testModel{ID: fmt.Sprintf("id-%d", i), Payload: "1"})
+			}
+			err := sc.Close()
+			if after := runtime.NumGoroutine(); after > before {
+				t.Errorf("%d goroutines were left running after Close", after-before)
+			}
+			if tt.wantErrs == 0 {
+				if err != nil {
+					t.Fatalf("Close failed; error = %v", err)
+				}
+				for _, fc := range sc.shards {
+					if fc.IsDirty() {
+						t.Errorf("Close did not save %s", fc.filename)
+					}
+				}
+				return
+			}
+			var errs joinedError
+			if !errors.As(err, &errs) || len(errs) != tt.wantErrs {
+				t.Fatalf("Close returned %v, expected %d errors", err, tt.wantErrs)
+			}
+			if !errors.Is(err, fs.ErrPermission) {
+				t.Errorf("errors.Is does not find %v in %v", fs.ErrPermission, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package pushstate
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// InstallSignalHandler saves the cache when one of the signals is received, SIGINT and SIGTERM when
+// none are given, and returns a context that is cancelled after that save, or when ctx is done.
+// The caller stops the handler with the returned cancel function, and can keep its own signal
+// handling since signal.Notify delivers the signals to every registered channel.
+func (fc *FileCache) InstallSignalHandler(ctx context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sigCtx, cancel := context.WithCancel(ctx)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, signals...)
+
+	go func() {
+		defer signal.Stop(sigs)
+		defer cancel()
+
+		select {
+		case sig := <-sigs:
+			if err := fc.Save(); err != nil {
+				fc.warnw(fc.logger(), "save on signal failed", "signal", sig.String(), "error", err)
+				return
+			}
+			fc.logger().Debugw("saved state-cache on signal", "signal", sig.String())
+		case <-sigCtx.Done():
+		}
+	}()
+	return sigCtx, cancel
+}
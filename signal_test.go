@@ -0,0 +1,49 @@
+//go:build unix
+
+package pushstate
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestInstallSignalHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		signal   bool
+		wantSave bool
+	}{
+		{name: "signal", signal: true, wantSave: true},
+		{name: "cancel", signal: false, wantSave: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			putAll(fc, "1", "a")
+			ctx, cancel := fc.InstallSignalHandler(context.Background(), syscall.SIGUSR1)
+			defer cancel()
+
+			if tt.signal {
+				if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				cancel()
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("the context was not cancelled")
+			}
+			if saved := !fc.IsDirty(); saved != tt.wantSave {
+				t.Errorf("the cache is saved %t, expected %t", saved, tt.wantSave)
+			}
+		})
+	}
+}
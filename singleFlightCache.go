@@ -0,0 +1,120 @@
+package pushstate
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/sync/singleflight"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// SingleFlightCache is a Cacher where concurrent IsChanged and PutIfChanged calls for the same model
+// share one call to the wrapped Cacher, and thus one check-sum computation, instead of each
+// computing it.  A model is the same when it has the same id and is the same pointer, or has the same
+// ChecksumKey when it is a ChecksumKeyer; other models are not shared.  Everything else goes
+// straight to the wrapped Cacher.
+type SingleFlightCache struct {
+	cacher Cacher
+	group  singleflight.Group
+}
+
+// NewSingleFlightCache wraps c
+func NewSingleFlightCache(c Cacher) *SingleFlightCache {
+	return &SingleFlightCache{cacher: c}
+}
+
+// flightKey returns the key that concurrent calls for m share, and false when m can not be shared
+func flightKey(op string, m PushModel) (string, bool) {
+	key, ok := memoKey(m)
+	if !ok {
+		return "", false
+	}
+	if _, isPointer := key.(PushModel); isPointer {
+		return fmt.Sprintf("%s\x00%s\x00%p", op, m.GetID(), key), true
+	}
+	return fmt.Sprintf("%s\x00%s\x00%v", op, m.GetID(), key), true
+}
+
+// IsChanged checks if the model is new or changed, sharing the check with concurrent calls
+func (sc *SingleFlightCache) IsChanged(m PushModel) bool {
+	key, ok := flightKey("changed", m)
+	if !ok {
+		return sc.cacher.IsChanged(m)
+	}
+	v, _, _ := sc.group.Do(key, func() (interface{}, error) {
+		return sc.cacher.IsChanged(m), nil
+	})
+	return v.(bool)
+}
+
+// PutIfChanged puts the model's check-sum when it is new or changed, and returns whether it was.
+// Of concurrent calls for the same model only the one that did the put returns true, so the model is
+// pushed once.
+func (sc *SingleFlightCache) PutIfChanged(m PushModel) bool {
+	putIfChanged := func() bool {
+		if !sc.cacher.IsChanged(m) {
+			return false
+		}
+		sc.cacher.Put(m)
+		return true
+	}
+	key, ok := flightKey("put", m)
+	if !ok {
+		return putIfChanged()
+	}
+	// Only the caller whose function ran did the put, the others share its result
+	ran := false
+	v, _, _ := sc.group.Do(key, func() (interface{}, error) {
+		ran = true
+		return putIfChanged(), nil
+	})
+	return ran && v.(bool)
+}
+
+// Put puts the model's check-sum in the cache
+func (sc *SingleFlightCache) Put(m PushModel) {
+	sc.cacher.Put(m)
+}
+
+// Read reads the check-sums from persistent storage
+func (sc *SingleFlightCache) Read() error {
+	return sc.cacher.Read()
+}
+
+// Save saves the check-sums to persistent storage
+func (sc *SingleFlightCache) Save() error {
+	return sc.cacher.Save()
+}
+
+// Size returns the number of check-sums
+func (sc *SingleFlightCache) Size() int64 {
+	return sc.cacher.Size()
+}
+
+// Get returns the check-sum for the given id
+func (sc *SingleFlightCache) Get(id string) string {
+	return sc.cacher.Get(id)
+}
+
+// Delete deletes the check-sum for the given id
+func (sc *SingleFlightCache) Delete(id string) error {
+	return sc.cacher.Delete(id)
+}
+
+// Reset empties the cache
+func (sc *SingleFlightCache) Reset() error {
+	return sc.cacher.Reset()
+}
+
+// Dump dumps the whole content to an io.Reader
+func (sc *SingleFlightCache) Dump() (io.Reader, error) {
+	return sc.cacher.Dump()
+}
+
+// WriteTo writes the whole content to w
+func (sc *SingleFlightCache) WriteTo(w io.Writer) (int64, error) {
+	return sc.cacher.WriteTo(w)
+}
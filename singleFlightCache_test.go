@@ -0,0 +1,82 @@
+package pushstate
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// slowCacher counts the calls of IsChanged, which take long enough for concurrent calls to overlap
+type slowCacher struct {
+	Cacher
+	calls int64
+}
+
+func (s *slowCacher) IsChanged(m PushModel) bool {
+	atomic.AddInt64(&s.calls, 1)
+	time.Sleep(50 * time.Millisecond)
+	return s.Cacher.IsChanged(m)
+}
+
+func TestSingleFlightCache(t *testing.T) {
+	const goroutines = 20
+	shared := &testModel{ID: "a", Payload: "1"}
+	tests := []struct {
+		name      string
+		model     func() PushModel
+		put       bool
+		wantCalls int64
+	}{
+		{name: "is changed of the same model", model: func() PushModel { return shared }, wantCalls: 1},
+		{name: "is changed of copies", model: func() PushModel { return &testModel{ID: "a", Payload: "1"} }, wantCalls: goroutines},
+		{name: "put if changed of the same model", model: func() PushModel { return shared }, put: true, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slow := &slowCacher{Cacher: NewMemoryCache(&checksum.Murmur3CheckSum{}, nil)}
+			sc := NewSingleFlightCache(slow)
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			var changed int64
+			for i := 0; i < goroutines; i++ {
+				m := tt.model()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					var ok bool
+					if tt.put {
+						ok = sc.PutIfChanged(m)
+					} else {
+						ok = sc.IsChanged(m)
+					}
+					if ok {
+						atomic.AddInt64(&changed, 1)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+			if calls := atomic.LoadInt64(&slow.calls); calls != tt.wantCalls {
+				t.Errorf("the wrapped IsChanged ran %d times, expected %d", calls, tt.wantCalls)
+			}
+			wantChanged := int64(goroutines)
+			if tt.put {
+				wantChanged = 1
+				if sc.Size() != 1 {
+					t.Errorf("Size returned %d, expected 1", sc.Size())
+				}
+			}
+			if changed != wantChanged {
+				t.Errorf("%d calls reported a change, expected %d", changed, wantChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package pushstate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// fileVersion is the newest state-file format this build reads and the one it writes.
+// Version 0 is the legacy format, a plain JSON object of ids and check-sums without a header.
+// Version 1 has a header, and the entries as an object of ids and check-sums.
+// Version 2 has the entries as an object of ids and entry objects with the check-sum and its metadata.
+const fileVersion = 2
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type fileHeader struct {
+	Version   int      `json:"version"`
+	Pinned    []string `json:"pinned,omitempty"`
+	Integrity string   `json:"integrity,omitempty"`
+	// The name of the transform the ids are stored with, see WithKeyTransform
+	KeyTransform string `json:"keyTransform,omitempty"`
+	// The counters of the cache as of the save, see WithPersistStats
+	Stats *stats `json:"stats,omitempty"`
+	// The deleted ids and when they were deleted, see WithTombstones
+	Tombstones map[string]time.Time `json:"tombstones,omitempty"`
+}
+
+// stateFile is the decoded content of a state-file in any version
+type stateFile struct {
+	Header   fileHeader                   `json:"header"`
+	Entries  map[string]string            `json:"entries"`
+	Meta     map[string]entryMeta         `json:"-"`
+	Sections map[string]map[string]string `json:"sections,omitempty"`
+	// The entries of a file decoded into a sorted store, see WithSortedArrayStore; Entries and Meta
+	// are then empty
+	sorted *sortedStore
+}
+
+// entryMeta is the metadata kept with an entry's check-sum
+type entryMeta struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// The name of the algorithm of the check-sum, see WithAlgorithmName
+	Algorithm string
+	// The order the id was added in, or 0 when not recorded, see WithOrderedEntries
+	Seq uint64
+}
+
+// diskFile is how a state-file of the current version is written
+type diskFile struct {
+	Header   fileHeader                   `json:"header"`
+	Entries  map[string]diskEntry         `json:"entries"`
+	Sections map[string]map[string]string `json:"sections,omitempty"`
+}
+
+type diskEntry struct {
+	Checksum  string     `json:"checksum"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	Algorithm string     `json:"algorithm,omitempty"`
+	Seq       uint64     `json:"seq,omitempty"`
+}
+
+func newStateFile() *stateFile {
+	return &stateFile{Entries: map[string]string{}, Meta: map[string]entryMeta{}, Sections: map[string]map[string]string{}}
+}
+
+// clone returns a deep copy of sf
+func (sf *stateFile) clone() *stateFile {
+	c := &stateFile{Header: sf.Header, Entries: copyMap(sf.Entries), Meta: map[string]entryMeta{}, Sections: map[string]map[string]string{}}
+	c.Header.Pinned = append([]string(nil), sf.Header.Pinned...)
+	if sf.Header.Tombstones != nil {
+		c.Header.Tombstones = make(map[string]time.Time, len(sf.Header.Tombstones))
+		for id, t := range sf.Header.Tombstones {
+			c.Header.Tombstones[id] = t
+		}
+	}
+	for id, meta := range sf.Meta {
+		c.Meta[id] = meta
+	}
+	for name, entries := range sf.Sections {
+		c.Sections[name] = copyMap(entries)
+	}
+	return c
+}
+
+// toDisk returns sf in the form it is written in
+func (sf *stateFile) toDisk() *diskFile {
+	df := &diskFile{Header: sf.Header, Entries: make(map[string]diskEntry, len(sf.Entries)), Sections: sf.Sections}
+	for id, cs := range sf.Entries {
+		meta := sf.Meta[id]
+		df.Entries[id] = diskEntry{Checksum: cs, CreatedAt: timePtr(meta.CreatedAt), UpdatedAt: timePtr(meta.UpdatedAt),
+			Algorithm: meta.Algorithm, Seq: meta.Seq}
+	}
+	return df
+}
+
+// size returns the number of entries of sf
+func (sf *stateFile) size() int {
+	if sf.sorted != nil {
+		return sf.sorted.len()
+	}
+	return len(sf.Entries)
+}
+
+// integrity returns a check-sum of the entries and sections of sf, that does not depend on the format
+// they are written in. It hashes the JSON of {"e":entries,"s":sections}, with the entries by id,
+// written one entry at a time so the entries are not copied into a map of their own.
+func (sf *stateFile) integrity() string {
+	type entry struct {
+		Checksum  string `json:"c"`
+		CreatedAt int64  `json:"cr,omitempty"`
+		UpdatedAt int64  `json:"up,omitempty"`
+		Algorithm string `json:"a,omitempty"`
+		Seq       uint64 `json:"q,omitempty"`
+	}
+	h := sha256.New()
+	// Writes to a hash and marshal of strings, numbers and maps of them do not fail
+	write := func(v interface{}) {
+		b, _ := json.Marshal(v)
+		_, _ = h.Write(b)
+	}
+	_, _ = io.WriteString(h, `{"e":{`)
+	ids := sortedKeys(sf.Entries)
+	if sf.sorted != nil {
+		ids = sf.sorted.keys
+	}
+	for i, id := range ids {
+		cs, meta := sf.Entries[id], sf.Meta[id]
+		if sf.sorted != nil {
+			cs, meta = sf.sorted.sums[i], sf.sorted.metas[i]
+		}
+		if i > 0 {
+			_, _ = io.WriteString(h, ",")
+		}
+		write(id)
+		_, _ = io.WriteString(h, ":")
+		write(entry{Checksum: cs, CreatedAt: unixNano(meta.CreatedAt), UpdatedAt: unixNano(meta.UpdatedAt),
+			Algorithm: meta.Algorithm, Seq: meta.Seq})
+	}
+	_, _ = io.WriteString(h, "}")
+	if len(sf.Sections) > 0 {
+		_, _ = io.WriteString(h, `,"s":`)
+		write(sf.Sections)
+	}
+	_, _ = io.WriteString(h, "}")
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// timePtr returns a pointer to t, or nil when t is zero so it is left out of the file
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// fromDisk sets the entries of sf from df
+func (sf *stateFile) fromDisk(df *diskFile) {
+	for id, e := range df.Entries {
+		sf.Entries[id] = e.Checksum
+		if meta := e.meta(); meta != (entryMeta{}) {
+			sf.Meta[id] = meta
+		}
+	}
+	if df.Sections != nil {
+		sf.Sections = df.Sections
+	}
+}
+
+// meta returns the metadata of e
+func (e *diskEntry) meta() entryMeta {
+	meta := entryMeta{Algorithm: e.Algorithm, Seq: e.Seq}
+	if e.CreatedAt != nil {
+		meta.CreatedAt = *e.CreatedAt
+	}
+	if e.UpdatedAt != nil {
+		meta.UpdatedAt = *e.UpdatedAt
+	}
+	return meta
+}
+
+// decodeSortedEntries decodes the entries object of a state-file of the current version into s one
+// entry at a time, so no map of all the entries is built
+func decodeSortedEntries(raw json.RawMessage, s *sortedStore) error {
+	if kind := jsonKind(raw); kind == "nothing" || kind == "null" {
+		return nil
+	} else if kind != "object" {
+		return fmt.Errorf("%w; found %s entries, expected object", ErrWrongFileFormat, kind)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	// The opening brace, which jsonKind has seen
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		// The keys of an object are always strings
+		id, _ := tok.(string)
+		e := diskEntry{}
+		if err = dec.Decode(&e); err != nil {
+			return err
+		}
+		s.add(id, e.Checksum, e.meta())
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// pinned returns the set of pinned ids
+func (sf *stateFile) pinned() map[string]bool {
+	pinned := map[string]bool{}
+	for _, id := range sf.Header.Pinned {
+		pinned[id] = true
+	}
+	return pinned
+}
+
+// FileVersion returns the format version of the state-file at path, where 0 is the legacy format
+func FileVersion(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, &CacheError{Op: "open", Path: path, Err: err}
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	sf, err := decodeStateFile(f, path, false)
+	if err != nil {
+		return 0, err
+	}
+	return sf.Header.Version, nil
+}
+
+// encodeStateFile writes sf to w as JSON or in the binary format, gzipped when configured to
+func encodeStateFile(w io.Writer, sf *stateFile, wo writeOptions) error {
+	encode := func(w io.Writer) error {
+		if wo.binary {
+			return encodeBinary(w, sf)
+		}
+		return json.NewEncoder(w).Encode(sf.toDisk())
+	}
+	if !wo.compress {
+		return encode(w)
+	}
+	if wo.compressMin > 0 {
+		// The size is only known once encoded, so a small file is written plain from the buffer
+		buf := &bytes.Buffer{}
+		if err := encode(buf); err != nil {
+			return err
+		}
+		if int64(buf.Len()) < wo.compressMin {
+			_, err := w.Write(buf.Bytes())
+			return err
+		}
+		encode = func(w io.Writer) error {
+			_, err := w.Write(buf.Bytes())
+			return err
+		}
+	}
+	gw := gzip.NewWriter(w)
+	if err := encode(gw); err != nil {
+		_ = gw.Close()
+		return err
+	}
+	return gw.Close()
+}
+
+// decompressed returns a reader that decompresses r when it is gzipped, and else reads r as it is
+func decompressed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, gzipMagic) {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// decodeStateFile decodes a state-file in any format version up to fileVersion, and only the header of
+// a newer one; an empty file is an empty cache, and a gzipped file is decompressed.
+// The version in the header of the result is the version of the file. The entries are decoded into
+// a sorted store when sorted is true, straight from the file in the current JSON format, and through
+// the maps in the other formats.
+func decodeStateFile(r io.Reader, path string, sorted bool) (*stateFile, error) {
+	sf, err := decodeStateFileMaps(r, path, sorted)
+	if err != nil || !sorted || sf.sorted != nil {
+		return sf, err
+	}
+	sf.sorted = newSortedStore(sf.Entries, sf.Meta)
+	sf.Entries, sf.Meta = map[string]string{}, map[string]entryMeta{}
+	return sf, nil
+}
+
+// decodeStateFileMaps is decodeStateFile, and only decodes the entries into a sorted store when the
+// file has the current JSON format and sorted is true
+func decodeStateFileMaps(r io.Reader, path string, sorted bool) (*stateFile, error) {
+	sf := newStateFile()
+	r, err := decompressed(r)
+	if err != nil {
+		return nil, &CacheError{Op: "decompress", Path: path, Err: err}
+	}
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(binaryMagic)); bytes.Equal(magic, binaryMagic) {
+		if err := decodeBinary(br, sf); err != nil {
+			return nil, &CacheError{Op: "decode", Path: path, Err: err}
+		}
+		return sf, nil
+	}
+	r = br
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		if err == io.EOF {
+			return sf, nil
+		}
+		return nil, &CacheError{Op: "decode", Path: path, Err: err}
+	}
+	if kind := jsonKind(raw); kind != "object" {
+		return nil, &CacheError{Op: "decode", Path: path, Err: fmt.Errorf("%w; found %s, expected object", ErrWrongFileFormat, kind)}
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, &CacheError{Op: "decode", Path: path, Err: err}
+	}
+	// A legacy file may well have an id named header, but its value is then a check-sum, not an object
+	if header, ok := fields["header"]; !ok || jsonKind(header) != "object" {
+		if err := json.Unmarshal(raw, &sf.Entries); err != nil {
+			return nil, &CacheError{Op: "decode", Path: path, Err: err}
+		}
+		return sf, nil
+	}
+
+	if err := json.Unmarshal(fields["header"], &sf.Header); err != nil {
+		return nil, &CacheError{Op: "decode", Path: path, Err: err}
+	}
+	// The rest of a newer format may not decode into this build's types at all
+	if sf.Header.Version > fileVersion {
+		return sf, nil
+	}
+	if sf.Header.Version < 2 {
+		v1 := newStateFile()
+		if err := json.Unmarshal(raw, v1); err != nil {
+			return nil, &CacheError{Op: "decode", Path: path, Err: err}
+		}
+		if v1.Entries != nil {
+			sf.Entries = v1.Entries
+		}
+		if v1.Sections != nil {
+			sf.Sections = v1.Sections
+		}
+		return sf, nil
+	}
+	if sorted {
+		sf.sorted = &sortedStore{}
+		if err := decodeSortedEntries(fields["entries"], sf.sorted); err != nil {
+			return nil, &CacheError{Op: "decode", Path: path, Err: err}
+		}
+		if sections, ok := fields["sections"]; ok {
+			if err := json.Unmarshal(sections, &sf.Sections); err != nil {
+				return nil, &CacheError{Op: "decode", Path: path, Err: err}
+			}
+		}
+		if sf.Sections == nil {
+			sf.Sections = map[string]map[string]string{}
+		}
+		return sf, nil
+	}
+	df := &diskFile{}
+	if err := json.Unmarshal(raw, df); err != nil {
+		return nil, &CacheError{Op: "decode", Path: path, Err: err}
+	}
+	sf.fromDisk(df)
+	return sf, nil
+}
+
+// jsonKind returns the kind of the top-level JSON value in raw
+func jsonKind(raw json.RawMessage) string {
+	b := bytes.TrimLeft(raw, " \t\r\n")
+	if len(b) == 0 {
+		return "nothing"
+	}
+	switch b[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
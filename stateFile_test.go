@@ -0,0 +1,184 @@
+package pushstate
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestFileVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantVersion int
+		wantErr     error
+		wantEntries map[string]string
+	}{
+		{name: "legacy", content: `{"a":"1"}`, wantEntries: map[string]string{"a": "1"}},
+		{name: "legacy with a header id", content: `{"header":"1"}`, wantEntries: map[string]string{"header": "1"}},
+		{name: "version 1", content: `{"header":{"version":1},"entries":{"a":"1"}}`, wantVersion: 1,
+			wantEntries: map[string]string{"a": "1"}},
+		{name: "newer", content: `{"header":{"version":99},"entries":[]}`, wantVersion: 99, wantErr: ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			if err := os.WriteFile(fc.filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			v, err := FileVersion(fc.filename)
+			if err != nil {
+				t.Fatalf("FileVersion failed; error = %v", err)
+			}
+			if v != tt.wantVersion {
+				t.Errorf("FileVersion is %d, expected %d", v, tt.wantVersion)
+			}
+			if err = fc.Read(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read returned %v, expected %v", err, tt.wantErr)
+			}
+			for id, cs := range tt.wantEntries {
+				if got := fc.Get(id); got != cs {
+					t.Errorf("%s has %q, expected %q", id, got, cs)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveWritesCurrentVersion(t *testing.T) {
+	fc := newTestCache(t)
+	putAll(fc, "1", "a")
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	if v, err := FileVersion(fc.filename); err != nil || v != fileVersion {
+		t.Errorf("FileVersion is %d and %v, expected %d", v, err, fileVersion)
+	}
+}
+
+func TestMigrateToEntryObjects(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantDirty bool
+	}{
+		{name: "legacy", content: `{"a":"1"}`, wantDirty: true},
+		{name: "version 1", content: `{"header":{"version":1},"entries":{"a":"1"}}`, wantDirty: true},
+		{name: "current", content: `{"header":{"version":2},"entries":{"a":{"checksum":"1"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			if err := os.WriteFile(fc.filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := fc.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if fc.IsDirty() != tt.wantDirty {
+				t.Errorf("IsDirty is %t after Read, expected %t", fc.IsDirty(), tt.wantDirty)
+			}
+			if err := fc.Recompact(); err != nil {
+				t.Fatalf("Recompact failed; error = %v", err)
+			}
+			b, err := os.ReadFile(fc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			df := diskFile{}
+			if err = json.Unmarshal(b, &df); err != nil {
+				t.Fatalf("the file is not in the current format; error = %v", err)
+			}
+			if df.Header.Version != fileVersion || df.Entries["a"].Checksum != "1" {
+				t.Errorf("the file has version %d and entries %+v", df.Header.Version, df.Entries)
+			}
+		})
+	}
+}
+
+func TestEntryTimes(t *testing.T) {
+	created := time.Unix(1000, 0).UTC()
+	now := created
+	fc := newTestCache(t)
+	fc.now = func() time.Time { return now }
+	putAll(fc, "1", "a")
+	now = now.Add(time.Hour)
+	putAll(fc, "2", "a")
+	if err := fc.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+
+	c := reopen(t, fc)
+	tests := []struct {
+		name string
+		at   func(id string) (time.Time, bool)
+		want time.Time
+	}{
+		{name: "created", at: c.CreatedAt, want: created},
+		{name: "updated", at: c.UpdatedAt, want: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if at, ok := tt.at("a"); !ok || !at.Equal(tt.want) {
+				t.Errorf("a is %s at %v, expected %v", tt.name, at, tt.want)
+			}
+			if _, ok := tt.at("missing"); ok {
+				t.Errorf("a missing id has a time")
+			}
+		})
+	}
+}
+
+func TestWithReadIntegrity(t *testing.T) {
+	tests := []struct {
+		name     string
+		saveOpts []Option
+		readOpts []Option
+		tamper   bool
+		wantErr  error
+	}{
+		{name: "intact", saveOpts: []Option{WithReadIntegrity()}, readOpts: []Option{WithReadIntegrity()}},
+		{name: "tampered", saveOpts: []Option{WithReadIntegrity()}, readOpts: []Option{WithReadIntegrity()},
+			tamper: true, wantErr: ErrIntegrity},
+		{name: "not verified", saveOpts: []Option{WithReadIntegrity()}, tamper: true},
+		{name: "saved without", readOpts: []Option{WithReadIntegrity()}, tamper: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.saveOpts...)
+			putAll(fc, "1", "a", "b")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if tt.tamper {
+				b, err := os.ReadFile(fc.filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				df := diskFile{}
+				if err = json.Unmarshal(b, &df); err != nil {
+					t.Fatal(err)
+				}
+				e := df.Entries["a"]
+				e.Checksum = "tampered"
+				df.Entries["a"] = e
+				if b, err = json.Marshal(&df); err != nil {
+					t.Fatal(err)
+				}
+				if err = os.WriteFile(fc.filename, b, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := NewFileCache(fc.filename, fc.checkSum, nil, tt.readOpts...)
+			if err := c.Read(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Read returned %v, expected %v", err, tt.wantErr)
+			}
+		})
+	}
+}
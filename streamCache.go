@@ -0,0 +1,184 @@
+package pushstate
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tkandal/checksum"
+	"go.uber.org/zap"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// streamFileName is the name of the state-file of a stream cache
+const streamFileName = "-"
+
+var errStreamUnsupported = errors.New("not supported by a stream")
+
+// NewStreamCache creates a cache whose state-file is read from r and written to w, e.g. os.Stdin and
+// os.Stdout for use in a pipeline.  The lifecycle is a single read and a single write: Read consumes
+// r to its end the first time, and later reads see the content of the last read or save.  A nil r is
+// an empty state-file.  The cache is dirty until the state-file is first written to w, so the first
+// Save writes the whole state-file also when nothing changed, and a pipeline that only reads passes
+// its state on.  Every later save that writes the file writes the whole state-file to w again, so
+// Save should be called once when w is a stream, e.g. before the program exits.  Dump and WriteTo return
+// the content of the last read or save.  The options are those of NewFileCache; the state-file is
+// always written in place, see WithInPlaceWrite.
+func NewStreamCache(r io.Reader, w io.Writer, cs checksum.CheckSum, log *zap.SugaredLogger, opts ...Option) *FileCache {
+	sfs := &streamFS{r: r, w: w}
+	opts = append(opts, WithFileSystem(sfs), WithInPlaceWrite())
+	fc := NewFileCache(streamFileName, cs, log, opts...)
+	fc.cacheLock.Lock()
+	fc.isDirty = true
+	fc.cacheLock.Unlock()
+	return fc
+}
+
+// streamPending returns true when fc is a stream cache that has not written its state-file to w yet;
+// the caller must hold the lock
+func (fc *FileCache) streamPending() bool {
+	sfs, ok := fc.fs.(*streamFS)
+	if !ok {
+		return false
+	}
+	sfs.streamLock.Lock()
+	defer sfs.streamLock.Unlock()
+
+	return !sfs.written
+}
+
+// streamFS is a FileSystem with the one file of a stream cache, read from r and written to w
+type streamFS struct {
+	r       io.Reader
+	w       io.Writer
+	read    bool
+	written bool
+	content []byte
+	modTime time.Time
+	// Protect the content
+	streamLock sync.Mutex
+}
+
+// OpenFile opens the content for reading, after reading r the first time, or a file that is written to
+// w when it is closed
+func (sfs *streamFS) OpenFile(name string, flag int, _ fs.FileMode) (File, error) {
+	if name != streamFileName {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return &streamFile{sfs: sfs, buf: &bytes.Buffer{}}, nil
+	}
+
+	sfs.streamLock.Lock()
+	defer sfs.streamLock.Unlock()
+
+	if !sfs.read {
+		sfs.read = true
+		if sfs.r != nil {
+			b, err := io.ReadAll(sfs.r)
+			if err != nil {
+				return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+			}
+			sfs.content = b
+			sfs.modTime = time.Now()
+		}
+	}
+	return &streamFile{sfs: sfs, buf: bytes.NewBuffer(append([]byte(nil), sfs.content...))}, nil
+}
+
+// CreateTemp is not supported, a stream cache writes in place
+func (sfs *streamFS) CreateTemp(dir, _ string) (File, error) {
+	return nil, &fs.PathError{Op: "createtemp", Path: dir, Err: errStreamUnsupported}
+}
+
+// Rename is not supported, a stream cache writes in place
+func (sfs *streamFS) Rename(oldpath, _ string) error {
+	return &fs.PathError{Op: "rename", Path: oldpath, Err: errStreamUnsupported}
+}
+
+// Stat returns the size of the content
+func (sfs *streamFS) Stat(name string) (fs.FileInfo, error) {
+	if name != streamFileName {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	sfs.streamLock.Lock()
+	defer sfs.streamLock.Unlock()
+
+	return streamInfo{size: int64(len(sfs.content)), modTime: sfs.modTime}, nil
+}
+
+// Remove does nothing, there is nothing to remove from a stream
+func (sfs *streamFS) Remove(_ string) error {
+	return nil
+}
+
+// Chmod does nothing, a stream has no mode
+func (sfs *streamFS) Chmod(_ string, _ fs.FileMode) error {
+	return nil
+}
+
+// streamFile is the content being read, or the state-file being written
+type streamFile struct {
+	sfs     *streamFS
+	buf     *bytes.Buffer
+	writing bool
+}
+
+func (sf *streamFile) Read(p []byte) (int, error) {
+	return sf.buf.Read(p)
+}
+
+func (sf *streamFile) Write(p []byte) (int, error) {
+	sf.writing = true
+	return sf.buf.Write(p)
+}
+
+// Close writes a written state-file to w in one piece, so a failed encoding writes nothing, and
+// keeps it as the content
+func (sf *streamFile) Close() error {
+	if !sf.writing {
+		return nil
+	}
+	sfs := sf.sfs
+	sfs.streamLock.Lock()
+	defer sfs.streamLock.Unlock()
+
+	if sfs.w == nil {
+		return &fs.PathError{Op: "write", Path: streamFileName, Err: errStreamUnsupported}
+	}
+	if _, err := sfs.w.Write(sf.buf.Bytes()); err != nil {
+		return err
+	}
+	sfs.read, sfs.written = true, true
+	sfs.content = append([]byte(nil), sf.buf.Bytes()...)
+	sfs.modTime = time.Now()
+	return nil
+}
+
+func (sf *streamFile) Name() string {
+	return streamFileName
+}
+
+func (sf *streamFile) Stat() (fs.FileInfo, error) {
+	return streamInfo{size: int64(sf.buf.Len())}, nil
+}
+
+// streamInfo is the fs.FileInfo of the state-file of a stream cache
+type streamInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (si streamInfo) Name() string       { return streamFileName }
+func (si streamInfo) Size() int64        { return si.size }
+func (si streamInfo) Mode() fs.FileMode  { return 0640 }
+func (si streamInfo) ModTime() time.Time { return si.modTime }
+func (si streamInfo) IsDir() bool        { return false }
+func (si streamInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,135 @@
+package pushstate
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestStreamCache(t *testing.T) {
+	// The state-file of a cache holding a and b
+	in := &bytes.Buffer{}
+	seed := NewStreamCache(nil, in, &checksum.Murmur3CheckSum{}, nil)
+	putAll(seed, "1", "a", "b")
+	if err := seed.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	state := in.String()
+
+	tests := []struct {
+		name     string
+		r        io.Reader
+		put      []string
+		wantRead int64
+		wantSize int64
+	}{
+		{name: "no input", put: []string{"c"}, wantSize: 1},
+		{name: "empty input", r: strings.NewReader(""), put: []string{"c"}, wantSize: 1},
+		{name: "input", r: strings.NewReader(state), put: []string{"c"}, wantRead: 2, wantSize: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			fc := NewStreamCache(tt.r, out, &checksum.Murmur3CheckSum{}, nil)
+			if err := fc.Read(); err != nil {
+				t.Fatalf("Read failed; error = %v", err)
+			}
+			if fc.Size() != tt.wantRead {
+				t.Errorf("Read has %d check-sums, expected %d", fc.Size(), tt.wantRead)
+			}
+			// The reader is consumed once, a second read sees the same content
+			if err := fc.Read(); err != nil || fc.Size() != tt.wantRead {
+				t.Errorf("a second Read returned %v with %d check-sums, expected %d", err, fc.Size(), tt.wantRead)
+			}
+			putAll(fc, "1", tt.put...)
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			saved := NewStreamCache(bytes.NewReader(out.Bytes()), nil, &checksum.Murmur3CheckSum{}, nil)
+			if err := saved.Read(); err != nil {
+				t.Fatalf("Read of the output failed; error = %v", err)
+			}
+			if saved.Size() != tt.wantSize {
+				t.Errorf("the output has %d check-sums, expected %d", saved.Size(), tt.wantSize)
+			}
+			dump, err := fc.Dump()
+			if err != nil {
+				t.Fatalf("Dump failed; error = %v", err)
+			}
+			if b, _ := io.ReadAll(dump); !bytes.Equal(b, out.Bytes()) {
+				t.Errorf("Dump returned %q, expected the output %q", b, out.Bytes())
+			}
+		})
+	}
+}
+
+func TestStreamCacheWithoutWriter(t *testing.T) {
+	fc := NewStreamCache(nil, nil, &checksum.Murmur3CheckSum{}, nil)
+	putAll(fc, "1", "a")
+	if err := fc.Save(); err == nil {
+		t.Error("Save without a writer returned <nil>, expected an error")
+	}
+}
+
+func TestStreamCachePassesStateOn(t *testing.T) {
+	in := &bytes.Buffer{}
+	seed := NewStreamCache(nil, in, &checksum.Murmur3CheckSum{}, nil)
+	putAll(seed, "1", "a", "b")
+	if err := seed.Save(); err != nil {
+		t.Fatalf("Save failed; error = %v", err)
+	}
+	state := in.String()
+
+	tests := []struct {
+		name     string
+		r        io.Reader
+		read     bool
+		wantSize int64
+	}{
+		{name: "read and save", r: strings.NewReader(state), read: true, wantSize: 2},
+		{name: "save without read", r: strings.NewReader(state)},
+		{name: "no input", read: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			fc := NewStreamCache(tt.r, out, &checksum.Murmur3CheckSum{}, nil)
+			if tt.read {
+				if err := fc.Read(); err != nil {
+					t.Fatalf("Read failed; error = %v", err)
+				}
+			}
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if out.Len() == 0 {
+				t.Fatal("Save of an unchanged stream cache wrote nothing")
+			}
+			if tt.wantSize > 0 && out.String() != state {
+				t.Errorf("Save wrote %q, expected the input %q", out.String(), state)
+			}
+			saved := NewStreamCache(bytes.NewReader(out.Bytes()), nil, &checksum.Murmur3CheckSum{}, nil)
+			if err := saved.Read(); err != nil {
+				t.Fatalf("Read of the output failed; error = %v", err)
+			}
+			if saved.Size() != tt.wantSize {
+				t.Errorf("the output has %d check-sums, expected %d", saved.Size(), tt.wantSize)
+			}
+			// The state-file is written once, a second Save without changes writes nothing more
+			n := out.Len()
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if out.Len() != n {
+				t.Errorf("a second Save wrote %d more bytes, expected none", out.Len()-n)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package pushstate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/tkandal/checksum"
+	"hash"
+	"io"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// StreamCheckSum is a checksum.CheckSum that can also hash a stream of bytes.
+// The check-sum of the bytes written to a hash from NewHash must be the hex encoding of its Sum, and
+// equal to SumBytes of the same bytes.  A model is encoded into the hash without a buffer of the
+// cache's own, but encoding/json encodes the whole model before it writes, so the peak memory of a
+// check-sum is still about the size of the encoding; only a ContentReader is hashed in pieces.
+type StreamCheckSum interface {
+	checksum.CheckSum
+	NewHash() hash.Hash
+}
+
+// ContentReader is a PushModel whose check-sum is the check-sum of its content instead of its JSON
+// encoding, e.g. a model backed by a file. A reader that is an io.Closer is closed after it is read.
+type ContentReader interface {
+	Content() (io.Reader, error)
+}
+
+// contentCheckSum hashes the content of cr, streaming it into the hash when cs is a StreamCheckSum
+func contentCheckSum(cs checksum.CheckSum, cr ContentReader) (string, error) {
+	r, err := cr.Content()
+	if err != nil {
+		return "", err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer func() {
+			_ = c.Close()
+		}()
+	}
+	if scs, ok := cs.(StreamCheckSum); ok {
+		h := scs.NewHash()
+		if _, err = io.Copy(h, r); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return cs.SumBytes(b), nil
+}
+
+// SHA256StreamCheckSum is a SHA-256 StreamCheckSum, safe for concurrent use
+type SHA256StreamCheckSum struct {
+}
+
+func (h *SHA256StreamCheckSum) SumString(str string) string {
+	return h.SumBytes([]byte(str))
+}
+
+func (h *SHA256StreamCheckSum) SumBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *SHA256StreamCheckSum) NewHash() hash.Hash {
+	return sha256.New()
+}
@@ -0,0 +1,164 @@
+package pushstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// bufferedCheckSum hides NewHash, so the cache encodes into a buffer first
+type bufferedCheckSum struct {
+	checksum.CheckSum
+}
+
+// largeModel is a model with a large encoding
+type largeModel struct {
+	ID    string   `json:"id"`
+	Lines []string `json:"lines"`
+}
+
+func (m *largeModel) GetID() string {
+	return m.ID
+}
+
+func newLargeModel() *largeModel {
+	m := &largeModel{ID: "large"}
+	for i := 0; i < 10000; i++ {
+		m.Lines = append(m.Lines, strings.Repeat("x", 100))
+	}
+	return m
+}
+
+func TestStreamCheckSum(t *testing.T) {
+	tests := []struct {
+		name  string
+		model PushModel
+		opts  []Option
+	}{
+		{name: "small", model: &testModel{ID: "a", Payload: "1"}},
+		{name: "escaped", model: &testModel{ID: "a", Payload: "<&>  "}},
+		{name: "large", model: newLargeModel()},
+		{name: "stable", model: &testModel{ID: "a", Payload: "1"}, opts: []Option{WithStableChecksum()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewFileCache("", &SHA256StreamCheckSum{}, nil, tt.opts...)
+			buffered := NewFileCache("", bufferedCheckSum{&SHA256StreamCheckSum{}}, nil, tt.opts...)
+			got, err := stream.computeCheckSum(tt.model)
+			if err != nil {
+				t.Fatalf("streamed check-sum failed; error = %v", err)
+			}
+			want, err := buffered.computeCheckSum(tt.model)
+			if err != nil {
+				t.Fatalf("buffered check-sum failed; error = %v", err)
+			}
+			if got != want {
+				t.Errorf("streamed check-sum %s differs from the buffered %s", got, want)
+			}
+			if len(tt.opts) > 0 {
+				return
+			}
+			buf := &bytes.Buffer{}
+			if err = json.NewEncoder(buf).Encode(tt.model); err != nil {
+				t.Fatal(err)
+			}
+			if direct := (&SHA256StreamCheckSum{}).SumBytes(buf.Bytes()); got != direct {
+				t.Errorf("streamed check-sum %s differs from the check-sum of the encoding %s", got, direct)
+			}
+		})
+	}
+}
+
+// contentModel is a ContentReader of a fixed content
+type contentModel struct {
+	ID      string `json:"id"`
+	content string
+	err     error
+	closed  bool
+}
+
+func (m *contentModel) GetID() string {
+	return m.ID
+}
+
+func (m *contentModel) Content() (io.Reader, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &closeTracker{Reader: strings.NewReader(m.content), closed: &m.closed}, nil
+}
+
+// closeTracker records that it has been closed
+type closeTracker struct {
+	io.Reader
+	closed *bool
+}
+
+func (c *closeTracker) Close() error {
+	*c.closed = true
+	return nil
+}
+
+func TestContentReader(t *testing.T) {
+	readErr := errors.New("no content")
+	tests := []struct {
+		name    string
+		cs      checksum.CheckSum
+		model   *contentModel
+		wantErr error
+	}{
+		{name: "stream", cs: &SHA256StreamCheckSum{}, model: &contentModel{ID: "a", content: "content"}},
+		{name: "buffer", cs: bufferedCheckSum{&SHA256StreamCheckSum{}}, model: &contentModel{ID: "a", content: "content"}},
+		{name: "empty", cs: &SHA256StreamCheckSum{}, model: &contentModel{ID: "a"}},
+		{name: "error", cs: &SHA256StreamCheckSum{}, model: &contentModel{ID: "a", err: readErr}, wantErr: readErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := NewFileCache("", tt.cs, nil)
+			got, err := fc.computeCheckSum(tt.model)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("computeCheckSum returned %v, expected %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if want := tt.cs.SumString(tt.model.content); got != want {
+				t.Errorf("check-sum is %s, expected the check-sum of the content %s", got, want)
+			}
+			if !tt.model.closed {
+				t.Error("the content reader was not closed")
+			}
+		})
+	}
+}
+
+func BenchmarkCheckSumLargeModel(b *testing.B) {
+	m := newLargeModel()
+	benchmarks := []struct {
+		name string
+		cs   checksum.CheckSum
+	}{
+		{name: "stream", cs: &SHA256StreamCheckSum{}},
+		{name: "buffer", cs: bufferedCheckSum{&SHA256StreamCheckSum{}}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			fc := NewFileCache("", bm.cs, nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := fc.computeCheckSum(m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+package pushstate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithFollowSymlinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantLink bool
+	}{
+		{name: "replace the link"},
+		{name: "follow the link", opts: []Option{WithFollowSymlinks()}, wantLink: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "target", "state.json")
+			if err := os.Mkdir(filepath.Dir(target), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(target, []byte(`{"entries":{}}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			link := filepath.Join(dir, "state.json")
+			if err := os.Symlink(filepath.Join("target", "state.json"), link); err != nil {
+				t.Fatal(err)
+			}
+			fc := NewFileCache(link, &checksum.Murmur3CheckSum{}, nil, tt.opts...)
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			info, err := os.Lstat(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isLink := info.Mode()&os.ModeSymlink != 0; isLink != tt.wantLink {
+				t.Fatalf("the state-file is a symlink %t, expected %t", isLink, tt.wantLink)
+			}
+			if !tt.wantLink {
+				return
+			}
+			onTarget := NewFileCache(target, &checksum.Murmur3CheckSum{}, nil)
+			if err = onTarget.Read(); err != nil {
+				t.Fatalf("Read of the target failed; error = %v", err)
+			}
+			if onTarget.Get("a") != fc.Get("a") {
+				t.Errorf("the target has %q for a, expected %q", onTarget.Get("a"), fc.Get("a"))
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package pushstate
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// tempPrefix returns the prefix of the cache's temporary files
+func (fc *FileCache) tempPrefix() string {
+	if fc.tempFilePrefix != "" {
+		return fc.tempFilePrefix
+	}
+	return filepath.Base(fc.filename)
+}
+
+// isTempFile returns true when name is a temporary file with prefix, i.e. the prefix followed by
+// the random digits CreateTemp adds
+func isTempFile(name string, prefix string) bool {
+	suffix := strings.TrimPrefix(name, prefix)
+	if suffix == name || suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// removeStaleTemps removes the temporary files in the state-file's directory that are older than the
+// stale age; failures are logged, since they only leave garbage behind
+func (fc *FileCache) removeStaleTemps() {
+	lister, ok := fc.fs.(interface {
+		ReadDir(name string) ([]fs.DirEntry, error)
+	})
+	if !ok {
+		fc.log.Debugw("file system cannot list files, not removing stale temporary files")
+		return
+	}
+	dir, prefix := filepath.Dir(fc.filename), fc.tempPrefix()
+	entries, err := lister.ReadDir(dir)
+	if err != nil {
+		fc.warnw(fc.log, "list stale temporary files failed", "dir", dir, "error", err)
+		return
+	}
+	now := fc.now()
+	for _, e := range entries {
+		if e.IsDir() || !isTempFile(e.Name(), prefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || now.Sub(info.ModTime()) < fc.staleTempAge {
+			continue
+		}
+		name := filepath.Join(dir, e.Name())
+		if err = fc.fs.Remove(name); err != nil {
+			fc.warnw(fc.log, "remove stale temporary file failed", "file", name, "error", err)
+			continue
+		}
+		fc.log.Debugw("removed stale temporary file", "file", name)
+	}
+}
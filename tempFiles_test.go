@@ -0,0 +1,110 @@
+package pushstate
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tkandal/checksum"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// patternFileSystem records the patterns of the temporary files created
+type patternFileSystem struct {
+	OSFileSystem
+	patterns []string
+}
+
+func (p *patternFileSystem) CreateTemp(dir, pattern string) (File, error) {
+	p.patterns = append(p.patterns, pattern)
+	return p.OSFileSystem.CreateTemp(dir, pattern)
+}
+
+func TestWithTempPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{name: "default", want: "state.json"},
+		{name: "prefix", opts: []Option{WithTempPrefix("cache-a.")}, want: "cache-a."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pfs := &patternFileSystem{}
+			filename := filepath.Join(t.TempDir(), "state.json")
+			fc := NewFileCache(filename, &checksum.Murmur3CheckSum{}, nil, append(tt.opts, WithFileSystem(pfs))...)
+			putAll(fc, "1", "a")
+			if err := fc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			if len(pfs.patterns) == 0 {
+				t.Fatal("Save created no temporary file")
+			}
+			for _, p := range pfs.patterns {
+				if !strings.HasPrefix(p, tt.want) {
+					t.Errorf("temporary file pattern is %q, expected the prefix %q", p, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithStaleTempCleanup(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	files := []struct {
+		name string
+		old  bool
+	}{
+		{name: "cache-a.123", old: true},
+		{name: "cache-a.456"},
+		{name: "cache-b.789", old: true},
+		{name: "cache-a.notdigits", old: true},
+		{name: "state.json", old: true},
+	}
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{name: "no cleanup", opts: []Option{WithTempPrefix("cache-a.")},
+			want: []string{"cache-a.123", "cache-a.456", "cache-a.notdigits", "cache-b.789", "state.json"}},
+		{name: "cleanup", opts: []Option{WithTempPrefix("cache-a."), WithStaleTempCleanup(time.Hour)},
+			want: []string{"cache-a.456", "cache-a.notdigits", "cache-b.789", "state.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range files {
+				name := filepath.Join(dir, f.name)
+				if err := os.WriteFile(name, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if f.old {
+					if err := os.Chtimes(name, old, old); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			NewFileCache(filepath.Join(dir, "state.json"), &checksum.Murmur3CheckSum{}, nil, tt.opts...)
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("files are %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package pushstate
+
+import (
+	"sort"
+	"strings"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// tenantPrefix is the prefix of the sections of tenants, so they do not collide with other sections
+const tenantPrefix = "tenant:"
+
+// TenantCache keeps the check-sums of each tenant apart in one FileCache, so ids collide freely across
+// tenants and resetting one tenant leaves the others intact.  A tenant is a section of the cache, see
+// Section, and the tenants are read and saved together with the rest of the file.
+type TenantCache struct {
+	fc *FileCache
+}
+
+// NewTenantCache keeps tenants in fc
+func NewTenantCache(fc *FileCache) *TenantCache {
+	return &TenantCache{fc: fc}
+}
+
+// Tenant returns the Cacher of the tenant
+func (tc *TenantCache) Tenant(tenant string) Cacher {
+	return tc.fc.Section(tenantPrefix + tenant)
+}
+
+// IsChanged checks if the model is new or changed for the tenant
+func (tc *TenantCache) IsChanged(tenant string, m PushModel) bool {
+	return tc.Tenant(tenant).IsChanged(m)
+}
+
+// Put puts the model's check-sum for the tenant
+func (tc *TenantCache) Put(tenant string, m PushModel) {
+	tc.Tenant(tenant).Put(m)
+}
+
+// Get returns the check-sum for the given id of the tenant
+func (tc *TenantCache) Get(tenant string, id string) string {
+	return tc.Tenant(tenant).Get(id)
+}
+
+// Delete deletes the check-sum for the given id of the tenant and saves the file
+func (tc *TenantCache) Delete(tenant string, id string) error {
+	return tc.Tenant(tenant).Delete(id)
+}
+
+// ResetTenant deletes all the check-sums of the tenant and saves the file
+func (tc *TenantCache) ResetTenant(tenant string) error {
+	return tc.Tenant(tenant).Reset()
+}
+
+// Size returns the number of check-sums of the tenant
+func (tc *TenantCache) Size(tenant string) int64 {
+	return tc.Tenant(tenant).Size()
+}
+
+// TotalSize returns the number of check-sums of all the tenants
+func (tc *TenantCache) TotalSize() int64 {
+	tc.fc.cacheLock.Lock()
+	defer tc.fc.cacheLock.Unlock()
+
+	var n int64
+	for name, entries := range tc.fc.sections {
+		if strings.HasPrefix(name, tenantPrefix) {
+			n += int64(len(entries))
+		}
+	}
+	return n
+}
+
+// Tenants returns the tenants that have check-sums, sorted
+func (tc *TenantCache) Tenants() []string {
+	tc.fc.cacheLock.Lock()
+	defer tc.fc.cacheLock.Unlock()
+
+	tenants := []string{}
+	for name, entries := range tc.fc.sections {
+		if strings.HasPrefix(name, tenantPrefix) && len(entries) > 0 {
+			tenants = append(tenants, strings.TrimPrefix(name, tenantPrefix))
+		}
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Read reads the whole file, including all the tenants
+func (tc *TenantCache) Read() error {
+	return tc.fc.Read()
+}
+
+// Save saves the whole file, including all the tenants
+func (tc *TenantCache) Save() error {
+	return tc.fc.Save()
+}
@@ -0,0 +1,67 @@
+package pushstate
+
+import (
+	"reflect"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestTenantCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		reset     string
+		wantSizeA int64
+		wantSizeB int64
+		wantTotal int64
+		wantNames []string
+	}{
+		{name: "no reset", wantSizeA: 2, wantSizeB: 1, wantTotal: 3, wantNames: []string{"a", "b"}},
+		{name: "reset a", reset: "a", wantSizeB: 1, wantTotal: 1, wantNames: []string{"b"}},
+		{name: "reset b", reset: "b", wantSizeA: 2, wantTotal: 2, wantNames: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t)
+			tc := NewTenantCache(fc)
+			tc.Put("a", &testModel{ID: "x", Payload: "1"})
+			tc.Put("a", &testModel{ID: "y", Payload: "1"})
+			tc.Put("b", &testModel{ID: "x", Payload: "2"})
+			putAll(fc, "1", "x")
+			if tc.Get("a", "x") == tc.Get("b", "x") {
+				t.Error("the same id of two tenants has the same check-sum")
+			}
+			if tt.reset != "" {
+				if err := tc.ResetTenant(tt.reset); err != nil {
+					t.Fatalf("ResetTenant failed; error = %v", err)
+				}
+			}
+			if err := tc.Save(); err != nil {
+				t.Fatalf("Save failed; error = %v", err)
+			}
+			fc = reopen(t, fc)
+			tc = NewTenantCache(fc)
+			if got := tc.Size("a"); got != tt.wantSizeA {
+				t.Errorf("Size(a) returned %d, expected %d", got, tt.wantSizeA)
+			}
+			if got := tc.Size("b"); got != tt.wantSizeB {
+				t.Errorf("Size(b) returned %d, expected %d", got, tt.wantSizeB)
+			}
+			if got := tc.TotalSize(); got != tt.wantTotal {
+				t.Errorf("TotalSize returned %d, expected %d", got, tt.wantTotal)
+			}
+			if got := tc.Tenants(); !reflect.DeepEqual(got, tt.wantNames) {
+				t.Errorf("Tenants returned %v, expected %v", got, tt.wantNames)
+			}
+			want := tt.reset == "b"
+			if got := tc.IsChanged("b", &testModel{ID: "x", Payload: "2"}); got != want {
+				t.Errorf("IsChanged(b) returned %t, expected %t", got, want)
+			}
+			if fc.Size() != 1 {
+				t.Errorf("the tenants touched the entries of the cache itself, Size is %d", fc.Size())
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package pushstate
+
+import (
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// logThrottle collapses identical warnings within a window into one line, and counts the rest
+type logThrottle struct {
+	window   time.Duration
+	messages map[string]*throttled
+	// Protect the messages
+	throttleLock sync.Mutex
+}
+
+type throttled struct {
+	start      time.Time
+	suppressed int
+	// Logs the suppressed count when the window ends without another warning, with the logger and
+	// fields of the last suppressed one
+	flush         *time.Timer
+	log           *zap.SugaredLogger
+	keysAndValues []interface{}
+}
+
+// allow returns true when msg should be logged now, and the number of times it was suppressed
+// since it was last logged
+func (t *logThrottle) allow(log *zap.SugaredLogger, now time.Time, msg string, keysAndValues []interface{}) (bool, int) {
+	t.throttleLock.Lock()
+	defer t.throttleLock.Unlock()
+
+	m, ok := t.messages[msg]
+	if ok && now.Sub(m.start) < t.window {
+		m.suppressed++
+		m.log, m.keysAndValues = log, keysAndValues
+		if m.flush == nil {
+			m.flush = time.AfterFunc(m.start.Add(t.window).Sub(now), func() { t.flush(msg, m) })
+		}
+		return false, 0
+	}
+	suppressed := 0
+	if ok {
+		suppressed = m.suppressed
+		if m.flush != nil {
+			m.flush.Stop()
+		}
+	}
+	t.messages[msg] = &throttled{start: now}
+	return true, suppressed
+}
+
+// flush logs the number of times msg was suppressed in the window of m, when the window ended
+// without another warning to carry it
+func (t *logThrottle) flush(msg string, m *throttled) {
+	t.throttleLock.Lock()
+	if t.messages[msg] != m || m.suppressed == 0 {
+		// Already carried by a later warning
+		t.throttleLock.Unlock()
+		return
+	}
+	delete(t.messages, msg)
+	t.throttleLock.Unlock()
+
+	m.log.Warnw(msg, append(m.keysAndValues, "repeated", m.suppressed)...)
+}
+
+// warnw logs a warning with log, unless an identical warning was logged within the window of t;
+// a nil t logs every warning.  The suppressed warnings are counted as "repeated", on the first
+// warning after the window, or on a line of its own when the window ends without one.
+func (t *logThrottle) warnw(log *zap.SugaredLogger, now time.Time, msg string, keysAndValues ...interface{}) {
+	if t == nil {
+		log.Warnw(msg, keysAndValues...)
+		return
+	}
+	ok, suppressed := t.allow(log, now, msg, keysAndValues)
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		keysAndValues = append(keysAndValues, "repeated", suppressed)
+	}
+	log.Warnw(msg, keysAndValues...)
+}
+
+// warnw logs a warning with log, throttled by WithLogThrottle
+func (fc *FileCache) warnw(log *zap.SugaredLogger, msg string, keysAndValues ...interface{}) {
+	fc.throttle.warnw(log, fc.now(), msg, keysAndValues...)
+}
@@ -0,0 +1,82 @@
+package pushstate
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithLogThrottle(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []Option
+		wantLogged   int
+		wantRepeated int64
+	}{
+		{name: "not throttled", wantLogged: 4},
+		{name: "throttled", opts: []Option{WithLogThrottle(time.Minute)}, wantLogged: 2, wantRepeated: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			now := time.Unix(1000, 0)
+			fc := newTestCache(t, tt.opts...)
+			fc.SetLogger(zap.New(core).Sugar())
+			fc.now = func() time.Time { return now }
+
+			// Three warnings within the window, and one after it
+			for i := 0; i < 3; i++ {
+				fc.warnw(fc.log, "warning", "i", i)
+			}
+			now = now.Add(2 * time.Minute)
+			fc.warnw(fc.log, "warning", "i", 3)
+
+			entries := logs.All()
+			if len(entries) != tt.wantLogged {
+				t.Fatalf("logged %d warnings, expected %d", len(entries), tt.wantLogged)
+			}
+			last := entries[len(entries)-1].ContextMap()
+			if repeated, _ := last["repeated"].(int64); repeated != tt.wantRepeated {
+				t.Errorf("the last warning has repeated %v, expected %d", last["repeated"], tt.wantRepeated)
+			}
+		})
+	}
+}
+
+func TestWithLogThrottleFlush(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	fc := newTestCache(t, WithLogThrottle(20*time.Millisecond))
+	fc.SetLogger(zap.New(core).Sugar())
+
+	// A burst of three warnings, and then silence
+	for i := 0; i < 3; i++ {
+		fc.warnw(fc.logger(), "warning", "i", i)
+	}
+	deadline := time.Now().Add(time.Second)
+	for logs.Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("logged %d warnings, expected the first and the count of the rest", len(entries))
+	}
+	last := entries[1].ContextMap()
+	if repeated, _ := last["repeated"].(int64); repeated != 2 {
+		t.Errorf("the count has repeated %v, expected 2", last["repeated"])
+	}
+
+	// The next warning starts a new window, and carries no count
+	fc.warnw(fc.logger(), "warning", "i", 3)
+	if n := logs.Len(); n != 3 {
+		t.Fatalf("logged %d warnings, expected 3", n)
+	}
+	if _, ok := logs.All()[2].ContextMap()["repeated"]; ok {
+		t.Error("the warning after the count carries a count too")
+	}
+}
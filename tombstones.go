@@ -0,0 +1,71 @@
+package pushstate
+
+import (
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// bury keeps a tombstone for the deleted id, when WithTombstones is set; the caller must hold the lock
+func (fc *FileCache) bury(id string) {
+	if fc.tombstoneTTL <= 0 {
+		return
+	}
+	if fc.tombstones == nil {
+		fc.tombstones = map[string]time.Time{}
+	}
+	fc.tombstones[id] = fc.now()
+}
+
+// isTombstone returns true when id has a tombstone that has not expired; the caller must hold the lock
+func (fc *FileCache) isTombstone(id string) bool {
+	deleted, ok := fc.tombstones[id]
+	if !ok {
+		return false
+	}
+	// A tombstone from the future, after the clock went backwards, is kept for a TTL from now
+	now := fc.now()
+	age, skewed := fc.age(deleted, now)
+	if skewed {
+		fc.tombstones[id] = now
+	}
+	if age >= fc.tombstoneTTL {
+		delete(fc.tombstones, id)
+		return false
+	}
+	return true
+}
+
+// sweepTombstones removes the expired tombstones; the caller must hold the lock
+func (fc *FileCache) sweepTombstones() {
+	for id := range fc.tombstones {
+		fc.isTombstone(id)
+	}
+}
+
+// tombstoneEntries returns the tombstones to keep in the state-file, or nil when there are none
+func (fc *FileCache) tombstoneEntries() map[string]time.Time {
+	if len(fc.tombstones) == 0 {
+		return nil
+	}
+	return fc.tombstones
+}
+
+// IsTombstone returns true when the id was deleted less than the TTL of WithTombstones ago
+func (fc *FileCache) IsTombstone(id string) bool {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	return fc.isTombstone(fc.key(id))
+}
+
+// Tombstones returns the ids that were deleted less than the TTL of WithTombstones ago, sorted
+func (fc *FileCache) Tombstones() []string {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	fc.sweepTombstones()
+	return sortedKeys(fc.tombstones)
+}
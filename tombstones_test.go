@@ -0,0 +1,117 @@
+package pushstate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithTombstones(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		after      time.Duration
+		put        bool
+		wantTomb   bool
+		wantReason ChangeReason
+	}{
+		{name: "disabled", wantReason: ReasonNew},
+		{name: "within the ttl", ttl: time.Hour, after: time.Minute,
+			wantTomb: true, wantReason: ReasonTombstone},
+		{name: "expired", ttl: time.Hour, after: 2 * time.Hour, wantReason: ReasonNew},
+		{name: "put again", ttl: time.Hour, after: time.Minute, put: true,
+			wantReason: ReasonUnchanged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			fc := newTestCache(t, WithTombstones(tt.ttl))
+			fc.now = func() time.Time { return now }
+			putAll(fc, "1", "a", "b")
+			if err := fc.Delete("a"); err != nil {
+				t.Fatalf("Delete failed; error = %v", err)
+			}
+			if got, want := fc.IsTombstone("a"), tt.ttl > 0; got != want {
+				t.Errorf("IsTombstone right after Delete returned %t, expected %t", got, want)
+			}
+			now = now.Add(tt.after)
+			if tt.put {
+				putAll(fc, "1", "a")
+			}
+			if got := fc.IsTombstone("a"); got != tt.wantTomb {
+				t.Errorf("IsTombstone returned %t, expected %t", got, tt.wantTomb)
+			}
+			if _, reason := fc.ChangeStatus(&testModel{ID: "a", Payload: "1"}); reason != tt.wantReason {
+				t.Errorf("ChangeStatus returned %v, expected %v", reason, tt.wantReason)
+			}
+			want := []string{}
+			if tt.wantTomb {
+				want = []string{"a"}
+			}
+			if got := fc.Tombstones(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Tombstones returned %v, expected %v", got, want)
+			}
+			if fc.Get("b") == "" {
+				t.Error("the tombstone removed the check-sum of another id")
+			}
+		})
+	}
+}
+
+func TestTombstonesAreSaved(t *testing.T) {
+	fc := newTestCache(t, WithTombstones(time.Hour))
+	putAll(fc, "1", "a")
+	if err := fc.Delete("a"); err != nil {
+		t.Fatalf("Delete failed; error = %v", err)
+	}
+	if got := reopen(t, fc, WithTombstones(time.Hour)).Tombstones(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("Tombstones after a read returned %v, expected [a]", got)
+	}
+	if got := reopen(t, fc).Tombstones(); len(got) != 0 {
+		t.Errorf("Tombstones after a read without WithTombstones returned %v, expected none", got)
+	}
+}
+
+func TestTombstonesOfDeletes(t *testing.T) {
+	tests := []struct {
+		name     string
+		del      func(fc *FileCache) error
+		wantTomb []string
+	}{
+		{name: "Delete", del: func(fc *FileCache) error { return fc.Delete("a") }, wantTomb: []string{"a"}},
+		{name: "WithLock", del: func(fc *FileCache) error {
+			fc.WithLock(func(la LockedAccess) { la.Delete("a") })
+			return nil
+		}, wantTomb: []string{"a"}},
+		{name: "Update", del: func(fc *FileCache) error {
+			return fc.Update("a", func(string, bool) (string, bool) { return "", false })
+		}, wantTomb: []string{"a"}},
+		{name: "Rename", del: func(fc *FileCache) error { return fc.Rename("a", "c") }, wantTomb: []string{"a"}},
+		{name: "PurgeInvalid", del: func(fc *FileCache) error {
+			fc.PutRaw("a", "")
+			_, err := fc.PurgeInvalid()
+			return err
+		}, wantTomb: []string{"a"}},
+		{name: "GarbageCollect", del: func(fc *FileCache) error {
+			_, err := fc.GarbageCollect([]string{"b"})
+			return err
+		}, wantTomb: []string{"a"}},
+		{name: "Reset", del: func(fc *FileCache) error { return fc.Reset() }, wantTomb: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, WithTombstones(time.Hour))
+			putAll(fc, "1", "a", "b")
+			if err := tt.del(fc); err != nil {
+				t.Fatalf("%s failed; error = %v", tt.name, err)
+			}
+			if got := fc.Tombstones(); !reflect.DeepEqual(got, tt.wantTomb) {
+				t.Errorf("Tombstones returned %v, expected %v", got, tt.wantTomb)
+			}
+		})
+	}
+}
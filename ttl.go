@@ -0,0 +1,62 @@
+package pushstate
+
+import (
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// age returns how long ago t was, and true when t is in the future, which means the wall clock
+// has gone backwards since t was taken. The age is then 0. A zero t has age 0 and is not skewed.
+// The caller must hold the lock.
+func (fc *FileCache) age(t time.Time, now time.Time) (time.Duration, bool) {
+	if t.IsZero() {
+		return 0, false
+	}
+	d := now.Sub(t)
+	if d < 0 {
+		fc.warnw(fc.log, "clock skew, a timestamp is in the future", "skew", -d)
+		return 0, true
+	}
+	return d, false
+}
+
+// expired returns true when the check-sum of id is older than the entry TTL. An entry from the
+// future, after the clock went backwards, gets the current time as its timestamp, so it expires a
+// TTL from now instead of after the clock catches up; an entry without a timestamp never expires.
+// The caller must hold the lock.
+func (fc *FileCache) expired(id string) bool {
+	if fc.entryTTL <= 0 {
+		return false
+	}
+	meta := fc.entries.meta(id)
+	updated := meta.UpdatedAt
+	if updated.IsZero() {
+		return false
+	}
+	now := fc.now()
+	age, skewed := fc.age(updated, now)
+	if skewed {
+		meta.UpdatedAt = now
+		fc.entries.setMeta(id, meta)
+	}
+	return age >= fc.entryTTL
+}
+
+// StaleEntries returns the ids, sorted, whose check-sum was last updated before olderThan. Nothing is
+// deleted, and ids without a timestamp, such as those read from a file without timestamps, are left out.
+func (fc *FileCache) StaleEntries(olderThan time.Time) []string {
+	fc.cacheLock.Lock()
+	defer fc.cacheLock.Unlock()
+
+	stale := []string{}
+	for _, id := range fc.entries.ids() {
+		updated := fc.entries.meta(id).UpdatedAt
+		if !updated.IsZero() && updated.Before(olderThan) {
+			stale = append(stale, id)
+		}
+	}
+	return stale
+}
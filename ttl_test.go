@@ -0,0 +1,93 @@
+package pushstate
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestWithEntryTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       []time.Duration
+		refresh     bool
+		wantChanged []bool
+		wantReason  ChangeReason
+	}{
+		{name: "fresh", steps: []time.Duration{time.Minute}, wantChanged: []bool{false}},
+		{name: "expired", steps: []time.Duration{time.Hour}, wantChanged: []bool{true}, wantReason: ReasonExpired},
+		{name: "refreshed", steps: []time.Duration{50 * time.Minute, 50 * time.Minute}, refresh: true,
+			wantChanged: []bool{false, false}},
+		// The clock goes back a day, and the entry expires an hour after that instead of a day later
+		{name: "clock backwards", steps: []time.Duration{-24 * time.Hour, 30 * time.Minute, 40 * time.Minute},
+			wantChanged: []bool{false, false, true}, wantReason: ReasonExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(100000, 0)
+			fc := newTestCache(t, WithEntryTTL(time.Hour))
+			fc.now = func() time.Time { return now }
+			m := &testModel{ID: "a", Payload: "1"}
+			fc.Put(m)
+
+			var reason ChangeReason
+			for i, d := range tt.steps {
+				now = now.Add(d)
+				var changed bool
+				changed, reason = fc.ChangeStatus(m)
+				if changed != tt.wantChanged[i] {
+					t.Errorf("step %d is changed %t, expected %t", i, changed, tt.wantChanged[i])
+				}
+				if tt.refresh {
+					fc.Put(m)
+				}
+			}
+			if reason != tt.wantReason {
+				t.Errorf("the last reason is %v, expected %v", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestStaleEntries(t *testing.T) {
+	start := time.Unix(100000, 0)
+	tests := []struct {
+		name      string
+		olderThan time.Time
+		want      []string
+	}{
+		{name: "none", olderThan: start},
+		{name: "some", olderThan: start.Add(90 * time.Second), want: []string{"a", "b"}},
+		{name: "all", olderThan: start.Add(time.Hour), want: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			fc := newTestCache(t)
+			fc.now = func() time.Time { return now }
+			for _, id := range []string{"b", "a", "c"} {
+				putAll(fc, "1", id)
+				now = now.Add(time.Minute)
+			}
+			// Read from a file without timestamps
+			fc.PutRaw("legacy", "x")
+			fc.entries.setMeta("legacy", entryMeta{})
+
+			stale := fc.StaleEntries(tt.olderThan)
+			if len(stale) != len(tt.want) {
+				t.Fatalf("StaleEntries returned %v, expected %v", stale, tt.want)
+			}
+			for i, id := range stale {
+				if id != tt.want[i] {
+					t.Errorf("stale %d is %s, expected %s", i, id, tt.want[i])
+				}
+			}
+			if fc.Size() != 4 {
+				t.Error("StaleEntries deleted entries")
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+//go:build go1.21
+
+package pushstate
+
+import (
+	"errors"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// isUnsupported returns true when err means the filesystem does not support the operation at all,
+// e.g. chmod on a filesystem without permissions
+func isUnsupported(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported)
+}
@@ -0,0 +1,19 @@
+//go:build !go1.21
+
+package pushstate
+
+import (
+	"errors"
+	"syscall"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// isUnsupported returns true when err means the filesystem does not support the operation at all,
+// e.g. chmod on a filesystem without permissions; the errors are the ones that match
+// errors.ErrUnsupported from Go 1.21
+func isUnsupported(err error) bool {
+	return errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS)
+}
@@ -0,0 +1,44 @@
+package pushstate
+
+import (
+	"fmt"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+// ValidateFile checks that the state-file at path can be read by a cache with the options, e.g.
+// before replacing the live state-file with it.  The file is decoded as Read decodes it, and its
+// version, integrity and key transform are checked against the options; the first problem is
+// returned as a *CacheError, and nil when Read would succeed.  Unlike Read, a missing file is an
+// error.  Only the options that concern reading matter, e.g. WithFileSystem, WithReadIntegrity and
+// WithKeyTransform, and no cache is created, so nothing else is read, written or removed.
+func ValidateFile(path string, opts ...Option) error {
+	fc := &FileCache{filename: path, fs: OSFileSystem{}}
+	for _, opt := range opts {
+		opt(fc)
+	}
+	if _, err := fc.fs.Stat(path); err != nil {
+		return &CacheError{Op: "validate", Path: path, Err: err}
+	}
+	sf, _, err := readFile(fc.fs, path)
+	if err != nil {
+		return err
+	}
+	return fc.checkHeader(sf, path)
+}
+
+// checkHeader checks that the header of sf, read from path, is compatible with the cache, which is
+// the check made by Read after a successful decode
+func (fc *FileCache) checkHeader(sf *stateFile, path string) error {
+	if fc.readIntegrity && sf.Header.Integrity != "" && sf.integrity() != sf.Header.Integrity {
+		return &CacheError{Op: "verify", Path: path, Err: ErrIntegrity}
+	}
+	// Keys stored with another transform would never be found, and be pushed again
+	if sf.Header.KeyTransform != fc.keyTransformName && sf.size() > 0 {
+		return &CacheError{Op: "read", Path: path, Err: fmt.Errorf("%w; file has %q, expected %q",
+			ErrKeyTransformMismatch, sf.Header.KeyTransform, fc.keyTransformName)}
+	}
+	return nil
+}
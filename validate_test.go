@@ -0,0 +1,68 @@
+package pushstate
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+/*
+ * Copyright (c) 2019 Norwegian University of Science and Technology
+ */
+
+func TestValidateFile(t *testing.T) {
+	upper := WithKeyTransform("upper", strings.ToUpper)
+	tests := []struct {
+		name         string
+		saveOpts     []Option
+		content      string
+		missing      bool
+		validateOpts []Option
+		wantErr      error
+		wantOp       string
+	}{
+		{name: "valid"},
+		{name: "compressed", saveOpts: []Option{WithCompression()}},
+		{name: "binary", saveOpts: []Option{WithBinaryFormat()}},
+		{name: "same key transform", saveOpts: []Option{upper}, validateOpts: []Option{upper}},
+		{name: "other key transform", saveOpts: []Option{upper}, wantErr: ErrKeyTransformMismatch, wantOp: "read"},
+		{name: "corrupt", content: `{"header":{"version":2},"entries":{"a":`, wantOp: "decode"},
+		{name: "newer version", content: `{"header":{"version":99},"entries":{}}`, wantErr: ErrUnsupportedVersion},
+		{name: "missing", missing: true, wantErr: fs.ErrNotExist, wantOp: "validate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCache(t, tt.saveOpts...)
+			switch {
+			case tt.missing:
+			case tt.content != "":
+				if err := os.WriteFile(fc.filename, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			default:
+				putAll(fc, "1", "a", "b")
+				if err := fc.Save(); err != nil {
+					t.Fatalf("Save failed; error = %v", err)
+				}
+			}
+			before, _ := os.ReadFile(fc.filename)
+			err := ValidateFile(fc.filename, tt.validateOpts...)
+			wantFail := tt.wantErr != nil || tt.wantOp != ""
+			if (err != nil) != wantFail || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("ValidateFile returned %v, expected %v", err, tt.wantErr)
+			}
+			var ce *CacheError
+			if wantFail && !errors.As(err, &ce) {
+				t.Errorf("ValidateFile returned %v, expected a *CacheError", err)
+			}
+			if tt.wantOp != "" && ce != nil && ce.Op != tt.wantOp {
+				t.Errorf("the *CacheError has Op %q, expected %q", ce.Op, tt.wantOp)
+			}
+			if after, _ := os.ReadFile(fc.filename); string(after) != string(before) {
+				t.Error("ValidateFile changed the file")
+			}
+		})
+	}
+}